@@ -3,12 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
-	"git.wntrmute.dev/kyle/goutils/die"
 	"git.wntrmute.dev/kyle/goutils/log"
 	"os"
 
 	"github.com/kisom/codecrafters/git-go/git"
 	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/trace2"
 )
 
 // Usage: your_git.sh <command> <arg1> <arg2> ...
@@ -16,6 +17,8 @@ func main() {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
 	// fmt.Println("Logs from your program will appear here!")
 
+	trace2.Start(pack.Agent, os.Args)
+
 	opts := log.DefaultOptions("mygit", false)
 	flag.StringVar(&opts.Level, "l", "info", "log level")
 	flag.Parse()
@@ -23,11 +26,12 @@ func main() {
 
 	args := flag.Args()
 
-	if len(args) < 2 {
+	if len(args) < 1 {
 		fmt.Fprintf(os.Stderr, "usage: mygit <command> [<args>...]\n")
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
+	trace2.CmdName(args[0])
 	switch command := args[0]; command {
 	case "cat-file":
 		objects.CatFile(args[1:])
@@ -35,34 +39,57 @@ func main() {
 		git.Clone(args[1:])
 	case "commit-tree":
 		objects.CommitTree(args[1:])
+	case "credential":
+		git.Credential(args[1:])
+	case "credential-cache":
+		git.CredentialCache(args[1:])
+	case "credential-cache--daemon":
+		git.CredentialCacheDaemon(args[1:])
+	case "credential-store":
+		git.CredentialStore(args[1:])
+	case "daemon":
+		git.Daemon(args[1:])
+	case "fetch":
+		git.Fetch(args[1:])
 	case "hash-object":
 		objects.Hash(args[1:])
 	case "ls-tree":
 		if len(args) < 3 {
 			fmt.Fprintf(os.Stderr, "usage: mygit <command> [<args>...]\n")
-			os.Exit(1)
+			trace2.ExitProcess(1)
 		}
 		objects.ListTree(args[1:])
+	case "pack-objects":
+		git.PackObjects(args[1:])
+	case "push":
+		git.Push(args[1:])
+	case "receive-pack":
+		git.ReceivePack(args[1:])
+	case "serve":
+		git.Serve(args[1:])
+	case "update-server-info":
+		git.UpdateServerInfo(args[1:])
+	case "upload-pack":
+		git.UploadPack(args[1:])
 	case "write-tree":
-		hash, err := git.WriteTree()
-		die.If(err)
+		store, err := objects.RepositoryStore()
+		trace2.DieIf(err)
+
+		hash, err := git.WriteTree(store)
+		trace2.DieIf(err)
 		fmt.Println(hash)
+	case "index-pack":
+		git.IndexPack(args[1:])
 	case "init":
-		for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating directory: %s\n", err)
-			}
-		}
-
-		headFileContents := []byte("ref: refs/heads/master\n")
-		if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing file: %s\n", err)
-		}
+		_, err := git.InitRepository(".")
+		trace2.DieIf(err)
 
 		fmt.Println("Initialized git directory")
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
+
+	trace2.Exit(0)
 }
@@ -0,0 +1,445 @@
+// Package config reads and writes git configuration files.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type entry struct {
+	key   string
+	value string
+}
+
+type section struct {
+	name       string
+	subsection string
+	entries    []*entry
+}
+
+func (s *section) matches(name, subsection string) bool {
+	return strings.EqualFold(s.name, name) && s.subsection == subsection
+}
+
+func (s *section) header() string {
+	if s.subsection == "" {
+		return fmt.Sprintf("[%s]", s.name)
+	}
+
+	subsection := strings.ReplaceAll(s.subsection, `\`, `\\`)
+	subsection = strings.ReplaceAll(subsection, `"`, `\"`)
+	return fmt.Sprintf("[%s \"%s\"]", s.name, subsection)
+}
+
+// Config is a parsed configuration file. Keys are named the way git names
+// them: "section.key" or "section.subsection.key". Section and key names
+// are case-insensitive; subsection names are not.
+type Config struct {
+	sections []*section
+}
+
+func New() *Config {
+	return &Config{}
+}
+
+// splitKey breaks "remote.origin.url" into ("remote", "origin", "url").
+func splitKey(key string) (string, string, string, error) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", fmt.Errorf("config: invalid key %q", key)
+	}
+
+	subsection := ""
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+
+	return strings.ToLower(key[:first]), subsection, strings.ToLower(key[last+1:]), nil
+}
+
+func (cfg *Config) section(name, subsection string, create bool) *section {
+	for _, s := range cfg.sections {
+		if s.matches(name, subsection) {
+			return s
+		}
+	}
+
+	if !create {
+		return nil
+	}
+
+	s := &section{name: name, subsection: subsection}
+	cfg.sections = append(cfg.sections, s)
+	return s
+}
+
+// GetAll returns every value set for key, in the order they appear.
+func (cfg *Config) GetAll(key string) []string {
+	name, subsection, key, err := splitKey(key)
+	if err != nil {
+		return nil
+	}
+
+	var values []string
+	for _, s := range cfg.sections {
+		if !s.matches(name, subsection) {
+			continue
+		}
+
+		for _, e := range s.entries {
+			if e.key == key {
+				values = append(values, e.value)
+			}
+		}
+	}
+
+	return values
+}
+
+// Get returns the last value set for key, and whether it was set at all.
+func (cfg *Config) Get(key string) (string, bool) {
+	values := cfg.GetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return values[len(values)-1], true
+}
+
+func (cfg *Config) GetString(key, defaultValue string) string {
+	value, ok := cfg.Get(key)
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+func (cfg *Config) GetBool(key string, defaultValue bool) (bool, error) {
+	value, ok := cfg.Get(key)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("config: %s has invalid boolean value %q", key, value)
+}
+
+// GetInt parses an integer value, which may have a k, m or g suffix.
+func (cfg *Config) GetInt(key string, defaultValue int64) (int64, error) {
+	value, ok := cfg.Get(key)
+	if !ok || value == "" {
+		return defaultValue, nil
+	}
+
+	multiplier := int64(1)
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "config: invalid integer for "+key)
+	}
+
+	return n * multiplier, nil
+}
+
+// Set replaces every value for key with a single value.
+func (cfg *Config) Set(key, value string) error {
+	name, subsection, key, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+
+	s := cfg.section(name, subsection, true)
+	for i := 0; i < len(s.entries); i++ {
+		if s.entries[i].key == key {
+			s.entries[i].value = value
+			s.entries = append(s.entries[:i+1], removeKey(s.entries[i+1:], key)...)
+			return nil
+		}
+	}
+
+	s.entries = append(s.entries, &entry{key: key, value: value})
+	return nil
+}
+
+func removeKey(entries []*entry, key string) []*entry {
+	var kept []*entry
+	for _, e := range entries {
+		if e.key != key {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// Add appends a value for key without replacing existing ones.
+func (cfg *Config) Add(key, value string) error {
+	name, subsection, key, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+
+	s := cfg.section(name, subsection, true)
+	s.entries = append(s.entries, &entry{key: key, value: value})
+	return nil
+}
+
+func (cfg *Config) Unset(key string) error {
+	name, subsection, key, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range cfg.sections {
+		if s.matches(name, subsection) {
+			s.entries = removeKey(s.entries, key)
+		}
+	}
+	return nil
+}
+
+// Subsections lists the subsections of a section, such as the names of all
+// configured remotes.
+func (cfg *Config) Subsections(name string) []string {
+	var subsections []string
+	seen := map[string]bool{}
+	for _, s := range cfg.sections {
+		if strings.EqualFold(s.name, name) && s.subsection != "" && !seen[s.subsection] {
+			seen[s.subsection] = true
+			subsections = append(subsections, s.subsection)
+		}
+	}
+	return subsections
+}
+
+// Keys returns the full names of every key set in the configuration.
+func (cfg *Config) Keys() []string {
+	var keys []string
+	for _, s := range cfg.sections {
+		prefix := strings.ToLower(s.name) + "."
+		if s.subsection != "" {
+			prefix += s.subsection + "."
+		}
+
+		for _, e := range s.entries {
+			keys = append(keys, prefix+e.key)
+		}
+	}
+	return keys
+}
+
+func quoteValue(value string) string {
+	needsQuotes := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	value = strings.ReplaceAll(value, "\t", `\t`)
+
+	if needsQuotes {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+func (cfg *Config) WriteTo(w io.Writer) (int64, error) {
+	buf := &strings.Builder{}
+	for _, s := range cfg.sections {
+		if len(s.entries) == 0 {
+			continue
+		}
+
+		buf.WriteString(s.header() + "\n")
+		for _, e := range s.entries {
+			fmt.Fprintf(buf, "\t%s = %s\n", e.key, quoteValue(e.value))
+		}
+	}
+
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+func parseValue(raw string) (string, error) {
+	var value strings.Builder
+	var pendingSpace strings.Builder
+	quoted := false
+
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(raw) {
+				return "", errors.New("trailing backslash")
+			}
+
+			i++
+			value.WriteString(pendingSpace.String())
+			pendingSpace.Reset()
+
+			switch raw[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			case '\\', '"':
+				value.WriteByte(raw[i])
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", raw[i])
+			}
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == '#' || c == ';'):
+			i = len(raw)
+		case !quoted && (c == ' ' || c == '\t'):
+			if value.Len() > 0 {
+				pendingSpace.WriteByte(c)
+			}
+		default:
+			value.WriteString(pendingSpace.String())
+			pendingSpace.Reset()
+			value.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return "", errors.New("unterminated quote")
+	}
+
+	return value.String(), nil
+}
+
+func parseSectionHeader(line string) (string, string, error) {
+	end := strings.LastIndex(line, "]")
+	if end < 0 {
+		return "", "", fmt.Errorf("invalid section header %q", line)
+	}
+
+	header := strings.TrimSpace(line[1:end])
+	name, subsection, ok := strings.Cut(header, " ")
+	if !ok {
+		// The deprecated [section.subsection] syntax.
+		if name, subsection, ok = strings.Cut(header, "."); ok {
+			return strings.ToLower(name), strings.ToLower(subsection), nil
+		}
+		return strings.ToLower(header), "", nil
+	}
+
+	subsection = strings.TrimSpace(subsection)
+	if len(subsection) < 2 || subsection[0] != '"' || subsection[len(subsection)-1] != '"' {
+		return "", "", fmt.Errorf("invalid subsection in %q", line)
+	}
+
+	subsection = subsection[1 : len(subsection)-1]
+	subsection = strings.ReplaceAll(subsection, `\"`, `"`)
+	subsection = strings.ReplaceAll(subsection, `\\`, `\`)
+	return strings.ToLower(name), subsection, nil
+}
+
+func Parse(r io.Reader) (*Config, error) {
+	cfg := New()
+	var current *section
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Continuation lines.
+		for strings.HasSuffix(line, `\`) && !strings.HasSuffix(line, `\\`) && scanner.Scan() {
+			lineNumber++
+			line = line[:len(line)-1] + scanner.Text()
+		}
+
+		switch {
+		case line == "", line[0] == '#', line[0] == ';':
+			continue
+		case line[0] == '[':
+			name, subsection, err := parseSectionHeader(line)
+			if err != nil {
+				return nil, errors.Wrapf(err, "config: line %d", lineNumber)
+			}
+
+			current = &section{name: name, subsection: subsection}
+			cfg.sections = append(cfg.sections, current)
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("config: line %d: key outside of a section", lineNumber)
+		}
+
+		key, raw, hasValue := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value := "true"
+		if hasValue {
+			var err error
+			value, err = parseValue(strings.TrimSpace(raw))
+			if err != nil {
+				return nil, errors.Wrapf(err, "config: line %d", lineNumber)
+			}
+		}
+
+		current.entries = append(current.entries, &entry{key: key, value: value})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "config: reading configuration")
+	}
+
+	return cfg, nil
+}
+
+// Load reads the configuration at path. A missing file is treated as an
+// empty configuration.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, errors.Wrap(err, "config: opening "+path)
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+func (cfg *Config) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "config: creating "+path)
+	}
+	defer file.Close()
+
+	_, err = cfg.WriteTo(file)
+	if err != nil {
+		return errors.Wrap(err, "config: writing "+path)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testConfig = `# a comment
+[core]
+	repositoryformatversion = 0
+	bare = false
+	ignorecase
+[remote "origin"]
+	url = https://example.com/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/* ; trailing comment
+[Branch "Main"]
+	remote = origin
+[http]
+	postBuffer = 1m
+	extraHeader = "X-Quoted: \"value\" # not a comment"
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url, _ := cfg.Get("remote.origin.url"); url != "https://example.com/repo.git" {
+		t.Errorf("unexpected remote URL %q", url)
+	}
+
+	fetch := cfg.GetAll("remote.origin.fetch")
+	if len(fetch) != 2 || fetch[1] != "+refs/tags/*:refs/tags/*" {
+		t.Errorf("unexpected fetch refspecs %q", fetch)
+	}
+
+	if remote, _ := cfg.Get("branch.Main.remote"); remote != "origin" {
+		t.Errorf("subsections should be case-sensitive, have %q", remote)
+	}
+
+	if _, ok := cfg.Get("branch.main.remote"); ok {
+		t.Error("subsections should be case-sensitive")
+	}
+
+	if ignoreCase, err := cfg.GetBool("CORE.IgnoreCase", false); err != nil || !ignoreCase {
+		t.Errorf("expected a bare key to be true, have %v (%v)", ignoreCase, err)
+	}
+
+	if size, err := cfg.GetInt("http.postbuffer", 0); err != nil || size != 1<<20 {
+		t.Errorf("unexpected http.postBuffer %d (%v)", size, err)
+	}
+
+	if header, _ := cfg.Get("http.extraheader"); header != `X-Quoted: "value" # not a comment` {
+		t.Errorf("unexpected quoted value %q", header)
+	}
+
+	if remotes := cfg.Subsections("remote"); len(remotes) != 1 || remotes[0] != "origin" {
+		t.Errorf("unexpected remotes %q", remotes)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	cfg := New()
+	cfg.Set("core.bare", "false")
+	cfg.Set("remote.origin.url", "https://example.com/repo.git")
+	cfg.Add("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	cfg.Add("remote.origin.fetch", "+refs/tags/*:refs/tags/*")
+	cfg.Set("user.name", " padded; value ")
+
+	buf := &bytes.Buffer{}
+	_, err := cfg.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range cfg.Keys() {
+		if have, want := parsed.GetAll(key), cfg.GetAll(key); strings.Join(have, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: have %q, want %q", key, have, want)
+		}
+	}
+
+	cfg.Set("remote.origin.fetch", "+refs/heads/main:refs/remotes/origin/main")
+	if fetch := cfg.GetAll("remote.origin.fetch"); len(fetch) != 1 {
+		t.Errorf("Set should replace every value, have %q", fetch)
+	}
+}
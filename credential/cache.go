@@ -0,0 +1,350 @@
+package credential
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultCacheTimeout is how many seconds the cache helper keeps a
+	// credential for, as in git.
+	defaultCacheTimeout = 900
+
+	// cacheStartupWait is how long a new cache daemon waits for its
+	// first credential before giving up.
+	cacheStartupWait = 30 * time.Second
+)
+
+// cacheRequestTimeout is how long a client has to send its request and
+// read the reply. The daemon answers one client at a time, so one that
+// stalls mustn't hold up the rest.
+var cacheRequestTimeout = 5 * time.Second
+
+// cacheHelper keeps credentials in memory in a daemon listening on a unix
+// socket, speaking the same protocol as git-credential-cache.
+type cacheHelper struct {
+	socket  string
+	timeout int
+}
+
+// DefaultCacheSocket returns the socket the cache daemon listens on when
+// it isn't given one.
+func DefaultCacheSocket() (string, error) {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "finding the credential cache socket")
+		}
+		cacheHome = filepath.Join(home, ".cache")
+	}
+
+	return filepath.Join(cacheHome, "git", "credential", "socket"), nil
+}
+
+// NewCacheHelper returns the cache helper, configured with the arguments
+// git-credential-cache takes.
+func NewCacheHelper(args []string) (Helper, error) {
+	flags := flag.NewFlagSet("cache", flag.ContinueOnError)
+	timeout := flags.Int("timeout", defaultCacheTimeout, "seconds to cache credentials for")
+	socket := flags.String("socket", "", "socket to reach the cache daemon on")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *socket == "" {
+		*socket, err = DefaultCacheSocket()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &cacheHelper{socket: *socket, timeout: *timeout}, nil
+}
+
+// errNoCacheDaemon is returned by requests when the daemon isn't running.
+var errNoCacheDaemon = errors.New("the credential cache daemon isn't running")
+
+// request sends action to the daemon, returning its response.
+func (h *cacheHelper) request(action string, c *Credential) ([]byte, error) {
+	conn, err := net.Dial("unix", h.socket)
+	if err != nil {
+		return nil, errNoCacheDaemon
+	}
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "action=%s\ntimeout=%d\n", action, h.timeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing to the credential cache")
+	}
+
+	_, err = c.WriteTo(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing to the credential cache")
+	}
+
+	_, err = io.WriteString(conn, "\n")
+	if err != nil {
+		return nil, errors.Wrap(err, "writing to the credential cache")
+	}
+
+	out, err := io.ReadAll(conn)
+	return out, errors.Wrap(err, "reading from the credential cache")
+}
+
+// spawn starts a cache daemon and waits for it to listen.
+func (h *cacheHelper) spawn() error {
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "starting the credential cache daemon")
+	}
+
+	cmd := exec.Command(self, "credential-cache--daemon", h.socket)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "starting the credential cache daemon")
+	}
+
+	child := trace2.ChildStart("credential-cache--daemon", cmd)
+	err = cmd.Start()
+	if err != nil {
+		child.Exit(err)
+		return errors.Wrap(err, "starting the credential cache daemon")
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "ok\n" {
+		child.Exit(cmd.Wait())
+		return errors.New("credential cache daemon failed to start")
+	}
+
+	child.Ready()
+	return cmd.Process.Release()
+}
+
+func (h *cacheHelper) Get(c *Credential) error {
+	out, err := h.request("get", c)
+	if err == errNoCacheDaemon {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return c.Read(strings.NewReader(string(out)))
+}
+
+// Store starts the daemon if it isn't running.
+func (h *cacheHelper) Store(c *Credential) error {
+	if !c.Complete() {
+		return nil
+	}
+
+	_, err := h.request("store", c)
+	if err != errNoCacheDaemon {
+		return err
+	}
+
+	err = h.spawn()
+	if err != nil {
+		return err
+	}
+
+	_, err = h.request("store", c)
+	return err
+}
+
+func (h *cacheHelper) Erase(c *Credential) error {
+	_, err := h.request("erase", c)
+	if err == errNoCacheDaemon {
+		return nil
+	}
+	return err
+}
+
+// ExitCache tells the cache daemon listening on socket to exit.
+func ExitCache(socket string) error {
+	h := &cacheHelper{socket: socket}
+	_, err := h.request("exit", &Credential{})
+	if err == errNoCacheDaemon {
+		return nil
+	}
+	return err
+}
+
+type cacheEntry struct {
+	credential *Credential
+	expires    time.Time
+}
+
+type credentialCache struct {
+	entries []cacheEntry
+
+	// stored is set once the cache has held a credential, after which
+	// it's done when it's empty.
+	stored bool
+}
+
+// expire drops expired credentials, returning when the next one expires.
+func (cc *credentialCache) expire(now time.Time) time.Time {
+	var next time.Time
+	live := cc.entries[:0]
+	for _, entry := range cc.entries {
+		if !entry.expires.After(now) {
+			continue
+		}
+
+		live = append(live, entry)
+		if next.IsZero() || entry.expires.Before(next) {
+			next = entry.expires
+		}
+	}
+
+	cc.entries = live
+	return next
+}
+
+func (cc *credentialCache) remove(c *Credential) {
+	match := &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+	live := cc.entries[:0]
+	for _, entry := range cc.entries {
+		if !entry.credential.Matches(match) {
+			live = append(live, entry)
+		}
+	}
+	cc.entries = live
+}
+
+// handle answers a request from a cache client, returning false if the
+// daemon should exit.
+func (cc *credentialCache) handle(conn net.Conn) (bool, error) {
+	defer conn.Close()
+
+	err := conn.SetDeadline(time.Now().Add(cacheRequestTimeout))
+	if err != nil {
+		return true, errors.Wrap(err, "setting cache request deadline")
+	}
+
+	var action string
+	var timeout int
+	var lines strings.Builder
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		switch {
+		case strings.HasPrefix(line, "action="):
+			action = strings.TrimPrefix(line, "action=")
+		case strings.HasPrefix(line, "timeout="):
+			timeout, err = strconv.Atoi(strings.TrimPrefix(line, "timeout="))
+			if err != nil {
+				return true, errors.Wrap(err, "parsing cache timeout")
+			}
+		default:
+			lines.WriteString(line + "\n")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return true, errors.Wrap(err, "reading cache request")
+	}
+
+	c := &Credential{}
+	err = c.Read(strings.NewReader(lines.String()))
+	if err != nil {
+		return true, err
+	}
+
+	switch action {
+	case "get":
+		for _, entry := range cc.entries {
+			if entry.credential.Matches(c) {
+				_, err = entry.credential.WriteTo(conn)
+				return true, err
+			}
+		}
+	case "store":
+		cc.remove(c)
+		c.WWWAuth, c.Capabilities = nil, nil
+		cc.stored = true
+		cc.entries = append(cc.entries, cacheEntry{
+			credential: c,
+			expires:    time.Now().Add(time.Duration(timeout) * time.Second),
+		})
+	case "erase":
+		cc.remove(c)
+	case "exit":
+		return false, nil
+	default:
+		return true, fmt.Errorf("unknown cache action %q", action)
+	}
+
+	return true, nil
+}
+
+// ServeCache runs the cache daemon on socket, writing "ok" to ready once
+// it's listening. It exits when its last credential expires or is erased,
+// or if it isn't given one to hold soon after starting.
+func ServeCache(socket string, ready io.Writer) error {
+	err := os.MkdirAll(filepath.Dir(socket), 0700)
+	if err != nil {
+		return errors.Wrap(err, "creating the credential cache socket")
+	}
+
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return errors.Wrap(err, "listening on the credential cache socket")
+	}
+	defer l.Close()
+
+	_, err = io.WriteString(ready, "ok\n")
+	if err != nil {
+		return err
+	}
+
+	cache := &credentialCache{}
+	startup := time.Now().Add(cacheStartupWait)
+	for {
+		now := time.Now()
+		deadline := cache.expire(now)
+		if deadline.IsZero() {
+			if cache.stored || !now.Before(startup) {
+				return nil
+			}
+			deadline = startup
+		}
+
+		l.(*net.UnixListener).SetDeadline(deadline)
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return errors.Wrap(err, "accepting cache connection")
+		}
+
+		more, err := cache.handle(conn)
+		if err != nil {
+			log.Warnf("credential cache: %v", err)
+		}
+
+		if !more {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package credential implements git's credential helper protocol, along
+// with the store and cache helpers and .netrc lookups.
+package credential
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Credential is the set of attributes exchanged with credential helpers.
+// Protocol, Host and Path say what the credential is for; the rest is the
+// credential itself. A helper that supports it can return a Bearer token
+// in Credential with an AuthType of "Bearer" instead of a password.
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+
+	AuthType   string
+	Credential string
+
+	// WWWAuth holds the WWW-Authenticate headers the server sent, so
+	// helpers can tell what it accepts.
+	WWWAuth []string
+
+	// Capabilities are the optional parts of the protocol the caller
+	// understands, such as authtype.
+	Capabilities []string
+
+	// Quit tells the caller to stop asking other helpers.
+	Quit bool
+}
+
+// FromURL returns a credential for the protocol and host of u. The path is
+// left out, as git does unless credential.useHttpPath is set. A username
+// and password in the URL are carried over.
+func FromURL(u *url.URL) *Credential {
+	c := &Credential{Protocol: u.Scheme, Host: u.Host}
+	if u.User != nil {
+		c.Username = u.User.Username()
+		c.Password, _ = u.User.Password()
+	}
+	return c
+}
+
+// Complete reports whether the credential can be used to authenticate.
+func (c *Credential) Complete() bool {
+	if c.AuthType != "" && c.Credential != "" {
+		return true
+	}
+	return c.Username != "" && c.Password != ""
+}
+
+// Clear forgets the credential itself, keeping what it's for.
+func (c *Credential) Clear() {
+	c.Username, c.Password = "", ""
+	c.AuthType, c.Credential = "", ""
+}
+
+// URL returns the URL the credential is for, without the credential.
+func (c *Credential) URL() string {
+	u := &url.URL{Scheme: c.Protocol, Host: c.Host}
+	if c.Path != "" {
+		u.Path = "/" + strings.TrimPrefix(c.Path, "/")
+	}
+	return u.String()
+}
+
+// Matches reports whether c, as stored by a helper, answers a request
+// for other. Attributes that aren't set in other match anything.
+func (c *Credential) Matches(other *Credential) bool {
+	for _, attr := range [][2]string{
+		{c.Protocol, other.Protocol},
+		{c.Host, other.Host},
+		{c.Path, other.Path},
+		{c.Username, other.Username},
+	} {
+		if attr[1] != "" && attr[0] != attr[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// Read parses credential attributes from r, up to a blank line or the end
+// of the input, updating c with what it finds. Unknown attributes are
+// ignored, as the protocol requires.
+func (c *Credential) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("invalid credential line %q", line)
+		}
+
+		switch key {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "authtype":
+			c.AuthType = value
+		case "credential":
+			c.Credential = value
+		case "wwwauth[]":
+			c.WWWAuth = append(c.WWWAuth, value)
+		case "capability[]":
+			c.Capabilities = append(c.Capabilities, value)
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return errors.Wrap(err, "parsing credential URL")
+			}
+
+			*c = *FromURL(u)
+			c.Path = strings.TrimPrefix(u.Path, "/")
+		case "quit":
+			c.Quit = value == "1" || value == "true"
+		}
+	}
+
+	return errors.Wrap(scanner.Err(), "reading credential")
+}
+
+// WriteTo writes the attributes that are set, one per line. The caller
+// ends the list with a blank line, or by closing the stream. Values
+// containing a newline or NUL can't be written.
+func (c *Credential) WriteTo(w io.Writer) (n int64, err error) {
+	var sb strings.Builder
+	write := func(key, value string) {
+		if strings.ContainsAny(value, "\n\x00") {
+			err = fmt.Errorf("credential %s contains a newline or NUL", key)
+		}
+		fmt.Fprintf(&sb, "%s=%s\n", key, value)
+	}
+
+	for _, capability := range c.Capabilities {
+		write("capability[]", capability)
+	}
+
+	for _, attr := range [][2]string{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+		{"authtype", c.AuthType},
+		{"credential", c.Credential},
+	} {
+		if attr[1] != "" {
+			write(attr[0], attr[1])
+		}
+	}
+
+	for _, header := range c.WWWAuth {
+		write("wwwauth[]", header)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.WriteString(w, sb.String())
+	return int64(written), err
+}
@@ -0,0 +1,149 @@
+package credential
+
+import (
+	"bytes"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Helper is a credential helper. Get fills in what it can of c; Store and
+// Erase save and forget a credential that worked or didn't.
+type Helper interface {
+	Get(c *Credential) error
+	Store(c *Credential) error
+	Erase(c *Credential) error
+}
+
+// externalHelper runs a helper program, passing it the action as its last
+// argument and the credential on stdin.
+type externalHelper struct {
+	command string
+}
+
+func (h *externalHelper) run(action string, c *Credential) ([]byte, error) {
+	in := &bytes.Buffer{}
+	_, err := c.WriteTo(in)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", h.command+` "$@"`, h.command, action)
+	cmd.Stdin = in
+	cmd.Stderr = os.Stderr
+	child := trace2.ChildStart("credential", cmd)
+	out, err := cmd.Output()
+	child.Exit(err)
+	return out, errors.Wrap(err, "running credential helper "+h.command)
+}
+
+func (h *externalHelper) Get(c *Credential) error {
+	out, err := h.run("get", c)
+	if err != nil {
+		return err
+	}
+	return c.Read(bytes.NewReader(out))
+}
+
+func (h *externalHelper) Store(c *Credential) error {
+	_, err := h.run("store", c)
+	return err
+}
+
+func (h *externalHelper) Erase(c *Credential) error {
+	_, err := h.run("erase", c)
+	return err
+}
+
+// NewHelper returns the helper a credential.helper setting names. As in
+// git, a setting starting with ! is a shell command, an absolute path is
+// run as is, and anything else names a helper: the built-in store and
+// cache helpers, or a git-credential-<name> program on the PATH. Helper
+// arguments follow the name.
+func NewHelper(spec string) (Helper, error) {
+	switch {
+	case strings.HasPrefix(spec, "!"):
+		return &externalHelper{command: spec[1:]}, nil
+	case filepath.IsAbs(spec):
+		return &externalHelper{command: spec}, nil
+	}
+
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		return nil, errors.New("empty credential helper")
+	}
+
+	switch args[0] {
+	case "store":
+		return NewStoreHelper(args[1:])
+	case "cache":
+		return NewCacheHelper(args[1:])
+	}
+
+	return &externalHelper{command: "git-credential-" + spec}, nil
+}
+
+// Manager fills credentials from a list of helpers, and tells them which
+// credentials worked, the way git credential does.
+type Manager struct {
+	Helpers []Helper
+
+	// Username is used when the helpers don't supply one, as with
+	// git's credential.username.
+	Username string
+}
+
+// Fill asks each helper in turn until the credential is complete. A
+// helper that fails is skipped, as in git.
+func (m *Manager) Fill(c *Credential) error {
+	if c.Username == "" {
+		c.Username = m.Username
+	}
+
+	for _, helper := range m.Helpers {
+		if c.Complete() {
+			break
+		}
+
+		err := helper.Get(c)
+		if err != nil {
+			log.Warnf("credential helper: %v", err)
+			continue
+		}
+
+		if c.Quit {
+			break
+		}
+	}
+
+	if !c.Complete() {
+		return errors.New("no credentials for " + c.URL())
+	}
+	return nil
+}
+
+// Approve tells every helper that the credential worked, so they can
+// save it.
+func (m *Manager) Approve(c *Credential) {
+	for _, helper := range m.Helpers {
+		err := helper.Store(c)
+		if err != nil {
+			log.Warnf("credential helper: %v", err)
+		}
+	}
+}
+
+// Reject tells every helper that the credential was refused, so they
+// forget it.
+func (m *Manager) Reject(c *Credential) {
+	for _, helper := range m.Helpers {
+		err := helper.Erase(c)
+		if err != nil {
+			log.Warnf("credential helper: %v", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package credential
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcEntry is a machine, or the default, in a .netrc file.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parses the machine and default entries of a .netrc file.
+// Macro definitions are skipped.
+func parseNetrc(data string) []netrcEntry {
+	var entries []netrcEntry
+	var entry *netrcEntry
+	var inMacro bool
+
+	lines := strings.Split(data, "\n")
+	for _, line := range lines {
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			token := fields[i]
+			if strings.HasPrefix(token, "#") {
+				break
+			}
+
+			var value string
+			switch token {
+			case "machine", "login", "password", "account", "macdef":
+				if i+1 < len(fields) {
+					i++
+					value = fields[i]
+				}
+			}
+
+			switch token {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: value})
+				entry = &entries[len(entries)-1]
+			case "default":
+				entries = append(entries, netrcEntry{})
+				entry = &entries[len(entries)-1]
+			case "login":
+				if entry != nil {
+					entry.login = value
+				}
+			case "password":
+				if entry != nil {
+					entry.password = value
+				}
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+
+	return entries
+}
+
+// netrcPath returns the .netrc file to read: $NETRC if it's set, or the one
+// in the home directory.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// Netrc fills in c's username and password from the .netrc entry for its
+// host, if there is one. A username already in c must match the entry's.
+func Netrc(c *Credential) error {
+	path := netrcPath()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "reading "+path)
+	}
+
+	host := c.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, entry := range parseNetrc(string(data)) {
+		if entry.machine != "" && entry.machine != host && entry.machine != c.Host {
+			continue
+		}
+
+		if c.Username != "" && entry.login != c.Username {
+			continue
+		}
+
+		if entry.password == "" {
+			continue
+		}
+
+		c.Username, c.Password = entry.login, entry.password
+		return nil
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package credential
+
+import (
+	"bufio"
+	"flag"
+	"github.com/pkg/errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storeHelper keeps credentials in plain text files, one URL per line, in
+// the same format as git-credential-store.
+type storeHelper struct {
+	files []string
+}
+
+// defaultStoreFiles returns the files git-credential-store reads when it
+// isn't given one: ~/.git-credentials, then the XDG credentials file.
+func defaultStoreFiles() []string {
+	var files []string
+	home, err := os.UserHomeDir()
+	if err == nil {
+		files = append(files, filepath.Join(home, ".git-credentials"))
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	if configHome != "" {
+		files = append(files, filepath.Join(configHome, "git", "credentials"))
+	}
+
+	return files
+}
+
+// NewStoreHelper returns the store helper, configured with the arguments
+// git-credential-store takes.
+func NewStoreHelper(args []string) (Helper, error) {
+	flags := flag.NewFlagSet("store", flag.ContinueOnError)
+	file := flags.String("file", "", "file to store credentials in")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		return &storeHelper{files: []string{*file}}, nil
+	}
+
+	files := defaultStoreFiles()
+	if len(files) == 0 {
+		return nil, errors.New("no home directory for the credential store")
+	}
+	return &storeHelper{files: files}, nil
+}
+
+// parseStoreLine parses a stored credential, returning nil for lines that
+// don't hold one.
+func parseStoreLine(line string) *Credential {
+	u, err := url.Parse(strings.TrimSpace(line))
+	if err != nil || u.Scheme == "" || u.User == nil {
+		return nil
+	}
+
+	c := FromURL(u)
+	c.Path = strings.TrimPrefix(u.Path, "/")
+	if !c.Complete() {
+		return nil
+	}
+	return c
+}
+
+func storeLine(c *Credential) string {
+	u := &url.URL{
+		Scheme: c.Protocol,
+		User:   url.UserPassword(c.Username, c.Password),
+		Host:   c.Host,
+	}
+	if c.Path != "" {
+		u.Path = "/" + c.Path
+	}
+	return u.String()
+}
+
+func readStoreFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading credential store")
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, errors.Wrap(scanner.Err(), "reading credential store")
+}
+
+// rewrite replaces path's credentials matching c with add, if it's set.
+// Files that don't exist are only created to add to them.
+func (h *storeHelper) rewrite(path string, c *Credential, add string) error {
+	lines, err := readStoreFile(path)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	if add != "" {
+		sb.WriteString(add + "\n")
+	}
+
+	changed := add != ""
+	for _, line := range lines {
+		stored := parseStoreLine(line)
+		if stored != nil && stored.Matches(c) {
+			changed = true
+			continue
+		}
+		sb.WriteString(line + "\n")
+	}
+
+	if !changed {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return errors.Wrap(err, "creating credential store")
+	}
+
+	err = os.WriteFile(path, []byte(sb.String()), 0600)
+	return errors.Wrap(err, "writing credential store")
+}
+
+func (h *storeHelper) Get(c *Credential) error {
+	for _, path := range h.files {
+		lines, err := readStoreFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			stored := parseStoreLine(line)
+			if stored != nil && stored.Matches(c) {
+				c.Username = stored.Username
+				c.Password = stored.Password
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// Store saves c in the first file, replacing what was saved for it
+// before. Like git, only usernames and passwords are saved.
+func (h *storeHelper) Store(c *Credential) error {
+	if c.Protocol == "" || c.Host == "" || c.Username == "" || c.Password == "" {
+		return nil
+	}
+
+	match := &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+	return h.rewrite(h.files[0], match, storeLine(c))
+}
+
+func (h *storeHelper) Erase(c *Credential) error {
+	match := &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+	for _, path := range h.files {
+		err := h.rewrite(path, match, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
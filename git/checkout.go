@@ -0,0 +1,237 @@
+package git
+
+import (
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type checkout struct {
+	store    *objects.DirStore
+	workTree string
+	files    []checkedOutFile
+}
+
+// validEntryName reports whether a tree entry's name is safe to check out:
+// like git's verify_path, it refuses names that would step out of the
+// directory the tree is written to, or into the repository itself. As
+// with core.protectNTFS, names that only do so on Windows are refused
+// everywhere: backslashes, drive letters, and .git with trailing dots or
+// spaces or as its short name. On Windows, device names such as CON and
+// names with colons in them are refused too.
+func validEntryName(name string) bool {
+	trimmed := strings.TrimRight(name, ". ")
+	switch {
+	case name == "", name == ".", name == "..":
+		return false
+	case strings.EqualFold(trimmed, ".git"), strings.EqualFold(name, "git~1"):
+		return false
+	case strings.ContainsAny(name, "/\\\x00"):
+		return false
+	case len(name) >= 2 && name[1] == ':' && isASCIILetter(name[0]):
+		return false
+	}
+
+	if runtime.GOOS == "windows" {
+		return trimmed != "" && trimmed != "." && !strings.Contains(name, ":") && !reservedWindowsName(name)
+	}
+	return true
+}
+
+func isASCIILetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// reservedWindowsName reports whether name is a device name such as CON or
+// COM1, which Windows reserves whatever extension follows it.
+func reservedWindowsName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) &&
+		base[3] >= '1' && base[3] <= '9'
+}
+
+// checkLeadingPath refuses to check out name if one of the directories
+// leading to it in the work tree is a symbolic link, which writing the
+// file would follow.
+func (co *checkout) checkLeadingPath(name string) error {
+	target := co.workTree
+	for _, component := range strings.Split(path.Dir(name), "/") {
+		if component == "." {
+			continue
+		}
+
+		target = filepath.Join(target, component)
+		info, err := os.Lstat(target)
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("%s is beyond a symbolic link", name)
+		}
+	}
+
+	return nil
+}
+
+// tree writes the contents of a tree into dir, which is relative to the top
+// of the working tree.
+func (co *checkout) tree(treeID, dir string) error {
+	blob, err := co.store.Read(treeID)
+	if err != nil {
+		return errors.Wrap(err, "reading tree "+treeID)
+	}
+
+	tree, err := objects.TreeFromBlob(blob)
+	if err != nil {
+		return errors.Wrap(err, "parsing tree "+treeID)
+	}
+
+	for _, entry := range tree.Entries() {
+		if !validEntryName(entry.Name) {
+			return fmt.Errorf("tree %s has an invalid path %q", treeID, path.Join(dir, entry.Name))
+		}
+
+		id := fmt.Sprintf("%x", entry.Hash)
+		name := path.Join(dir, entry.Name)
+		target := filepath.Join(co.workTree, filepath.FromSlash(name))
+
+		err = co.checkLeadingPath(name)
+		if err != nil {
+			return errors.Wrap(err, "checking out "+name)
+		}
+
+		// Nothing is written over what's already there: a tree with
+		// the same name in it twice could otherwise have a file
+		// written through a symbolic link checked out before it.
+		switch entry.Mode {
+		case objects.ModeDirectory:
+			err = os.Mkdir(target, 0755)
+			if err == nil {
+				err = co.tree(id, name)
+			}
+		case objects.ModeSubmodule:
+			// Submodules aren't cloned, but git leaves an empty
+			// directory where each one goes.
+			err = os.Mkdir(target, 0755)
+		case objects.ModeSymbolic:
+			err = checkoutSymlink(co.store, id, target)
+		case objects.ModeExecutable:
+			err = checkoutFile(co.store, id, target, 0755)
+		default:
+			err = checkoutFile(co.store, id, target, 0644)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "checking out "+name)
+		}
+
+		if entry.Mode != objects.ModeDirectory {
+			co.files = append(co.files, checkedOutFile{path: name, id: entry.Hash, mode: indexMode(entry)})
+		}
+	}
+
+	return nil
+}
+
+// missingBlobs lists the blobs in a tree that aren't stored locally.
+func (co *checkout) missingBlobs(treeID string, missing *[]string) error {
+	blob, err := co.store.Read(treeID)
+	if err != nil {
+		return errors.Wrap(err, "reading tree "+treeID)
+	}
+
+	tree, err := objects.TreeFromBlob(blob)
+	if err != nil {
+		return errors.Wrap(err, "parsing tree "+treeID)
+	}
+
+	for _, entry := range tree.Entries() {
+		id := fmt.Sprintf("%x", entry.Hash)
+		switch {
+		case entry.Mode == objects.ModeSubmodule:
+		case entry.Mode == objects.ModeDirectory:
+			err = co.missingBlobs(id, missing)
+			if err != nil {
+				return err
+			}
+		case !co.store.Has(id):
+			*missing = append(*missing, id)
+		}
+	}
+
+	return nil
+}
+
+func checkoutFile(store objects.ObjectStore, id, target string, perm os.FileMode) error {
+	blob, err := store.Read(id)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(blob.Contents)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func checkoutSymlink(store objects.ObjectStore, id, target string) error {
+	blob, err := store.Read(id)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(string(blob.Contents), target)
+}
+
+// checkoutCommit writes the tree of a commit from store into workTree and
+// records it in the index.
+func checkoutCommit(gitDir string, store *objects.DirStore, commitID, workTree string) error {
+	co := &checkout{
+		store:    store,
+		workTree: workTree,
+	}
+
+	commit, err := objects.ReadCommit(store, commitID)
+	if err != nil {
+		return errors.Wrap(err, "reading commit to check out")
+	}
+
+	// A partial clone fetches the blobs it left out all at once, rather
+	// than one at a time as they're read.
+	if objects.IsPartialClone(store.Dir) {
+		var missing []string
+		err = co.missingBlobs(commit.Tree, &missing)
+		if err == nil {
+			err = objects.FetchPromised(store, missing)
+		}
+		if err != nil {
+			return errors.Wrap(err, "fetching missing blobs")
+		}
+	}
+
+	err = co.tree(commit.Tree, "")
+	if err != nil {
+		return err
+	}
+
+	return writeIndex(gitDir, workTree, co.files)
+}
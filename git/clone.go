@@ -1,40 +1,49 @@
 package git
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"git.wntrmute.dev/kyle/goutils/fileutil"
 	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
 	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/kisom/codecrafters/git-go/transport"
 	"github.com/pkg/errors"
-	"net/url"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func defaultRepoDir(repo string) (string, error) {
-	repoURL, err := url.Parse(repo)
+	endpoint, err := transport.ParseEndpoint(repo)
 	if err != nil {
-		return "", errors.Wrap(err, "parsing repo URL")
+		return "", err
 	}
 
-	log.Debugf("repo URL: %#v\n", repoURL)
+	log.Debugf("repo endpoint: %#v\n", endpoint)
 
-	dirName := filepath.Base(repoURL.Path)
-	if dirName == "" {
-		return repoURL.Hostname(), nil
+	dirName := endpoint.DefaultDir()
+	if dirName == "" || dirName == "." || dirName == ".." {
+		return "", fmt.Errorf("can't guess a directory name for %s; please give one", repo)
 	}
 
 	return dirName, nil
 }
 
-func removeDirectoryIfEmpty(dir string) {
+const defaultRemote = "origin"
+
+func removeFailedClone(dir string) {
 	log.Debugf("removing directory %s\n", dir)
-	if !fileutil.FileDoesExist(filepath.Join(dir, ".git", "HEAD")) {
-		err := os.RemoveAll(dir)
-		if err != nil {
-			log.Errf("repository is empty but failed to remove directory %s: %v", dir, err)
-		}
+	err := os.RemoveAll(dir)
+	if err != nil {
+		log.Errf("clone failed but couldn't remove directory %s: %v", dir, err)
 	}
 }
 
@@ -52,57 +61,418 @@ func clonePreflight(repo string, dirName string) error {
 	return nil
 }
 
-func cloneReferences(dirName string, ra *pack.ReferenceAdvertisement) error {
-	return errors.New("not implemented")
+func writeRemoteConfig(gitDir, remote, repo string) error {
+	cfgPath := filepath.Join(gitDir, "config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	cfg.Set("remote."+remote+".url", repo)
+	cfg.Set("remote."+remote+".fetch", "+refs/heads/*:refs/remotes/"+remote+"/*")
+
+	return cfg.Save(cfgPath)
+}
+
+func writeBranchConfig(gitDir, branch, remote string) error {
+	cfgPath := filepath.Join(gitDir, "config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	cfg.Set("branch."+branch+".remote", remote)
+	cfg.Set("branch."+branch+".merge", "refs/heads/"+branch)
+
+	return cfg.Save(cfgPath)
+}
+
+// remoteHead works out which branch the remote's HEAD points to. Servers
+// normally say so with a symref capability; failing that, pick a branch
+// pointing at the same commit.
+func remoteHead(ra *pack.ReferenceAdvertisement) (string, *pack.Reference) {
+	head := ra.Lookup("HEAD")
+	if head == nil {
+		return "", nil
+	}
+
+	target := ra.SymbolicRef("HEAD")
+	if strings.HasPrefix(target, "refs/heads/") && paths.ValidRefName(target) {
+		return target, head
+	}
+
+	for _, name := range []string{"refs/heads/master", "refs/heads/main"} {
+		if ref := ra.Lookup(name); ref != nil && ref.ID == head.ID {
+			return name, head
+		}
+	}
+
+	for _, ref := range ra.References {
+		if strings.HasPrefix(ref.Name, "refs/heads/") && ref.ID == head.ID {
+			return ref.Name, head
+		}
+	}
+
+	return "", head
+}
+
+// cloneReferences writes remote-tracking refs and tags for the advertised
+// references and points HEAD at the remote's default branch. It returns the
+// commit to check out, if any.
+func cloneReferences(gitDir string, ra *pack.ReferenceAdvertisement) (string, error) {
+	for _, ref := range ra.References {
+		if ref.IsPeeled() {
+			continue
+		}
+
+		var local string
+		switch {
+		case strings.HasPrefix(ref.Name, "refs/heads/"):
+			local = "refs/remotes/" + defaultRemote + "/" + strings.TrimPrefix(ref.Name, "refs/heads/")
+		case strings.HasPrefix(ref.Name, "refs/tags/"):
+			local = ref.Name
+		default:
+			continue
+		}
+
+		err := paths.UpdateRef(gitDir, local, ref.ID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	target, head := remoteHead(ra)
+	if head == nil {
+		fmt.Fprintln(os.Stderr, "warning: remote HEAD refers to nonexistent ref, unable to checkout")
+		return "", nil
+	}
+
+	if target == "" {
+		// Detached HEAD on the remote.
+		return head.ID, paths.UpdateRef(gitDir, "HEAD", head.ID)
+	}
+
+	branch := strings.TrimPrefix(target, "refs/heads/")
+	err := paths.UpdateRef(gitDir, target, head.ID)
+	if err != nil {
+		return "", err
+	}
+
+	err = paths.UpdateSymbolicRef(gitDir, "HEAD", target)
+	if err != nil {
+		return "", err
+	}
+
+	err = paths.UpdateSymbolicRef(gitDir, "refs/remotes/"+defaultRemote+"/HEAD",
+		"refs/remotes/"+defaultRemote+"/"+branch)
+	if err != nil {
+		return "", err
+	}
+
+	return head.ID, writeBranchConfig(gitDir, branch, defaultRemote)
+}
+
+// singleBranch narrows a clone down to the remote's default branch, leaving
+// out every other ref and limiting the remote's fetch refspec to match.
+func singleBranch(gitDir string, ra *pack.ReferenceAdvertisement) error {
+	target, head := remoteHead(ra)
+	if head == nil {
+		return nil
+	}
+
+	refs := []*pack.Reference{head}
+	if ref := ra.Lookup(target); ref != nil {
+		refs = append(refs, ref)
+	}
+	ra.References = refs
+
+	if target == "" {
+		return nil
+	}
+
+	cfgPath := filepath.Join(gitDir, "config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	branch := strings.TrimPrefix(target, "refs/heads/")
+	cfg.Set("remote."+defaultRemote+".fetch", "+"+target+":refs/remotes/"+defaultRemote+"/"+branch)
+	return cfg.Save(cfgPath)
 }
 
-func clone(repo string, dirName string) error {
-	err := clonePreflight(repo, dirName)
+type cloneOptions struct {
+	fetch pack.FetchOptions
+
+	// local copies the objects straight from a repository on this
+	// machine, hardlinking them if hardlinks is set.
+	local     bool
+	hardlinks bool
+}
+
+// linkOrCopy hardlinks a file if it can, and copies it otherwise.
+func linkOrCopy(src, dst string, hardlink bool) error {
+	if hardlink && os.Link(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
 	if err != nil {
 		return err
 	}
-	defer removeDirectoryIfEmpty(dirName)
 
-	advertisement, err := pack.FetchReferenceAdvertisement(repo)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyObjects copies a local repository's loose objects and packs, which
+// leaves the fetch that follows with nothing to do. Objects are immutable,
+// so they can be shared with hardlinks. An object database isn't: it's
+// written in place, so its objects are copied out one by one instead, as
+// loose objects in the clone.
+func copyObjects(srcDir, dstDir string, hardlink bool) error {
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir() && rel == "info":
+			return filepath.SkipDir
+		case d.IsDir():
+			return os.MkdirAll(filepath.Join(dstDir, rel), 0755)
+		case !d.Type().IsRegular(), strings.HasPrefix(d.Name(), "tmp_"), rel == objects.KVStoreName:
+			return nil
+		}
+
+		return linkOrCopy(path, filepath.Join(dstDir, rel), hardlink)
+	})
+	if err != nil {
+		return err
+	}
+
+	db, ok := objects.NewDirStore(srcDir).Loose.(*objects.KVStore)
+	if !ok {
+		return nil
+	}
+
+	dst := objects.NewDirStore(dstDir)
+	return db.Iterate(func(id string) error {
+		blob, err := db.Read(id)
+		if err != nil {
+			return err
+		}
+		return dst.Write(blob)
+	})
+}
+
+func clone(ctx context.Context, repo string, dirName string, opts *cloneOptions) (err error) {
+	err = clonePreflight(repo, dirName)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			removeFailedClone(dirName)
+		}
+	}()
+
+	gitDir, err := InitRepository(dirName)
+	if err != nil {
+		return err
+	}
+
+	err = writeRemoteConfig(gitDir, defaultRemote, repo)
+	if err != nil {
+		return err
+	}
+
+	if opts.fetch.Filter != nil {
+		err = writePromisorConfig(gitDir, defaultRemote, opts.fetch.Filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.local {
+		srcGitDir, ok := pack.LocalGitDir(repo)
+		if !ok {
+			return fmt.Errorf("%s is not a local repository", repo)
+		}
+
+		err = copyObjects(filepath.Join(srcGitDir, "objects"), filepath.Join(gitDir, "objects"), opts.hardlinks)
+		if err != nil {
+			return errors.Wrap(err, "copying objects")
+		}
+	}
+
+	cfg, err := config.Load(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return err
+	}
+
+	session, err := connect(ctx, cfg, repo, pack.ServiceUploadPack, pack.ProtocolV2)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	region := trace2.StartRegion("clone", "list-refs")
+	advertisement, err := pack.ListRefs(session, "HEAD", "refs/heads/", "refs/tags/")
+	region.End()
 	if err != nil {
 		return err
 	}
 
 	log.Infof("read %d reference in advertisement", len(advertisement.References))
+	trace2.Data("clone", "refs", len(advertisement.References))
 
-	return nil
+	if len(advertisement.References) == 0 {
+		fmt.Fprintln(os.Stderr, "warning: You appear to have cloned an empty repository.")
+		return nil
+	}
+
+	// As in git, a shallow clone only fetches the remote's default
+	// branch.
+	if opts.fetch.Depth > 0 || !opts.fetch.ShallowSince.IsZero() {
+		err = singleBranch(gitDir, advertisement)
+		if err != nil {
+			return err
+		}
+	}
+
+	store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+	region = trace2.StartRegion("clone", "fetch-pack")
+	checksum, err := pack.FetchPack(session, advertisement, store, &opts.fetch)
+	region.End()
+	if err != nil {
+		return errors.Wrap(err, "fetching pack")
+	}
+
+	log.Debugf("stored pack %s", checksum)
+
+	head, err := cloneReferences(gitDir, advertisement)
+	if err != nil {
+		return errors.Wrap(err, "writing references")
+	}
+
+	if head == "" {
+		return nil
+	}
+
+	defer trace2.StartRegion("clone", "checkout").End()
+	return checkoutCommit(gitDir, store, head, dirName)
 }
 
 func Clone(args []string) {
+	var quiet, local, noLocal, noHardlinks bool
+	var depth int
+	var since, filter string
 	flags := flag.NewFlagSet("clone", flag.ExitOnError)
+	flags.BoolVar(&quiet, "quiet", false, "don't show progress")
+	flags.BoolVar(&quiet, "q", false, "don't show progress")
+	flags.BoolVar(&local, "local", false, "copy objects directly from a local repository")
+	flags.BoolVar(&local, "l", false, "copy objects directly from a local repository")
+	flags.BoolVar(&noLocal, "no-local", false, "fetch from a local repository as if it were remote")
+	flags.BoolVar(&noHardlinks, "no-hardlinks", false, "copy objects from a local repository instead of hardlinking them")
+	flags.IntVar(&depth, "depth", 0, "create a shallow clone with this many commits of history")
+	flags.StringVar(&since, "shallow-since", "", "create a shallow clone with history after this date")
+	flags.StringVar(&filter, "filter", "", "create a partial clone, leaving out the objects the `spec` filters")
 	err := flags.Parse(args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing flags: %v\n", err)
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
-	if len(flag.Args()) == 0 {
+	if flags.NArg() == 0 {
 		fmt.Fprintln(os.Stderr, "no repo provided.")
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
 	repo := flags.Arg(0)
 	dirName, err := defaultRepoDir(repo)
-	if len(args) > 1 {
+	if flags.NArg() > 1 {
 		dirName = flags.Arg(1)
 	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "invalid repo provided: %s\n", err)
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
 	if fileutil.DirectoryDoesExist(dirName) {
 		fmt.Fprintln(os.Stderr, "repository already exists in", dirName)
-		os.Exit(1)
+		trace2.ExitProcess(1)
+	}
+
+	// Like git, plain paths are cloned by copying objects, but file://
+	// URLs go through the transport unless --local is given.
+	_, isLocal := pack.LocalGitDir(repo)
+	isPath := isLocal && !strings.HasPrefix(repo, "file://")
+	opts := &cloneOptions{
+		local:     isLocal && (local || isPath) && !noLocal,
+		hardlinks: !noHardlinks,
+	}
+
+	if isPath {
+		// Record where the repository is, wherever fetch is run from.
+		repo, err = filepath.Abs(repo)
+		trace2.DieIf(err)
+	}
+
+	if !quiet {
+		opts.fetch.Progress = os.Stderr
+	}
+
+	opts.fetch.Depth = depth
+	if since != "" {
+		opts.fetch.ShallowSince, err = parseShallowSince(since)
+		trace2.DieIf(err)
+	}
+
+	if filter != "" {
+		opts.fetch.Filter, err = pack.ParseFilter(filter)
+		trace2.DieIf(err)
 	}
 
-	err = clone(repo, dirName)
+	// Copying objects brings all of history along, so as in git, a
+	// shallow or partial clone of a path needs a file:// URL.
+	if opts.local && (opts.fetch.Depth > 0 || !opts.fetch.ShallowSince.IsZero()) {
+		fmt.Fprintln(os.Stderr, "warning: --depth is ignored in local clones; use file:// instead.")
+		opts.fetch.Depth = 0
+		opts.fetch.ShallowSince = time.Time{}
+	}
+
+	if opts.local && opts.fetch.Filter != nil {
+		fmt.Fprintln(os.Stderr, "warning: --filter is ignored in local clones; use file:// instead.")
+		opts.fetch.Filter = nil
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	err = clone(ctx, repo, dirName, opts)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "error cloning repository:", err)
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 }
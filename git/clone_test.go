@@ -0,0 +1,304 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type testObject interface {
+	Write(objects.ObjectStore) error
+}
+
+func writeTestObject(t *testing.T, store objects.ObjectStore, obj testObject) {
+	err := obj.Write(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRepository creates a repository named name in root whose master
+// branch is a single commit of tree, writing objs along with it.
+func newTestRepository(t *testing.T, root, name string, tree *objects.Tree, objs ...testObject) (string, string) {
+	gitDir, err := InitRepository(filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+	for _, obj := range objs {
+		writeTestObject(t, store, obj)
+	}
+	writeTestObject(t, store, tree)
+
+	commit := objects.NewCommitFromTree(tree.HashString(), "", "initial commit")
+	writeTestObject(t, store, commit)
+
+	err = paths.UpdateRef(gitDir, "refs/heads/"+defaultBranch, commit.HashString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gitDir, commit.HashString()
+}
+
+func TestClone(t *testing.T) {
+	root := t.TempDir()
+	file := objects.BlobFromBytes([]byte("hello\n"))
+	script := objects.BlobFromBytes([]byte("#!/bin/sh\necho hello\n"))
+	target := objects.BlobFromBytes([]byte("hello.txt"))
+	nested := objects.BlobFromBytes([]byte("nested\n"))
+
+	subtree := &objects.Tree{}
+	subtree.Add(&objects.TreeEntry{Hash: nested.Hash(), Name: "nested.txt", Mode: objects.ModeRegular})
+
+	tree := &objects.Tree{}
+	tree.Add(&objects.TreeEntry{Hash: file.Hash(), Name: "hello.txt", Mode: objects.ModeRegular})
+	tree.Add(&objects.TreeEntry{Hash: script.Hash(), Name: "hello.sh", Mode: objects.ModeExecutable})
+	tree.Add(&objects.TreeEntry{Hash: target.Hash(), Name: "link", Mode: objects.ModeSymbolic})
+	tree.Add(&objects.TreeEntry{Hash: subtree.Hash(), Name: "dir", Mode: objects.ModeDirectory})
+
+	srcGitDir, head := newTestRepository(t, root, "src", tree, file, script, target, nested, subtree)
+	err := paths.UpdateRef(srcGitDir, "refs/tags/v1", head)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(pack.NewHTTPHandler(root))
+	defer srv.Close()
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	err = clone(context.Background(), srv.URL+"/src", dir, &cloneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		Name     string
+		Contents string
+		Mode     os.FileMode
+	}{
+		{"hello.txt", "hello\n", 0644},
+		{"hello.sh", "#!/bin/sh\necho hello\n", 0755},
+		{"dir/nested.txt", "nested\n", 0644},
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(contents) != f.Contents {
+			t.Fatalf("expected %s to hold %q, have %q", f.Name, f.Contents, contents)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The umask may take permissions away, but not the execute bit
+		// from the owner.
+		if info.Mode().Perm()&0100 != f.Mode&0100 {
+			t.Fatalf("expected %s to have mode %o, have %o", f.Name, f.Mode, info.Mode().Perm())
+		}
+	}
+
+	link, err := os.Readlink(filepath.Join(dir, "link"))
+	if err != nil || link != "hello.txt" {
+		t.Fatalf("expected link to point to hello.txt, have %q (%v)", link, err)
+	}
+
+	gitDir := filepath.Join(dir, ".git")
+	for _, name := range []string{"HEAD", "refs/heads/master", "refs/remotes/origin/master", "refs/tags/v1"} {
+		id, err := paths.ReadRef(gitDir, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id != head {
+			t.Fatalf("expected %s to be %s, have %s", name, head, id)
+		}
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed to check the index with")
+	}
+
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git status failed: %v\n%s", err, out)
+	}
+
+	if len(out) != 0 {
+		t.Fatalf("expected git status to be clean, have\n%s", out)
+	}
+}
+
+func TestCloneInvalidPath(t *testing.T) {
+	root := t.TempDir()
+	config := objects.BlobFromBytes([]byte("[core]\n\tfsmonitor = touch pwned\n"))
+	escaped := objects.BlobFromBytes([]byte("escaped\n"))
+
+	gitTree := &objects.Tree{}
+	gitTree.Add(&objects.TreeEntry{Hash: config.Hash(), Name: "config", Mode: objects.ModeRegular})
+
+	parentTree := &objects.Tree{}
+	parentTree.Add(&objects.TreeEntry{Hash: escaped.Hash(), Name: "escaped.txt", Mode: objects.ModeRegular})
+
+	for i, name := range []string{".git", ".GIT", ".git. ", "GIT~1", "..", "..\\x", "C:x"} {
+		subtree := gitTree
+		if name == ".." {
+			subtree = parentTree
+		}
+
+		tree := &objects.Tree{}
+		tree.Add(&objects.TreeEntry{Hash: subtree.Hash(), Name: name, Mode: objects.ModeDirectory})
+		repo := fmt.Sprintf("src%d", i)
+		newTestRepository(t, root, repo, tree, config, escaped, gitTree, parentTree)
+
+		srv := httptest.NewServer(pack.NewHTTPHandler(root))
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "clone")
+		err := clone(context.Background(), srv.URL+"/"+repo, dir, &cloneOptions{})
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), "invalid path") {
+			t.Fatalf("expected cloning a tree with %q in it to fail, have %v", name, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); err == nil {
+			t.Fatal("a file was checked out outside of the work tree")
+		}
+	}
+}
+
+func TestValidEntryName(t *testing.T) {
+	valid := []string{"hello.txt", ".gitignore", "a b", "git~2"}
+	if runtime.GOOS != "windows" {
+		valid = append(valid, "...", "con.txt", "ab:c")
+	}
+
+	for _, name := range valid {
+		if !validEntryName(name) {
+			t.Errorf("expected %q to be valid", name)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", ".git", ".Git", ".git.", ".git ", "git~1", "a/b", "..\\x", "a\\b", "c:x", "Z:", "a\x00b"} {
+		if validEntryName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		for _, name := range []string{"CON", "con.txt", "nul ", "COM1", "lpt9.log", "ab:c", "... "} {
+			if validEntryName(name) {
+				t.Errorf("expected %q to be invalid on Windows", name)
+			}
+		}
+	}
+}
+
+func TestCloneInvalidRefName(t *testing.T) {
+	pktLine := func(line string) string {
+		return fmt.Sprintf("%04x%s", len(line)+4, line)
+	}
+
+	id := "5360bfb1a8f0e8fcfba4f9d9e4ef2cf1e1b4c9d5"
+	for _, advertised := range []string{
+		pktLine(id+" HEAD\x00symref=HEAD:refs/heads/main\n") + pktLine(id+" refs/tags/../../../../pwned\n"),
+		pktLine("not-an-object-id HEAD\x00\n"),
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			fmt.Fprint(w, pktLine("# service=git-upload-pack\n")+"0000"+advertised+"0000")
+		}))
+
+		parent := t.TempDir()
+		err := clone(context.Background(), srv.URL+"/repo", filepath.Join(parent, "clone"), &cloneOptions{})
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatalf("expected cloning from a server advertising %q to fail, have %v", advertised, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(parent, "pwned")); err == nil {
+			t.Fatal("a ref was written outside of the repository")
+		}
+	}
+}
+
+func TestLocalCloneObjectDatabase(t *testing.T) {
+	root := t.TempDir()
+	srcGitDir, err := InitRepository(filepath.Join(root, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfgPath := filepath.Join(srcGitDir, "config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("core.repositoryformatversion", "1")
+	cfg.Set("extensions.objectstorage", "kvdb")
+	err = cfg.Save(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := objects.BlobFromBytes([]byte("hello\n"))
+	tree := &objects.Tree{}
+	tree.Add(&objects.TreeEntry{Hash: file.Hash(), Name: "hello.txt", Mode: objects.ModeRegular})
+	commit := objects.NewCommitFromTree(tree.HashString(), "", "initial commit")
+
+	src := objects.NewDirStore(filepath.Join(srcGitDir, "objects"))
+	if _, ok := src.Loose.(*objects.KVStore); !ok {
+		t.Fatalf("expected the source to keep objects in a database, have %T", src.Loose)
+	}
+	for _, obj := range []testObject{file, tree, commit} {
+		writeTestObject(t, src, obj)
+	}
+
+	err = paths.UpdateRef(srcGitDir, "refs/heads/"+defaultBranch, commit.HashString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	err = clone(context.Background(), filepath.Join(root, "src"), dir, &cloneOptions{local: true, hardlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectsDir := filepath.Join(dir, ".git", "objects")
+	if _, err = os.Stat(filepath.Join(objectsDir, objects.KVStoreName)); err == nil {
+		t.Fatal("the clone shouldn't share the source's object database")
+	}
+
+	dst := objects.NewDirStore(objectsDir)
+	for _, id := range []string{file.HashString(), tree.HashString(), commit.HashString()} {
+		if !dst.Has(id) {
+			t.Fatalf("expected the clone to have object %s", id)
+		}
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	if err != nil || string(contents) != "hello\n" {
+		t.Fatalf("expected hello.txt to hold %q, have %q (%v)", "hello\n", contents, err)
+	}
+}
@@ -0,0 +1,157 @@
+package git
+
+import (
+	"flag"
+	"fmt"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/credential"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"os"
+	"path/filepath"
+)
+
+// credentialManager returns the credential helpers configured for
+// remoteURL. As in git, an empty credential.helper setting clears the list
+// so far.
+func credentialManager(cfg *config.Config, remoteURL string) (*credential.Manager, error) {
+	username := cfg.GetString(urlConfigKey(cfg, "credential", "username", remoteURL), "")
+	manager := &credential.Manager{Username: username}
+	for _, spec := range urlConfigAll(cfg, "credential", "helper", remoteURL) {
+		if spec == "" {
+			manager.Helpers = nil
+			continue
+		}
+
+		helper, err := credential.NewHelper(spec)
+		if err != nil {
+			return nil, err
+		}
+		manager.Helpers = append(manager.Helpers, helper)
+	}
+
+	return manager, nil
+}
+
+// repoConfig returns the configuration of the repository in the current
+// directory, or an empty one outside of a repository.
+func repoConfig() (*config.Config, error) {
+	gitDir, ok := paths.FindGitDir(".")
+	if !ok {
+		return config.New(), nil
+	}
+
+	return config.Load(filepath.Join(gitDir, "config"))
+}
+
+// Credential runs the configured credential helpers, in the manner of git
+// credential: fill completes the credential read from standard input and
+// prints it, approve and reject pass it on to be saved or forgotten.
+func Credential(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: mygit credential (fill|approve|reject)\n")
+		trace2.ExitProcess(1)
+	}
+
+	c := &credential.Credential{}
+	err := c.Read(os.Stdin)
+	trace2.DieIf(err)
+
+	cfg, err := repoConfig()
+	trace2.DieIf(err)
+
+	manager, err := credentialManager(cfg, c.URL())
+	trace2.DieIf(err)
+
+	switch args[0] {
+	case "fill":
+		err = manager.Fill(c)
+		trace2.DieIf(err)
+
+		c.WWWAuth, c.Capabilities = nil, nil
+		_, err = c.WriteTo(os.Stdout)
+		trace2.DieIf(err)
+	case "approve":
+		manager.Approve(c)
+	case "reject":
+		manager.Reject(c)
+	default:
+		fmt.Fprintf(os.Stderr, "usage: mygit credential (fill|approve|reject)\n")
+		trace2.ExitProcess(1)
+	}
+}
+
+// runHelper runs a built-in helper the way git runs a credential helper
+// program: with options, an action, and the credential on standard input.
+func runHelper(name string, newHelper func([]string) (credential.Helper, error), args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: mygit %s [<options>] (get|store|erase)\n", name)
+		trace2.ExitProcess(1)
+	}
+
+	helper, err := newHelper(args[:len(args)-1])
+	trace2.DieIf(err)
+
+	c := &credential.Credential{}
+	err = c.Read(os.Stdin)
+	trace2.DieIf(err)
+
+	switch action := args[len(args)-1]; action {
+	case "get":
+		found := &credential.Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+		err = helper.Get(found)
+		trace2.DieIf(err)
+
+		if found.Complete() {
+			_, err = found.WriteTo(os.Stdout)
+			trace2.DieIf(err)
+		}
+	case "store":
+		err = helper.Store(c)
+		trace2.DieIf(err)
+	case "erase":
+		err = helper.Erase(c)
+		trace2.DieIf(err)
+	default:
+		// Helpers ignore actions they don't know, so that new ones
+		// can be added to the protocol.
+		log.Debugf("%s: ignoring unknown action %s", name, action)
+	}
+}
+
+func CredentialStore(args []string) {
+	runHelper("credential-store", credential.NewStoreHelper, args)
+}
+
+func CredentialCache(args []string) {
+	if len(args) > 0 && args[len(args)-1] == "exit" {
+		helper := flag.NewFlagSet("credential-cache", flag.ExitOnError)
+		socket := helper.String("socket", "", "socket to reach the cache daemon on")
+		helper.Int("timeout", 0, "seconds to cache credentials for")
+		err := helper.Parse(args[:len(args)-1])
+		trace2.DieIf(err)
+
+		if *socket == "" {
+			*socket, err = credential.DefaultCacheSocket()
+			trace2.DieIf(err)
+		}
+
+		trace2.DieIf(credential.ExitCache(*socket))
+		return
+	}
+
+	runHelper("credential-cache", credential.NewCacheHelper, args)
+}
+
+// CredentialCacheDaemon runs the daemon the cache helper keeps credentials
+// in. It's started by the helper as it's needed.
+func CredentialCacheDaemon(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: mygit credential-cache--daemon <socket>\n")
+		trace2.ExitProcess(1)
+	}
+
+	err := credential.ServeCache(args[0], os.Stdout)
+	trace2.DieIf(err)
+}
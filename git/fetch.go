@@ -0,0 +1,351 @@
+package git
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/kisom/codecrafters/git-go/transport"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if short, ok := strings.CutPrefix(name, prefix); ok {
+			return short
+		}
+	}
+	return name
+}
+
+func shortID(id string) string {
+	if len(id) > 7 {
+		return id[:7]
+	}
+	return id
+}
+
+type refUpdate struct {
+	remote string
+	local  string
+	oldID  string
+	newID  string
+	force  bool
+}
+
+// matchRefspecs works out which local refs the advertised refs update.
+// Wildcards copy the remote's names into local ones, so both have to be
+// valid ref names before anything is written.
+func matchRefspecs(ra *pack.ReferenceAdvertisement, refspecs []*paths.Refspec) ([]*refUpdate, error) {
+	var updates []*refUpdate
+	for _, refspec := range refspecs {
+		matched := false
+		for _, ref := range ra.References {
+			if ref.IsPeeled() {
+				continue
+			}
+
+			local, ok := refspec.Match(ref.Name)
+			if !ok {
+				continue
+			}
+
+			matched = true
+			if !paths.ValidRefName(ref.Name) || (local != "" && !paths.ValidRefName(local)) {
+				return nil, fmt.Errorf("refusing to fetch %s into invalid ref name %q", ref.Name, local)
+			}
+
+			if local != "" {
+				updates = append(updates, &refUpdate{remote: ref.Name, local: local, newID: ref.ID, force: refspec.Force})
+			}
+		}
+
+		if !matched && !refspec.IsWildcard() {
+			return nil, fmt.Errorf("couldn't find remote ref %s", refspec.Src)
+		}
+	}
+
+	return updates, nil
+}
+
+// applyRefUpdate moves a local ref after a fetch, refusing to rewind it
+// unless the refspec allows it. It returns false if the update was
+// rejected.
+func applyRefUpdate(gitDir string, store *objects.DirStore, update *refUpdate, w io.Writer) (bool, error) {
+	if update.oldID == update.newID {
+		return true, nil
+	}
+
+	summary := fmt.Sprintf("%-17s %-10s -> %s", "", shortRefName(update.remote), shortRefName(update.local))
+	switch {
+	case update.oldID == "":
+		kind := "[new branch]"
+		if strings.HasPrefix(update.local, "refs/tags/") {
+			kind = "[new tag]"
+		}
+		summary = fmt.Sprintf(" * %-15s %-10s -> %s", kind, shortRefName(update.remote), shortRefName(update.local))
+	default:
+		fastForward, err := pack.IsAncestor(store, update.oldID, update.newID)
+		if err != nil {
+			return false, errors.Wrap(err, "checking for fast-forward of "+update.local)
+		}
+
+		switch {
+		case fastForward:
+			summary = fmt.Sprintf("   %-15s %-10s -> %s", shortID(update.oldID)+".."+shortID(update.newID),
+				shortRefName(update.remote), shortRefName(update.local))
+		case update.force:
+			summary = fmt.Sprintf(" + %-15s %-10s -> %s  (forced update)", shortID(update.oldID)+"..."+shortID(update.newID),
+				shortRefName(update.remote), shortRefName(update.local))
+		default:
+			fmt.Fprintf(w, " ! %-15s %-10s -> %s  (non-fast-forward)\n", "[rejected]",
+				shortRefName(update.remote), shortRefName(update.local))
+			return false, nil
+		}
+	}
+
+	fmt.Fprintln(w, summary)
+	return true, paths.UpdateRef(gitDir, update.local, update.newID)
+}
+
+// remoteURL returns the URL and fetch refspecs configured for a remote. A
+// remote that isn't configured is taken to be a URL. Either way, the URL
+// is rewritten as url.<base>.insteadOf says.
+func remoteURL(cfg *config.Config, remote string) (string, []string) {
+	url, ok := cfg.Get("remote." + remote + ".url")
+	if !ok {
+		return transport.RewriteURL(cfg, remote, false), nil
+	}
+
+	return transport.RewriteURL(cfg, url, false), cfg.GetAll("remote." + remote + ".fetch")
+}
+
+// connect starts a session with a remote's service, with the transport
+// settings from the repository's config.
+func connect(ctx context.Context, cfg *config.Config, url, service string, version int) (pack.Session, error) {
+	credentials, err := credentialManager(cfg, url)
+	if err != nil {
+		return nil, err
+	}
+
+	httpOpts, err := httpOptions(cfg, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return pack.Connect(ctx, url, service, &pack.ConnectOptions{
+		Version:     version,
+		SSHCommand:  cfg.GetString("core.sshCommand", ""),
+		Credentials: credentials,
+		HTTP:        *httpOpts,
+	})
+}
+
+// commandContext returns the context a command talks to remotes in, which
+// is cancelled if the command is interrupted.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func fetch(ctx context.Context, gitDir, remote string, specs []string, opts *pack.FetchOptions) error {
+	cfg, err := config.Load(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return err
+	}
+
+	url, configured := remoteURL(cfg, remote)
+	if len(specs) == 0 {
+		specs = configured
+	}
+
+	// Fetches from a partial clone's promisor remote leave out the same
+	// objects the clone did.
+	filter, promisor, err := promisorFilter(cfg, remote)
+	if err != nil {
+		return err
+	}
+
+	if promisor {
+		opts.Promisor = true
+		if opts.Filter == nil {
+			opts.Filter = filter
+		}
+	}
+
+	if len(specs) == 0 {
+		return fmt.Errorf("no refspecs to fetch from %s", remote)
+	}
+
+	var refspecs []*paths.Refspec
+	var prefixes []string
+	for _, spec := range specs {
+		refspec, err := paths.ParseRefspec(spec)
+		if err != nil {
+			return err
+		}
+
+		refspecs = append(refspecs, refspec)
+		prefixes = append(prefixes, refspec.Prefix())
+	}
+
+	session, err := connect(ctx, cfg, url, pack.ServiceUploadPack, pack.ProtocolV2)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	region := trace2.StartRegion("fetch", "list-refs")
+	ra, err := pack.ListRefs(session, prefixes...)
+	region.End()
+	if err != nil {
+		return err
+	}
+
+	updates, err := matchRefspecs(ra, refspecs)
+	if err != nil {
+		return err
+	}
+
+	localRefs, err := paths.ListRefs(gitDir)
+	if err != nil {
+		return err
+	}
+
+	opts.Wants = nil
+	for _, update := range updates {
+		update.oldID = localRefs[update.local]
+		opts.Wants = append(opts.Wants, update.newID)
+	}
+
+	seen := map[string]bool{}
+	opts.Haves = nil
+	for _, id := range localRefs {
+		if !seen[id] {
+			seen[id] = true
+			opts.Haves = append(opts.Haves, id)
+		}
+	}
+
+	trace2.Data("fetch", "wants", len(opts.Wants))
+	if len(opts.Wants) == 0 {
+		return nil
+	}
+
+	store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+	region = trace2.StartRegion("fetch", "fetch-pack")
+	_, err = pack.FetchPack(session, ra, store, opts)
+	region.End()
+	if err != nil {
+		return errors.Wrap(err, "fetching pack")
+	}
+
+	region = trace2.StartRegion("fetch", "update-refs")
+	defer region.End()
+
+	fmt.Fprintf(os.Stderr, "From %s\n", url)
+	rejected := 0
+	for _, update := range updates {
+		ok, err := applyRefUpdate(gitDir, store, update, os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			rejected++
+		}
+	}
+
+	if rejected > 0 {
+		return fmt.Errorf("%d refs were rejected", rejected)
+	}
+
+	return nil
+}
+
+// shallowSinceLayouts are the date formats --shallow-since accepts, besides
+// seconds since the epoch.
+var shallowSinceLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func parseShallowSince(date string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(date, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	for _, layout := range shallowSinceLayouts {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q", date)
+}
+
+func Fetch(args []string) {
+	var quiet, unshallow bool
+	var depth, deepen int
+	var since string
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	flags.BoolVar(&quiet, "quiet", false, "don't show progress")
+	flags.BoolVar(&quiet, "q", false, "don't show progress")
+	flags.IntVar(&depth, "depth", 0, "limit history to this many commits from the remote's tips")
+	flags.IntVar(&deepen, "deepen", 0, "fetch this many more commits behind the shallow boundary")
+	flags.BoolVar(&unshallow, "unshallow", false, "fetch the rest of a shallow repository's history")
+	flags.StringVar(&since, "shallow-since", "", "limit history to commits after this date")
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	remote := defaultRemote
+	if flags.NArg() > 0 {
+		remote = flags.Arg(0)
+	}
+
+	gitDir, err := paths.GitDir()
+	trace2.DieIf(err)
+
+	opts := &pack.FetchOptions{Depth: depth}
+	if !quiet {
+		opts.Progress = os.Stderr
+	}
+
+	if since != "" {
+		opts.ShallowSince, err = parseShallowSince(since)
+		trace2.DieIf(err)
+	}
+
+	if deepen > 0 {
+		opts.Depth = deepen
+		opts.DeepenRelative = true
+	}
+
+	if unshallow {
+		shallow, err := paths.ReadShallow(gitDir)
+		trace2.DieIf(err)
+
+		if len(shallow) == 0 {
+			fmt.Fprintln(os.Stderr, "--unshallow on a complete repository does not make sense")
+			trace2.ExitProcess(1)
+		}
+		opts.Depth = pack.InfiniteDepth
+		opts.DeepenRelative = false
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	err = fetch(ctx, gitDir, remote, flags.Args()[min(1, flags.NArg()):], opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error fetching:", err)
+		trace2.ExitProcess(1)
+	}
+}
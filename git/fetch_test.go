@@ -0,0 +1,34 @@
+package git
+
+import (
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"testing"
+)
+
+func TestMatchRefspecs(t *testing.T) {
+	refspec, err := paths.ParseRefspec("+refs/heads/*:refs/remotes/origin/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := "5360bfb1a8f0e8fcfba4f9d9e4ef2cf1e1b4c9d5"
+	ra := &pack.ReferenceAdvertisement{References: []*pack.Reference{
+		{ID: id, Name: "refs/heads/main"},
+		{ID: id, Name: "refs/tags/v1"},
+	}}
+
+	updates, err := matchRefspecs(ra, []*paths.Refspec{refspec})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updates) != 1 || updates[0].local != "refs/remotes/origin/main" || !updates[0].force {
+		t.Fatalf("expected one forced update of refs/remotes/origin/main, have %#v", updates)
+	}
+
+	ra.References = append(ra.References, &pack.Reference{ID: id, Name: "refs/heads/../../../x"})
+	if _, err = matchRefspecs(ra, []*paths.Refspec{refspec}); err == nil {
+		t.Fatal("expected a remote ref outside refs/ to be refused")
+	}
+}
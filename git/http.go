@@ -0,0 +1,73 @@
+package git
+
+import (
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envInt reads an integer setting from the environment, which overrides
+// the config.
+func envInt(name string, value int64) (int64, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return value, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, errors.Wrap(err, "invalid "+name)
+}
+
+// httpOptions reads the http.* settings that apply to remoteURL, along
+// with the environment variables git lets override them.
+func httpOptions(cfg *config.Config, remoteURL string) (*pack.HTTPOptions, error) {
+	key := func(name string) string {
+		return urlConfigKey(cfg, "http", name, remoteURL)
+	}
+
+	opts := &pack.HTTPOptions{
+		Proxy:  cfg.GetString(key("proxy"), ""),
+		CAInfo: cfg.GetString(key("sslCAInfo"), ""),
+	}
+	if caInfo := os.Getenv("GIT_SSL_CAINFO"); caInfo != "" {
+		opts.CAInfo = caInfo
+	}
+
+	verify, err := cfg.GetBool(key("sslVerify"), true)
+	if err != nil {
+		return nil, err
+	}
+	opts.SkipVerify = !verify || os.Getenv("GIT_SSL_NO_VERIFY") != ""
+
+	opts.LowSpeedLimit, err = cfg.GetInt(key("lowSpeedLimit"), 0)
+	if err == nil {
+		opts.LowSpeedLimit, err = envInt("GIT_HTTP_LOW_SPEED_LIMIT", opts.LowSpeedLimit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lowSpeedTime, err := cfg.GetInt(key("lowSpeedTime"), 0)
+	if err == nil {
+		lowSpeedTime, err = envInt("GIT_HTTP_LOW_SPEED_TIME", lowSpeedTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+	opts.LowSpeedTime = time.Duration(lowSpeedTime) * time.Second
+
+	// As with credential.helper, an empty http.extraHeader clears the
+	// headers so far.
+	for _, header := range urlConfigAll(cfg, "http", "extraHeader", remoteURL) {
+		if header == "" {
+			opts.ExtraHeaders = nil
+			continue
+		}
+		opts.ExtraHeaders = append(opts.ExtraHeaders, header)
+	}
+
+	return opts, nil
+}
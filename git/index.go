@@ -0,0 +1,89 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var indexSignature = []byte("DIRC")
+
+const indexVersion = 2
+
+// checkedOutFile records a file written by checkout, so that it can be
+// added to the index.
+type checkedOutFile struct {
+	path string // relative to the top of the working tree, with / separators
+	id   []byte
+	mode uint32
+}
+
+func indexMode(entry *objects.TreeEntry) uint32 {
+	switch entry.Mode {
+	case objects.ModeExecutable:
+		return 0100755
+	case objects.ModeSymbolic:
+		return 0120000
+	case objects.ModeSubmodule:
+		return 0160000
+	}
+	return 0100644
+}
+
+// writeIndex writes a version 2 index for the files checked out into
+// workTree. Only the modification time and size are recorded from each
+// file; git fills in the rest the next time it refreshes the index.
+func writeIndex(gitDir, workTree string, files []checkedOutFile) error {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+
+	buf := &bytes.Buffer{}
+	buf.Write(indexSignature)
+	binary.Write(buf, binary.BigEndian, uint32(indexVersion))
+	binary.Write(buf, binary.BigEndian, uint32(len(files)))
+
+	for _, file := range files {
+		var mtime, size int64
+		fi, err := os.Lstat(filepath.Join(workTree, filepath.FromSlash(file.path)))
+		if err == nil && file.mode != 0160000 {
+			mtime = fi.ModTime().UnixNano()
+			size = fi.Size()
+		}
+
+		start := buf.Len()
+		stat := []uint32{
+			uint32(mtime / 1e9), uint32(mtime % 1e9), // ctime
+			uint32(mtime / 1e9), uint32(mtime % 1e9), // mtime
+			0, 0, // dev, ino
+			file.mode,
+			0, 0, // uid, gid
+			uint32(size),
+		}
+		binary.Write(buf, binary.BigEndian, stat)
+		buf.Write(file.id)
+
+		nameLength := len(file.path)
+		if nameLength > 0xfff {
+			nameLength = 0xfff
+		}
+		binary.Write(buf, binary.BigEndian, uint16(nameLength))
+		buf.WriteString(file.path)
+
+		// Entries are NUL-padded to a multiple of eight bytes, with at
+		// least one NUL.
+		padding := 8 - (buf.Len()-start)%8
+		buf.Write(make([]byte, padding))
+	}
+
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	err := os.WriteFile(filepath.Join(gitDir, "index"), buf.Bytes(), 0644)
+	return errors.Wrap(err, "writing index")
+}
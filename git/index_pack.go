@@ -0,0 +1,80 @@
+package git
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/pkg/errors"
+	"os"
+)
+
+func indexPackFromStdin(packPath, indexPath string) (string, error) {
+	file, err := os.OpenFile(packPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", errors.Wrap(err, "creating packfile")
+	}
+	defer file.Close()
+
+	index, err := pack.IndexPack(os.Stdin, file, file)
+	if err != nil {
+		return "", err
+	}
+
+	indexFile, err := os.Create(indexPath)
+	if err != nil {
+		return "", errors.Wrap(err, "creating pack index")
+	}
+	defer indexFile.Close()
+
+	_, err = index.WriteTo(indexFile)
+	if err != nil {
+		return "", errors.Wrap(err, "writing pack index")
+	}
+
+	return fmt.Sprintf("%x", index.PackChecksum), indexFile.Close()
+}
+
+func IndexPack(args []string) {
+	var indexPath string
+	var stdin bool
+
+	flags := flag.NewFlagSet("index-pack", flag.ExitOnError)
+	flags.StringVar(&indexPath, "o", "", "write the index to `file`")
+	flags.BoolVar(&stdin, "stdin", false, "read the pack from stdin")
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	if flags.NArg() > 1 || (!stdin && flags.NArg() != 1) {
+		fmt.Fprintln(os.Stderr, "Usage: index-pack [-o idx-file] <pack-file>")
+		fmt.Fprintln(os.Stderr, "       index-pack --stdin [-o idx-file] [pack-file]")
+		flags.PrintDefaults()
+		trace2.ExitProcess(1)
+	}
+
+	packPath := flags.Arg(0)
+	if indexPath == "" && packPath != "" {
+		indexPath = objects.IndexPathForPack(packPath)
+	}
+
+	var checksum string
+	switch {
+	case stdin && packPath == "":
+		objectsDir, err := paths.ObjectsDir()
+		trace2.DieIf(err)
+
+		checksum, err = pack.IndexPackToDir(os.Stdin, paths.PackDir(objectsDir))
+		trace2.DieIf(err)
+		fmt.Printf("pack\t%s\n", checksum)
+		return
+	case stdin:
+		checksum, err = indexPackFromStdin(packPath, indexPath)
+	default:
+		checksum, err = pack.IndexPackFile(packPath, indexPath)
+	}
+
+	trace2.DieIf(err)
+	fmt.Println(checksum)
+}
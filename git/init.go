@@ -0,0 +1,39 @@
+package git
+
+import (
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+const defaultBranch = "master"
+
+// InitRepository creates an empty repository in dir, returning the path to
+// its .git directory.
+func InitRepository(dir string) (string, error) {
+	gitDir := filepath.Join(dir, ".git")
+	for _, subdir := range []string{"objects", "objects/pack", "refs/heads", "refs/tags"} {
+		err := os.MkdirAll(filepath.Join(gitDir, subdir), 0755)
+		if err != nil {
+			return "", errors.Wrap(err, "creating repository directories")
+		}
+	}
+
+	err := paths.UpdateSymbolicRef(gitDir, "HEAD", "refs/heads/"+defaultBranch)
+	if err != nil {
+		return "", errors.Wrap(err, "writing HEAD")
+	}
+
+	cfg := config.New()
+	cfg.Set("core.repositoryformatversion", "0")
+	cfg.Set("core.filemode", "true")
+	cfg.Set("core.bare", "false")
+	err = cfg.Save(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return "", err
+	}
+
+	return gitDir, nil
+}
@@ -0,0 +1,192 @@
+package git
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// resolveRevision resolves a ref name with optional ~N and ^ suffixes to
+// an object ID, following first parents through store.
+func resolveRevision(gitDir string, store objects.ObjectStore, rev string) (string, error) {
+	name := rev
+	var generations []int
+
+	for {
+		if i := strings.LastIndexAny(name, "~^"); i > 0 {
+			n := 1
+			suffix := name[i+1:]
+			if suffix != "" {
+				var err error
+				n, err = strconv.Atoi(suffix)
+				if err != nil {
+					break
+				}
+			}
+
+			if name[i] == '^' && n != 1 {
+				return "", fmt.Errorf("unsupported revision %s; only first parents are supported", rev)
+			}
+
+			generations = append([]int{n}, generations...)
+			name = name[:i]
+			continue
+		}
+		break
+	}
+
+	id, err := paths.ReadRef(gitDir, name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, n := range generations {
+		for ; n > 0; n-- {
+			commit, err := objects.ReadCommit(store, id)
+			if err != nil {
+				return "", errors.Wrap(err, "resolving "+rev)
+			}
+
+			if len(commit.Parents) == 0 {
+				return "", fmt.Errorf("revision %s goes past the root commit", rev)
+			}
+			id = commit.Parents[0]
+		}
+	}
+
+	return id, nil
+}
+
+// parseRevs turns rev-list style arguments ("A", "^B", "B..A") into the
+// object IDs to include and exclude.
+func parseRevs(gitDir string, store objects.ObjectStore, revs []string) ([]string, []string, error) {
+	var include, exclude []string
+
+	for _, rev := range revs {
+		if rev == "" {
+			continue
+		}
+
+		if from, to, ok := strings.Cut(rev, ".."); ok {
+			if to == "" {
+				to = "HEAD"
+			}
+
+			fromID, err := resolveRevision(gitDir, store, from)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			toID, err := resolveRevision(gitDir, store, to)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			include = append(include, toID)
+			exclude = append(exclude, fromID)
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(rev, "^"); ok {
+			id, err := resolveRevision(gitDir, store, name)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			exclude = append(exclude, id)
+			continue
+		}
+
+		id, err := resolveRevision(gitDir, store, rev)
+		if err != nil {
+			return nil, nil, err
+		}
+		include = append(include, id)
+	}
+
+	return include, exclude, nil
+}
+
+func readPackObjectsInput(r io.Reader, gitDir string, store *objects.DirStore, revs bool) ([]pack.RevObject, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading object list")
+	}
+
+	if revs {
+		include, exclude, err := parseRevs(gitDir, store, lines)
+		if err != nil {
+			return nil, err
+		}
+
+		return pack.RevList(store, include, exclude)
+	}
+
+	// Each line is an object ID, optionally followed by its path, as
+	// printed by rev-list --objects.
+	var revObjects []pack.RevObject
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		id, name, _ := strings.Cut(line, " ")
+		revObjects = append(revObjects, pack.RevObject{ID: id, Name: name})
+	}
+
+	return revObjects, nil
+}
+
+func PackObjects(args []string) {
+	var revs, stdout bool
+	opts := pack.DefaultPackOptions()
+
+	flags := flag.NewFlagSet("pack-objects", flag.ExitOnError)
+	flags.BoolVar(&revs, "revs", false, "read revisions instead of object IDs from stdin")
+	flags.BoolVar(&stdout, "stdout", false, "write the pack to stdout")
+	flags.IntVar(&opts.Window, "window", pack.DefaultWindow, "number of objects to consider as delta bases")
+	flags.IntVar(&opts.Depth, "depth", pack.DefaultDepth, "maximum delta chain depth")
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	if !stdout && flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: pack-objects [options] <base-name> < object-list")
+		flags.PrintDefaults()
+		trace2.ExitProcess(1)
+	}
+
+	gitDir, err := paths.GitDir()
+	trace2.DieIf(err)
+
+	store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+	revObjects, err := readPackObjectsInput(os.Stdin, gitDir, store, revs)
+	trace2.DieIf(err)
+
+	if stdout {
+		w := bufio.NewWriter(os.Stdout)
+		_, err = pack.WritePack(w, store, revObjects, opts)
+		trace2.DieIf(err)
+		trace2.DieIf(w.Flush())
+		return
+	}
+
+	checksum, err := pack.WritePackFiles(flags.Arg(0), store, revObjects, opts)
+	trace2.DieIf(err)
+
+	fmt.Println(checksum)
+}
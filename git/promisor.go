@@ -0,0 +1,90 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/pkg/errors"
+	"path/filepath"
+)
+
+func init() {
+	objects.FetchPromisedObjects = fetchPromisedObjects
+}
+
+// writePromisorConfig records remote as the promisor remote of a partial
+// clone made with filter. Like git, it bumps the repository format version
+// so that older versions leave the repository alone.
+func writePromisorConfig(gitDir, remote string, filter *pack.Filter) error {
+	cfgPath := filepath.Join(gitDir, "config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	cfg.Set("core.repositoryformatversion", "1")
+	cfg.Set("extensions.partialclone", remote)
+	cfg.Set("remote."+remote+".promisor", "true")
+	cfg.Set("remote."+remote+".partialclonefilter", filter.String())
+
+	return cfg.Save(cfgPath)
+}
+
+// promisorFilter returns the filter a partial clone uses to fetch from
+// remote, and whether remote is a promisor remote at all.
+func promisorFilter(cfg *config.Config, remote string) (*pack.Filter, bool, error) {
+	promisor, err := cfg.GetBool("remote."+remote+".promisor", false)
+	if err != nil || !promisor {
+		return nil, false, err
+	}
+
+	spec, ok := cfg.Get("remote." + remote + ".partialclonefilter")
+	if !ok {
+		return nil, true, nil
+	}
+
+	filter, err := pack.ParseFilter(spec)
+	return filter, true, err
+}
+
+// fetchPromisedObjects fetches objects a partial clone left out from its
+// promisor remote. Trees are fetched without their blobs, which are
+// fetched in turn when they're needed.
+func fetchPromisedObjects(store *objects.DirStore, ids []string) error {
+	gitDir := filepath.Dir(store.Dir)
+	cfg, err := config.Load(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return err
+	}
+
+	remote, ok := cfg.Get("extensions.partialclone")
+	if !ok {
+		return fmt.Errorf("%s has no promisor remote", gitDir)
+	}
+
+	url, _ := remoteURL(cfg, remote)
+	session, err := connect(context.Background(), cfg, url, pack.ServiceUploadPack, pack.ProtocolV2)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	ra, err := pack.ReadAdvertisement(session)
+	if err != nil {
+		return err
+	}
+
+	filter, err := pack.ParseFilter("blob:none")
+	if err != nil {
+		return err
+	}
+
+	_, err = pack.FetchPack(session, ra, store, &pack.FetchOptions{
+		Wants:    ids,
+		Filter:   filter,
+		Promisor: true,
+	})
+	return errors.Wrap(err, "fetching from promisor remote "+remote)
+}
@@ -0,0 +1,324 @@
+package git
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/kisom/codecrafters/git-go/transport"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type pushUpdate struct {
+	pack.RefUpdate
+	src   string
+	force bool
+
+	// rejected explains why the update wasn't sent, if it wasn't.
+	rejected string
+}
+
+func (update *pushUpdate) upToDate() bool {
+	return update.OldID == update.NewID
+}
+
+// remoteRefName works out the full name of the ref to push to, which is a
+// branch unless the source is a tag.
+func remoteRefName(localRefs map[string]string, src, dst string) string {
+	if strings.HasPrefix(dst, "refs/") {
+		return dst
+	}
+
+	_, isTag := localRefs["refs/tags/"+src]
+	_, isBranch := localRefs["refs/heads/"+src]
+	if isTag && !isBranch {
+		return "refs/tags/" + dst
+	}
+	return "refs/heads/" + dst
+}
+
+func parsePushRefspec(gitDir string, localRefs map[string]string, spec string, force bool) (*pushUpdate, error) {
+	refspec, err := paths.ParseRefspec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if refspec.IsWildcard() {
+		return nil, fmt.Errorf("wildcard refspec %s can't be pushed", spec)
+	}
+
+	dst := refspec.Dst
+	if dst == "" {
+		dst = refspec.Src
+	}
+
+	update := &pushUpdate{src: refspec.Src, force: force || refspec.Force}
+	update.Name = remoteRefName(localRefs, refspec.Src, dst)
+	update.NewID = pack.ZeroID
+	if refspec.Src != "" {
+		update.NewID, err = paths.ReadRef(gitDir, refspec.Src)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return update, nil
+}
+
+// checkPushUpdate rejects updates that would lose commits on the remote,
+// unless they're forced.
+func checkPushUpdate(store *objects.DirStore, update *pushUpdate) error {
+	switch {
+	case update.IsDelete() && update.OldID == pack.ZeroID:
+		update.rejected = "remote ref does not exist"
+	case update.IsDelete(), update.OldID == pack.ZeroID, update.upToDate(), update.force:
+	case !store.Has(update.OldID):
+		update.rejected = "fetch first"
+	default:
+		fastForward, err := pack.IsAncestor(store, update.OldID, update.NewID)
+		if err != nil {
+			return errors.Wrap(err, "checking for fast-forward of "+update.Name)
+		}
+
+		if !fastForward {
+			update.rejected = "non-fast-forward"
+		}
+	}
+
+	return nil
+}
+
+func printPushStatus(w io.Writer, store *objects.DirStore, update *pushUpdate, status *pack.RefStatus) {
+	from := shortRefName(update.src)
+	to := shortRefName(update.Name)
+	if update.src != "" {
+		to = from + " -> " + to
+	}
+
+	switch {
+	case update.rejected != "":
+		fmt.Fprintf(w, " ! %-17s %s (%s)\n", "[rejected]", to, update.rejected)
+	case status == nil:
+		fmt.Fprintf(w, " ! %-17s %s (no status reported)\n", "[remote failure]", to)
+	case status.Error != "":
+		fmt.Fprintf(w, " ! %-17s %s (%s)\n", "[remote rejected]", to, status.Error)
+	case update.IsDelete():
+		fmt.Fprintf(w, " - %-17s %s\n", "[deleted]", to)
+	case update.OldID == pack.ZeroID:
+		kind := "[new branch]"
+		if strings.HasPrefix(update.Name, "refs/tags/") {
+			kind = "[new tag]"
+		}
+		fmt.Fprintf(w, " * %-17s %s\n", kind, to)
+	default:
+		fastForward, _ := pack.IsAncestor(store, update.OldID, update.NewID)
+		if fastForward {
+			fmt.Fprintf(w, "   %-17s %s\n", shortID(update.OldID)+".."+shortID(update.NewID), to)
+		} else {
+			fmt.Fprintf(w, " + %-17s %s (forced update)\n", shortID(update.OldID)+"..."+shortID(update.NewID), to)
+		}
+	}
+}
+
+// updateTrackingRefs moves the remote-tracking refs for pushed branches, as
+// if they'd been fetched.
+func updateTrackingRefs(gitDir string, fetchSpecs []string, update *pushUpdate) error {
+	for _, spec := range fetchSpecs {
+		refspec, err := paths.ParseRefspec(spec)
+		if err != nil {
+			return err
+		}
+
+		local, ok := refspec.Match(update.Name)
+		if !ok || local == "" {
+			continue
+		}
+
+		if update.IsDelete() {
+			err = paths.DeleteRef(gitDir, local)
+		} else {
+			err = paths.UpdateRef(gitDir, local, update.NewID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type pushFlags struct {
+	force  bool
+	delete bool
+	opts   pack.PushOptions
+}
+
+// pushURL is remoteURL for pushing. A remote's pushurl is used in place of
+// its url, and url.<base>.pushInsteadOf rewrites the url but not an
+// explicit pushurl.
+func pushURL(cfg *config.Config, remote string) (string, []string) {
+	fetchSpecs := cfg.GetAll("remote." + remote + ".fetch")
+	if pushurl, ok := cfg.Get("remote." + remote + ".pushurl"); ok {
+		return transport.RewriteURL(cfg, pushurl, false), fetchSpecs
+	}
+
+	url, ok := cfg.Get("remote." + remote + ".url")
+	if !ok {
+		url = remote
+	}
+	return transport.RewriteURL(cfg, url, true), fetchSpecs
+}
+
+func push(ctx context.Context, gitDir, remote string, specs []string, flags *pushFlags) error {
+	cfg, err := config.Load(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return err
+	}
+	url, fetchSpecs := pushURL(cfg, remote)
+
+	localRefs, err := paths.ListRefs(gitDir)
+	if err != nil {
+		return err
+	}
+
+	var updates []*pushUpdate
+	for _, spec := range specs {
+		if flags.delete {
+			spec = ":" + spec
+		}
+
+		update, err := parsePushRefspec(gitDir, localRefs, spec, flags.force)
+		if err != nil {
+			return err
+		}
+		updates = append(updates, update)
+	}
+
+	session, err := connect(ctx, cfg, url, pack.ServiceReceivePack, pack.ProtocolV0)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	ra, err := pack.ReadAdvertisement(session)
+	if err != nil {
+		return err
+	}
+
+	store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+	var commands []*pack.RefUpdate
+	rejected := 0
+	for _, update := range updates {
+		update.OldID = pack.ZeroID
+		if ref := ra.Lookup(update.Name); ref != nil {
+			update.OldID = ref.ID
+		}
+
+		err = checkPushUpdate(store, update)
+		if err != nil {
+			return err
+		}
+
+		if update.rejected != "" {
+			rejected++
+		}
+	}
+
+	for _, update := range updates {
+		if flags.opts.Atomic && rejected > 0 && update.rejected == "" {
+			update.rejected = "atomic push failed"
+		}
+
+		if update.rejected == "" && !update.upToDate() {
+			commands = append(commands, &update.RefUpdate)
+		}
+	}
+
+	report := &pack.PushReport{}
+	if len(commands) > 0 {
+		report, err = pack.SendPack(session, ra, store, commands, &flags.opts)
+		if err != nil {
+			return errors.Wrap(err, "pushing")
+		}
+	}
+
+	if len(commands) == 0 && rejected == 0 {
+		fmt.Fprintln(os.Stderr, "Everything up-to-date")
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "To %s\n", url)
+	failed := 0
+	for _, update := range updates {
+		if update.upToDate() && update.rejected == "" {
+			continue
+		}
+
+		status := report.Status(update.Name)
+		printPushStatus(os.Stderr, store, update, status)
+		if update.rejected != "" || status == nil || status.Error != "" {
+			failed++
+			continue
+		}
+
+		err = updateTrackingRefs(gitDir, fetchSpecs, update)
+		if err != nil {
+			return err
+		}
+	}
+
+	if report.UnpackError != "" {
+		return fmt.Errorf("remote unpack failed: %s", report.UnpackError)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to push some refs to %s", url)
+	}
+
+	return nil
+}
+
+func Push(args []string) {
+	var quiet bool
+	pf := &pushFlags{}
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	flags.BoolVar(&pf.force, "force", false, "update remote refs even if they aren't ancestors")
+	flags.BoolVar(&pf.force, "f", false, "update remote refs even if they aren't ancestors")
+	flags.BoolVar(&pf.delete, "delete", false, "delete the named remote refs")
+	flags.BoolVar(&pf.delete, "d", false, "delete the named remote refs")
+	flags.BoolVar(&pf.opts.Atomic, "atomic", false, "update all refs or none of them")
+	flags.BoolVar(&quiet, "quiet", false, "don't show progress")
+	flags.BoolVar(&quiet, "q", false, "don't show progress")
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: push [options] <remote> <refspec>...")
+		flags.PrintDefaults()
+		trace2.ExitProcess(1)
+	}
+
+	gitDir, err := paths.GitDir()
+	trace2.DieIf(err)
+
+	if !quiet {
+		pf.opts.Progress = os.Stderr
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	err = push(ctx, gitDir, flags.Arg(0), flags.Args()[1:], pf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		trace2.ExitProcess(1)
+	}
+}
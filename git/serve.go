@@ -0,0 +1,97 @@
+package git
+
+import (
+	"flag"
+	"fmt"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"io"
+	"net"
+	"net/http"
+	"os"
+)
+
+// serveService runs a service on standard input and output, as the
+// remote end of an ssh connection does.
+func serveService(name string, serve func(string, io.Reader, io.Writer, bool) error, args []string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <directory>\n", name)
+		trace2.ExitProcess(1)
+	}
+
+	gitDir, ok := paths.FindGitDir(flags.Arg(0))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "fatal: '%s' does not appear to be a git repository\n", flags.Arg(0))
+		trace2.ExitProcess(128)
+	}
+
+	err = serve(gitDir, os.Stdin, os.Stdout, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s: %v\n", name, err)
+		trace2.ExitProcess(128)
+	}
+}
+
+func UploadPack(args []string) {
+	serveService("upload-pack", pack.UploadPack, args)
+}
+
+func ReceivePack(args []string) {
+	serveService("receive-pack", pack.ReceivePack, args)
+}
+
+func Serve(args []string) {
+	var addr string
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&addr, "addr", "localhost:8080", "address to listen on")
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	root := "."
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		root = flags.Arg(0)
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: serve [--addr host:port] [directory]")
+		flags.PrintDefaults()
+		trace2.ExitProcess(1)
+	}
+
+	if _, err = os.Stat(root); err != nil {
+		trace2.DieIf(err)
+	}
+
+	log.Infof("serving repositories in %s on %s", root, addr)
+	trace2.DieIf(http.ListenAndServe(addr, pack.NewHTTPHandler(root)))
+}
+
+func Daemon(args []string) {
+	var listen, port string
+	daemon := &pack.Daemon{}
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags.StringVar(&daemon.BasePath, "base-path", "", "directory repository paths are relative to")
+	flags.BoolVar(&daemon.ExportAll, "export-all", false, "serve repositories without a git-daemon-export-ok file")
+	flags.StringVar(&listen, "listen", "", "address to listen on")
+	flags.StringVar(&port, "port", pack.DaemonPort, "port to listen on")
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon [--base-path directory] [--export-all] [--listen host] [--port port]")
+		flags.PrintDefaults()
+		trace2.ExitProcess(1)
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(listen, port))
+	trace2.DieIf(err)
+
+	log.Infof("serving repositories over git:// on %s", l.Addr())
+	trace2.DieIf(daemon.Serve(l))
+}
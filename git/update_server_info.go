@@ -0,0 +1,33 @@
+package git
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/pack"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"os"
+)
+
+// UpdateServerInfo writes the files that let the repository be fetched
+// from a plain file server.
+func UpdateServerInfo(args []string) {
+	flags := flag.NewFlagSet("update-server-info", flag.ExitOnError)
+	err := flags.Parse(args)
+	trace2.DieIf(err)
+
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: mygit update-server-info")
+		trace2.ExitProcess(1)
+	}
+
+	// The repositories served this way are usually bare.
+	gitDir, ok := paths.FindGitDir(".")
+	if !ok {
+		fmt.Fprintln(os.Stderr, "fatal: not a git repository")
+		trace2.ExitProcess(128)
+	}
+
+	err = pack.UpdateServerInfo(gitDir)
+	trace2.DieIf(err)
+}
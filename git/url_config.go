@@ -0,0 +1,76 @@
+package git
+
+import (
+	"github.com/kisom/codecrafters/git-go/config"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// configURLMatches reports whether the URL in a setting like
+// http.<url>.proxy applies to target: the protocol and host must be the
+// same, and the setting's path, if any, must be a prefix of target's.
+func configURLMatches(pattern string, target *url.URL) bool {
+	u, err := url.Parse(pattern)
+	if err != nil || u.Scheme == "" {
+		return false
+	}
+
+	if u.Scheme != target.Scheme || u.Host != target.Host {
+		return false
+	}
+
+	if u.User != nil && (target.User == nil || u.User.Username() != target.User.Username()) {
+		return false
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	path := strings.Trim(target.Path, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// urlConfigKeys returns the keys that set section.key for remoteURL: the
+// key for every URL, then the keys for matching URLs, least specific
+// first.
+func urlConfigKeys(cfg *config.Config, section, key, remoteURL string) []string {
+	keys := []string{section + "." + key}
+	target, err := url.Parse(remoteURL)
+	if err != nil {
+		return keys
+	}
+
+	var patterns []string
+	for _, pattern := range cfg.Subsections(section) {
+		if configURLMatches(pattern, target) {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	sort.SliceStable(patterns, func(i, j int) bool { return len(patterns[i]) < len(patterns[j]) })
+	for _, pattern := range patterns {
+		keys = append(keys, section+"."+pattern+"."+key)
+	}
+	return keys
+}
+
+// urlConfigKey returns the most specific key setting section.key for
+// remoteURL, so that its value can be read with the usual getters.
+func urlConfigKey(cfg *config.Config, section, key, remoteURL string) string {
+	keys := urlConfigKeys(cfg, section, key, remoteURL)
+	for i := len(keys) - 1; i > 0; i-- {
+		if _, ok := cfg.Get(keys[i]); ok {
+			return keys[i]
+		}
+	}
+	return keys[0]
+}
+
+// urlConfigAll returns every value of a multi-valued section.key that
+// applies to remoteURL.
+func urlConfigAll(cfg *config.Config, section, key, remoteURL string) []string {
+	var values []string
+	for _, k := range urlConfigKeys(cfg, section, key, remoteURL) {
+		values = append(values, cfg.GetAll(k)...)
+	}
+	return values
+}
@@ -4,13 +4,14 @@ import (
 	"fmt"
 	"github.com/kisom/codecrafters/git-go/objects"
 	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
 	"github.com/pkg/errors"
 	"io/fs"
 	"os"
 	"path/filepath"
 )
 
-func writeTree(path string) ([]byte, error) {
+func writeTree(store objects.ObjectStore, path string) ([]byte, error) {
 	dirents, err := os.ReadDir(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "writeTree")
@@ -29,7 +30,7 @@ func writeTree(path string) ([]byte, error) {
 				continue
 			}
 
-			hash, err := writeTree(target)
+			hash, err := writeTree(store, target)
 			if err != nil {
 				return nil, err
 			}
@@ -43,7 +44,7 @@ func writeTree(path string) ([]byte, error) {
 			if err != nil {
 				return nil, errors.Wrap(err, "writeTree")
 			}
-			err = blob.Write()
+			err = blob.Write(store)
 			if err != nil {
 				return nil, errors.Wrap(err, "writeTree")
 			}
@@ -66,7 +67,7 @@ func writeTree(path string) ([]byte, error) {
 			if err != nil {
 				return nil, errors.Wrap(err, "writeTree")
 			}
-			err = blob.Write()
+			err = blob.Write(store)
 			if err != nil {
 				return nil, errors.Wrap(err, "writeTree")
 			}
@@ -81,7 +82,7 @@ func writeTree(path string) ([]byte, error) {
 		tree.Add(entry)
 	}
 
-	err = tree.Write()
+	err = tree.Write(store)
 	if err != nil {
 		return nil, errors.Wrap(err, "writeTree")
 	}
@@ -89,14 +90,42 @@ func writeTree(path string) ([]byte, error) {
 	return tree.Hash(), nil
 }
 
-func WriteTree() (string, error) {
+// WriteTree stores the work tree of the repository the current directory
+// is in as tree objects in store, returning the ID of the top tree. If
+// store supports transactions the objects are collected in memory and
+// written in one; otherwise they're written as they're made.
+func WriteTree(store objects.ObjectStore) (string, error) {
+	defer trace2.StartRegion("tree", "write-tree").End()
+
 	topLevel, err := paths.FindGitRoot()
 	if err != nil {
 		return "", errors.Wrap(err, "writing tree")
 	}
 
-	// Strip out the .git repository.
-	hash, err := writeTree(topLevel)
+	if !objects.Batches(store) {
+		// Strip out the .git repository.
+		hash, err := writeTree(store, topLevel)
+		if err != nil {
+			return "", errors.Wrap(err, "writing tree")
+		}
+		return fmt.Sprintf("%02x", hash), nil
+	}
+
+	batch := objects.NewMemoryStore()
+	hash, err := writeTree(batch, topLevel)
+	if err != nil {
+		return "", errors.Wrap(err, "writing tree")
+	}
+
+	var blobs []*objects.Blob
+	err = batch.Iterate(func(id string) error {
+		blob, err := batch.Read(id)
+		blobs = append(blobs, blob)
+		return err
+	})
+	if err == nil {
+		err = objects.WriteBatch(store, blobs)
+	}
 	if err != nil {
 		return "", errors.Wrap(err, "writing tree")
 	}
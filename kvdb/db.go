@@ -0,0 +1,314 @@
+// Package kvdb is a key-value database kept in a single file, as a
+// copy-on-write B+tree. Writes are made in transactions, which either take
+// effect whole or not at all, even if the process dies part way through
+// one. Processes sharing a database take turns with a file lock.
+package kvdb
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+// ErrNotFound is returned for a key that isn't stored.
+var ErrNotFound = errors.New("kvdb: key not found")
+
+type DB struct {
+	path string
+	file *os.File
+
+	// mu keeps the goroutines of this process to one writer or many
+	// readers; the file lock does the same for other processes, which
+	// hold it for as long as readers is nonzero.
+	mu        sync.RWMutex
+	readersMu sync.Mutex
+	readers   int
+}
+
+// Open opens the database at path, creating it if it doesn't exist.
+func Open(path string) (*DB, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "kvdb: opening "+path)
+	}
+
+	db := &DB{path: path, file: file}
+	err = db.lock()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	defer db.unlock()
+
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		err = db.create()
+	}
+	if err == nil {
+		_, err = db.meta()
+	}
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "kvdb: opening "+path)
+	}
+
+	return db, nil
+}
+
+// create writes an empty database: the meta pages, an empty leaf as the
+// root and an empty freelist.
+func (db *DB) create() error {
+	m := &meta{root: 2, freelist: 3, pages: 4}
+	pages := [][]byte{nil, nil, encodeNode(nil, true), encodeFreelist(nil, 1)}
+	for i := 2; i < len(pages); i++ {
+		err := db.writePage(uint64(i), pages[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	for txid := uint64(0); txid < 2; txid++ {
+		m.txid = txid
+		err := db.writePage(txid, m.encode())
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.file.Sync()
+}
+
+func (db *DB) Close() error {
+	return db.file.Close()
+}
+
+func (db *DB) lock() error {
+	db.mu.Lock()
+	err := lockFile(db.file, true)
+	if err != nil {
+		db.mu.Unlock()
+		return errors.Wrap(err, "kvdb: locking "+db.path)
+	}
+	return nil
+}
+
+func (db *DB) unlock() {
+	unlockFile(db.file)
+	db.mu.Unlock()
+}
+
+func (db *DB) rlock() error {
+	db.mu.RLock()
+	db.readersMu.Lock()
+	defer db.readersMu.Unlock()
+
+	if db.readers == 0 {
+		err := lockFile(db.file, false)
+		if err != nil {
+			db.mu.RUnlock()
+			return errors.Wrap(err, "kvdb: locking "+db.path)
+		}
+	}
+	db.readers++
+	return nil
+}
+
+func (db *DB) runlock() {
+	db.readersMu.Lock()
+	db.readers--
+	if db.readers == 0 {
+		unlockFile(db.file)
+	}
+	db.readersMu.Unlock()
+	db.mu.RUnlock()
+}
+
+func (db *DB) readPages(pgid uint64, count int) ([]byte, error) {
+	buf := make([]byte, count*pageSize)
+	_, err := db.file.ReadAt(buf, int64(pgid)*pageSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "kvdb: reading page %d", pgid)
+	}
+	return buf, nil
+}
+
+func (db *DB) writePage(pgid uint64, buf []byte) error {
+	_, err := db.file.WriteAt(buf, int64(pgid)*pageSize)
+	return errors.Wrapf(err, "kvdb: writing page %d", pgid)
+}
+
+// meta returns the current meta page.
+func (db *DB) meta() (*meta, error) {
+	buf, err := db.readPages(0, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	var current *meta
+	for i := 0; i < 2; i++ {
+		m, merr := decodeMeta(buf[i*pageSize : (i+1)*pageSize])
+		if merr != nil {
+			err = merr
+			continue
+		}
+
+		if current == nil || m.txid > current.txid {
+			current = m
+		}
+	}
+
+	if current == nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (db *DB) readNode(pgid uint64) (*node, error) {
+	buf, err := db.readPages(pgid, 1)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(pgid, buf)
+}
+
+// value returns the value of a leaf element, reading it from its pages
+// if it isn't inline.
+func (db *DB) value(e *element) ([]byte, error) {
+	if e.extent == 0 {
+		return append([]byte{}, e.value...), nil
+	}
+
+	// The size is read from the leaf, so check it against the file
+	// before allocating for it.
+	info, err := db.file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "kvdb: reading "+db.path)
+	}
+	if e.extent >= uint64(info.Size())/pageSize || uint64(e.size) > uint64(info.Size())-e.extent*pageSize {
+		return nil, fmt.Errorf("kvdb: value at page %d runs past the end of the database", e.extent)
+	}
+
+	buf := make([]byte, e.size)
+	_, err = db.file.ReadAt(buf, int64(e.extent)*pageSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "kvdb: reading page %d", e.extent)
+	}
+	return buf, nil
+}
+
+// leaf returns the element for key, if it's stored.
+func (db *DB) leaf(key []byte) (*element, error) {
+	m, err := db.meta()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := db.readNode(m.root)
+	for err == nil && !n.leaf {
+		n, err = db.readNode(n.elements[n.childIndex(key)].child)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	i, found := n.find(key)
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &n.elements[i], nil
+}
+
+// Has reports whether key is stored, without reading its value.
+func (db *DB) Has(key []byte) (bool, error) {
+	err := db.rlock()
+	if err != nil {
+		return false, err
+	}
+	defer db.runlock()
+
+	_, err = db.leaf(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get returns the value stored for key, or ErrNotFound.
+func (db *DB) Get(key []byte) ([]byte, error) {
+	err := db.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer db.runlock()
+
+	e, err := db.leaf(key)
+	if err != nil {
+		return nil, err
+	}
+	return db.value(e)
+}
+
+// Keys returns every key stored, in order.
+func (db *DB) Keys() ([][]byte, error) {
+	err := db.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer db.runlock()
+
+	m, err := db.meta()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][]byte
+	var walk func(pgid uint64) error
+	walk = func(pgid uint64) error {
+		n, err := db.readNode(pgid)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range n.elements {
+			if n.leaf {
+				keys = append(keys, e.key)
+				continue
+			}
+
+			err = walk(e.child)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = walk(m.root)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Update calls fn with a transaction, which is committed if fn returns
+// nil. If fn returns an error, nothing it wrote is kept. Only one
+// transaction runs at a time.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.commit()
+}
@@ -0,0 +1,204 @@
+package kvdb
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(i int) []byte {
+	sum := sha1.Sum([]byte(fmt.Sprint(i)))
+	return sum[:]
+}
+
+// testValue returns a value for key i; every seventh one is too large to
+// be kept in a leaf.
+func testValue(i, generation int) []byte {
+	size := 20 + i%300
+	if i%7 == 0 {
+		size = 3*pageSize + i
+	}
+	return bytes.Repeat([]byte(fmt.Sprintf("%d.%d;", i, generation)), size/8+1)
+}
+
+func checkValues(t *testing.T, db *DB, count, generation int) {
+	for i := 0; i < count; i++ {
+		value, err := db.Get(testKey(i))
+		if err != nil {
+			t.Fatalf("getting %d: %v", i, err)
+		}
+
+		if !bytes.Equal(value, testValue(i, generation)) {
+			t.Fatalf("value %d doesn't match", i)
+		}
+	}
+
+	keys, err := db.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != count {
+		t.Fatalf("expected %d keys, have %d", count, len(keys))
+	}
+
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			t.Fatalf("keys %x and %x are out of order", keys[i-1], keys[i])
+		}
+	}
+}
+
+func putValues(t *testing.T, db *DB, start, end, generation int) {
+	err := db.Update(func(tx *Tx) error {
+		for i := start; i < end; i++ {
+			err := tx.Put(testKey(i), testValue(i, generation))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 3000
+	for start := 0; start < count; start += 500 {
+		putValues(t, db, start, start+500, 0)
+	}
+	checkValues(t, db, count, 0)
+
+	_, err = db.Get(testKey(count))
+	if err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, have %v", err)
+	}
+
+	// A transaction that fails leaves nothing behind.
+	failed := errors.New("failed")
+	err = db.Update(func(tx *Tx) error {
+		tx.Put(testKey(count), []byte("new"))
+		tx.Put(testKey(0), []byte("changed"))
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected the transaction's error, have %v", err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkValues(t, db, count, 0)
+
+	// Replacing every value frees the pages the old ones were in, which
+	// later transactions reuse.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for generation := 1; generation <= 3; generation++ {
+		putValues(t, db, 0, count, generation)
+	}
+	checkValues(t, db, count, 3)
+
+	grown, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if grown.Size() > 2*info.Size() {
+		t.Fatalf("the database grew from %d to %d bytes", info.Size(), grown.Size())
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	err := os.WriteFile(path, bytes.Repeat([]byte("not a database"), pageSize), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(path)
+	if err == nil {
+		t.Fatal("expected an error opening something that isn't a database")
+	}
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putValues(t, db, 0, 10, 0)
+
+	m, err := db.meta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	valid, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each corruption overwrites four bytes of a page.
+	for i, corruption := range []struct {
+		pgid   uint64
+		offset int
+		value  []byte
+	}{
+		{m.root, 4, []byte{0xff, 0xff, 0xff, 0xff}},              // element count
+		{m.root, headerSize, []byte{0xff, 0xff, 0, 0}},           // first key size
+		{m.root, headerSize + 4, []byte{0xff, 0xff, 0xff, 0xff}}, // first value size
+		{m.freelist, 8, []byte{0xff, 0xff, 0xff, 0xff}},          // freelist overflow
+		{m.freelist, 4, []byte{0xff, 0xff, 0xff, 0xff}},          // freelist count
+	} {
+		corrupt := append([]byte(nil), valid...)
+		copy(corrupt[int(corruption.pgid)*pageSize+corruption.offset:], corruption.value)
+
+		path := filepath.Join(dir, fmt.Sprintf("corrupt%d.db", i))
+		err = os.WriteFile(path, corrupt, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := Open(path)
+		if err != nil {
+			continue
+		}
+
+		_, getErr := db.Get(testKey(1))
+		_, keysErr := db.Keys()
+		updateErr := db.Update(func(tx *Tx) error {
+			return tx.Put(testKey(1), testValue(1, 1))
+		})
+		db.Close()
+
+		if getErr == nil && keysErr == nil && updateErr == nil {
+			t.Fatalf("expected corruption %d to be noticed", i)
+		}
+	}
+}
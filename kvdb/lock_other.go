@@ -0,0 +1,18 @@
+//go:build !unix && !windows
+
+package kvdb
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+// There's no file locking here, so a database can't be shared safely and
+// isn't opened at all.
+func lockFile(file *os.File, exclusive bool) error {
+	return errors.New("file locking isn't supported on this platform")
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
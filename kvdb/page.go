@@ -0,0 +1,312 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"sort"
+)
+
+const (
+	pageSize   = 4096
+	headerSize = 16
+
+	// MaxKeySize is the longest key that can be stored.
+	MaxKeySize = 512
+
+	// Values larger than maxInlineValue are kept in pages of their own,
+	// out of the leaves, so that a leaf always holds at least two
+	// elements.
+	maxInlineValue = pageSize / 4
+
+	magic   = 0x6b766462 // "kvdb"
+	version = 1
+)
+
+// A page starts with its type, the number of elements in it and the
+// number of pages it runs on into.
+const (
+	pageMeta = iota + 1
+	pageBranch
+	pageLeaf
+	pageFreelist
+)
+
+type header struct {
+	kind     uint16
+	count    uint32
+	overflow uint32
+}
+
+func (h header) encode(buf []byte) {
+	binary.BigEndian.PutUint16(buf[0:], h.kind)
+	binary.BigEndian.PutUint32(buf[4:], h.count)
+	binary.BigEndian.PutUint32(buf[8:], h.overflow)
+}
+
+func decodeHeader(buf []byte) header {
+	return header{
+		kind:     binary.BigEndian.Uint16(buf[0:]),
+		count:    binary.BigEndian.Uint32(buf[4:]),
+		overflow: binary.BigEndian.Uint32(buf[8:]),
+	}
+}
+
+// pagesFor returns the number of pages size bytes take up.
+func pagesFor(size int) int {
+	return (size + pageSize - 1) / pageSize
+}
+
+// meta is the root of the database. There are two copies of it, in the
+// first two pages, which are written in turn; the valid one with the
+// highest transaction ID is current. A transaction only takes effect when
+// its meta page is written, so one that's interrupted leaves the database
+// as it was.
+type meta struct {
+	root     uint64
+	freelist uint64
+	pages    uint64
+	txid     uint64
+}
+
+const metaSize = headerSize + 48
+
+func (m *meta) encode() []byte {
+	buf := make([]byte, pageSize)
+	header{kind: pageMeta}.encode(buf)
+	binary.BigEndian.PutUint32(buf[16:], magic)
+	binary.BigEndian.PutUint32(buf[20:], version)
+	binary.BigEndian.PutUint32(buf[24:], pageSize)
+	binary.BigEndian.PutUint64(buf[32:], m.root)
+	binary.BigEndian.PutUint64(buf[40:], m.freelist)
+	binary.BigEndian.PutUint64(buf[48:], m.pages)
+	binary.BigEndian.PutUint64(buf[56:], m.txid)
+	binary.BigEndian.PutUint32(buf[metaSize:], crc32.ChecksumIEEE(buf[:metaSize]))
+	return buf
+}
+
+func decodeMeta(buf []byte) (*meta, error) {
+	if decodeHeader(buf).kind != pageMeta || binary.BigEndian.Uint32(buf[16:]) != magic {
+		return nil, errors.New("kvdb: not a database")
+	}
+
+	if v := binary.BigEndian.Uint32(buf[20:]); v != version {
+		return nil, fmt.Errorf("kvdb: unsupported version %d", v)
+	}
+
+	if size := binary.BigEndian.Uint32(buf[24:]); size != pageSize {
+		return nil, fmt.Errorf("kvdb: unsupported page size %d", size)
+	}
+
+	if binary.BigEndian.Uint32(buf[metaSize:]) != crc32.ChecksumIEEE(buf[:metaSize]) {
+		return nil, errors.New("kvdb: meta page checksum mismatch")
+	}
+
+	return &meta{
+		root:     binary.BigEndian.Uint64(buf[32:]),
+		freelist: binary.BigEndian.Uint64(buf[40:]),
+		pages:    binary.BigEndian.Uint64(buf[48:]),
+		txid:     binary.BigEndian.Uint64(buf[56:]),
+	}, nil
+}
+
+// element is an entry in a node. In a leaf, it's a key and its value,
+// which is either inline or kept in size bytes of pages starting at
+// extent. In a branch, it's the first key in a child node.
+type element struct {
+	key    []byte
+	value  []byte
+	extent uint64
+	size   uint32
+
+	child uint64
+	node  *node
+}
+
+// encodedSize returns the size of the element in a page.
+func (e *element) encodedSize(leaf bool) int {
+	if !leaf {
+		return 10 + len(e.key)
+	}
+
+	if e.extent != 0 {
+		return 16 + len(e.key)
+	}
+	return 8 + len(e.key) + len(e.value)
+}
+
+// node is a page of the B+tree as it's read into memory. Nodes changed
+// in a transaction are dirty, and are written to new pages when it
+// commits.
+type node struct {
+	pgid     uint64
+	leaf     bool
+	dirty    bool
+	elements []element
+}
+
+func decodeNode(pgid uint64, buf []byte) (*node, error) {
+	h := decodeHeader(buf)
+	if h.kind != pageBranch && h.kind != pageLeaf {
+		return nil, fmt.Errorf("kvdb: page %d isn't a node", pgid)
+	}
+
+	corrupt := fmt.Errorf("kvdb: page %d is corrupt", pgid)
+	leaf := h.kind == pageLeaf
+
+	// Every element has a fixed part of at least elementSize bytes, so a
+	// count that couldn't fit in the page is corrupt. A branch always has
+	// a child.
+	elementSize := 10
+	if leaf {
+		elementSize = 8
+	}
+	if len(buf) < headerSize || int(h.count) > (len(buf)-headerSize)/elementSize || (!leaf && h.count == 0) {
+		return nil, corrupt
+	}
+
+	n := &node{pgid: pgid, leaf: leaf, elements: make([]element, h.count)}
+	off := headerSize
+	for i := range n.elements {
+		e := &n.elements[i]
+		if off+elementSize > len(buf) {
+			return nil, corrupt
+		}
+
+		keySize := int(binary.BigEndian.Uint16(buf[off:]))
+		if !n.leaf {
+			e.child = binary.BigEndian.Uint64(buf[off+2:])
+			off += 10
+		} else {
+			extent := buf[off+2] == 1
+			e.size = binary.BigEndian.Uint32(buf[off+4:])
+			off += 8
+			if extent {
+				if off+8 > len(buf) {
+					return nil, corrupt
+				}
+				e.extent = binary.BigEndian.Uint64(buf[off:])
+				off += 8
+			}
+		}
+
+		if off+keySize > len(buf) {
+			return nil, corrupt
+		}
+		e.key = buf[off : off+keySize]
+		off += keySize
+
+		if n.leaf && e.extent == 0 {
+			if uint64(off)+uint64(e.size) > uint64(len(buf)) {
+				return nil, corrupt
+			}
+			e.value = buf[off : off+int(e.size)]
+			off += int(e.size)
+		}
+	}
+
+	return n, nil
+}
+
+func encodeNode(elements []element, leaf bool) []byte {
+	buf := make([]byte, pageSize)
+	h := header{kind: pageBranch, count: uint32(len(elements))}
+	if leaf {
+		h.kind = pageLeaf
+	}
+	h.encode(buf)
+
+	off := headerSize
+	for _, e := range elements {
+		binary.BigEndian.PutUint16(buf[off:], uint16(len(e.key)))
+		if !leaf {
+			binary.BigEndian.PutUint64(buf[off+2:], e.child)
+			off += 10
+		} else if e.extent != 0 {
+			buf[off+2] = 1
+			binary.BigEndian.PutUint32(buf[off+4:], e.size)
+			binary.BigEndian.PutUint64(buf[off+8:], e.extent)
+			off += 16
+		} else {
+			binary.BigEndian.PutUint32(buf[off+4:], uint32(len(e.value)))
+			off += 8
+		}
+
+		off += copy(buf[off:], e.key)
+		if leaf && e.extent == 0 {
+			off += copy(buf[off:], e.value)
+		}
+	}
+
+	return buf
+}
+
+// find returns the index of key in a leaf, or where it would go, and
+// whether it's there.
+func (n *node) find(key []byte) (int, bool) {
+	i := sort.Search(len(n.elements), func(i int) bool {
+		return bytes.Compare(n.elements[i].key, key) >= 0
+	})
+	return i, i < len(n.elements) && bytes.Equal(n.elements[i].key, key)
+}
+
+// childIndex returns the index of the child of a branch that key belongs
+// in.
+func (n *node) childIndex(key []byte) int {
+	i := sort.Search(len(n.elements), func(i int) bool {
+		return bytes.Compare(n.elements[i].key, key) > 0
+	})
+	return max(i-1, 0)
+}
+
+// split breaks a node's elements up into pages. A node that's outgrown
+// its page is split into pages about half full, leaving room for the
+// elements that will be added to them.
+func (n *node) split() [][]element {
+	size := headerSize
+	for i := range n.elements {
+		size += n.elements[i].encodedSize(n.leaf)
+	}
+
+	if size <= pageSize {
+		return [][]element{n.elements}
+	}
+
+	limit := headerSize + (pageSize-headerSize)/2
+	var chunks [][]element
+	start, size := 0, headerSize
+	for i := range n.elements {
+		elementSize := n.elements[i].encodedSize(n.leaf)
+		if i > start && size+elementSize > limit {
+			chunks = append(chunks, n.elements[start:i])
+			start, size = i, headerSize
+		}
+		size += elementSize
+	}
+
+	return append(chunks, n.elements[start:])
+}
+
+func encodeFreelist(ids []uint64, pages int) []byte {
+	buf := make([]byte, pages*pageSize)
+	header{kind: pageFreelist, count: uint32(len(ids)), overflow: uint32(pages - 1)}.encode(buf)
+	for i, id := range ids {
+		binary.BigEndian.PutUint64(buf[headerSize+8*i:], id)
+	}
+	return buf
+}
+
+func decodeFreelist(buf []byte) ([]uint64, error) {
+	h := decodeHeader(buf)
+	if h.kind != pageFreelist || int(h.count) > (len(buf)-headerSize)/8 {
+		return nil, errors.New("kvdb: freelist is corrupt")
+	}
+
+	ids := make([]uint64, h.count)
+	for i := range ids {
+		ids[i] = binary.BigEndian.Uint64(buf[headerSize+8*i:])
+	}
+	return ids, nil
+}
@@ -0,0 +1,239 @@
+package kvdb
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// Tx is a transaction writing to a database. The nodes it changes are
+// kept in memory until it commits, when they're written to pages that
+// aren't in use, so that the tree as it was is left intact until the
+// meta page is written.
+type Tx struct {
+	db   *DB
+	meta meta
+	root *node
+
+	// free holds the pages that can be reused. Pages this transaction
+	// stops using are pending; they're still part of the tree as it
+	// was, so they can't be reused until a later transaction.
+	free          []uint64
+	pending       []uint64
+	freelistPages int
+}
+
+func (db *DB) begin() (*Tx, error) {
+	m, err := db.meta()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Tx{db: db, meta: *m}
+	buf, err := db.readPages(m.freelist, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// The freelist can't run past the end of the database.
+	overflow := uint64(decodeHeader(buf).overflow)
+	if m.freelist >= m.pages || overflow >= m.pages-m.freelist {
+		return nil, errors.New("kvdb: freelist is corrupt")
+	}
+
+	tx.freelistPages = int(overflow) + 1
+	if tx.freelistPages > 1 {
+		buf, err = db.readPages(m.freelist, tx.freelistPages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tx.free, err = decodeFreelist(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.root, err = db.readNode(m.root)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// allocate returns the first of count contiguous pages, taken from the
+// freelist if it has a run of them, and from the end of the file if not.
+func (tx *Tx) allocate(count int) uint64 {
+	for i := 0; i+count <= len(tx.free); i++ {
+		if tx.free[i+count-1] == tx.free[i]+uint64(count-1) {
+			pgid := tx.free[i]
+			tx.free = append(tx.free[:i], tx.free[i+count:]...)
+			return pgid
+		}
+	}
+
+	pgid := tx.meta.pages
+	tx.meta.pages += uint64(count)
+	return pgid
+}
+
+func (tx *Tx) release(pgid uint64, count int) {
+	for i := 0; i < count; i++ {
+		tx.pending = append(tx.pending, pgid+uint64(i))
+	}
+}
+
+// leaf returns the leaf key belongs in, reading the nodes on the way to
+// it. If dirty is set, they're marked as changed.
+func (tx *Tx) leaf(key []byte, dirty bool) (*node, error) {
+	n := tx.root
+	for {
+		n.dirty = n.dirty || dirty
+		if n.leaf {
+			return n, nil
+		}
+
+		e := &n.elements[n.childIndex(key)]
+		if e.node == nil {
+			child, err := tx.db.readNode(e.child)
+			if err != nil {
+				return nil, err
+			}
+			e.node = child
+		}
+		n = e.node
+	}
+}
+
+// Has reports whether key is stored, including by this transaction.
+func (tx *Tx) Has(key []byte) (bool, error) {
+	n, err := tx.leaf(key, false)
+	if err != nil {
+		return false, err
+	}
+
+	_, found := n.find(key)
+	return found, nil
+}
+
+// Put stores value for key, replacing any value it already had.
+func (tx *Tx) Put(key, value []byte) error {
+	if len(key) == 0 || len(key) > MaxKeySize {
+		return fmt.Errorf("kvdb: invalid key size %d", len(key))
+	}
+
+	if uint64(len(value)) > 1<<32-1 {
+		return fmt.Errorf("kvdb: value for %x is too large", key)
+	}
+
+	n, err := tx.leaf(key, true)
+	if err != nil {
+		return err
+	}
+
+	e := element{key: append([]byte{}, key...)}
+	if len(value) > maxInlineValue {
+		e.extent = tx.allocate(pagesFor(len(value)))
+		e.size = uint32(len(value))
+		err = tx.db.writePage(e.extent, value)
+		if err != nil {
+			return err
+		}
+	} else {
+		e.value = append([]byte{}, value...)
+	}
+
+	i, found := n.find(key)
+	if found {
+		old := n.elements[i]
+		if old.extent != 0 {
+			tx.release(old.extent, pagesFor(int(old.size)))
+		}
+		n.elements[i] = e
+		return nil
+	}
+
+	n.elements = append(n.elements, element{})
+	copy(n.elements[i+1:], n.elements[i:])
+	n.elements[i] = e
+	return nil
+}
+
+// spill writes a dirty node and the dirty nodes under it to new pages,
+// returning the branch elements for the pages it was split into.
+func (tx *Tx) spill(n *node) ([]element, error) {
+	if !n.leaf {
+		var elements []element
+		for _, e := range n.elements {
+			if e.node == nil || !e.node.dirty {
+				elements = append(elements, e)
+				continue
+			}
+
+			spilled, err := tx.spill(e.node)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, spilled...)
+		}
+		n.elements = elements
+	}
+
+	if n.pgid != 0 {
+		tx.release(n.pgid, 1)
+	}
+
+	var spilled []element
+	for _, chunk := range n.split() {
+		pgid := tx.allocate(1)
+		err := tx.db.writePage(pgid, encodeNode(chunk, n.leaf))
+		if err != nil {
+			return nil, err
+		}
+		spilled = append(spilled, element{key: chunk[0].key, child: pgid})
+	}
+
+	return spilled, nil
+}
+
+// commit writes the changed nodes and the freelist, then, once they're
+// on disk, the meta page that makes them current.
+func (tx *Tx) commit() error {
+	if !tx.root.dirty {
+		return nil
+	}
+
+	elements, err := tx.spill(tx.root)
+	for err == nil && len(elements) > 1 {
+		elements, err = tx.spill(&node{elements: elements, dirty: true})
+	}
+	if err != nil {
+		return err
+	}
+	tx.meta.root = elements[0].child
+
+	tx.release(tx.meta.freelist, tx.freelistPages)
+	pages := pagesFor(headerSize + 8*(len(tx.free)+len(tx.pending)))
+	tx.meta.freelist = tx.allocate(pages)
+
+	free := append(tx.free, tx.pending...)
+	sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+	err = tx.db.writePage(tx.meta.freelist, encodeFreelist(free, pages))
+	if err != nil {
+		return err
+	}
+
+	err = tx.db.file.Sync()
+	if err != nil {
+		return errors.Wrap(err, "kvdb: syncing")
+	}
+
+	tx.meta.txid++
+	err = tx.db.writePage(tx.meta.txid%2, tx.meta.encode())
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(tx.db.file.Sync(), "kvdb: syncing")
+}
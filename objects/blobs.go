@@ -1,18 +1,13 @@
 package objects
 
 import (
-	"bytes"
-	"compress/zlib"
 	"crypto/sha1"
 	"flag"
 	"fmt"
-	"git.wntrmute.dev/kyle/goutils/die"
-	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
 	"github.com/pkg/errors"
 	"io"
 	"os"
-	"path/filepath"
-	"strconv"
 )
 
 var _ Object = &Blob{}
@@ -53,87 +48,20 @@ func (blob *Blob) ObjectType() string {
 	return blob.Type
 }
 
-func (blob *Blob) Write() error {
-	id := blob.HashString()
-	path, err := paths.PathFromID(id)
-	if err != nil {
-		return errors.Wrap(err, "couldn't find git path")
-	}
-
-	parent := filepath.Dir(path)
-	err = os.MkdirAll(parent, 0755)
-	if err != nil {
-		return errors.Wrap(err, "couldn't create parent directory "+parent)
-	}
-
-	file, err := os.Create(path)
-	if err != nil {
-		return errors.Wrap(err, "couldn't create file "+path)
-	}
-	defer file.Close()
-
-	encoder := zlib.NewWriter(file)
-	defer encoder.Close()
-
-	_, err = io.Copy(encoder, bytes.NewReader(blob.Raw()))
-	if err != nil {
-		return errors.Wrap(err, "couldn't write to file "+path)
-	}
-
-	return nil
+// Write stores the object in store.
+func (blob *Blob) Write(store ObjectStore) error {
+	return store.Write(blob)
 }
 
+// ReadBlobWithID reads an object from the repository the current directory
+// is in.
 func ReadBlobWithID(id string) (*Blob, error) {
-	path, err := paths.PathFromID(id)
+	store, err := RepositoryStore()
 	if err != nil {
 		return nil, errors.Wrap(err, "couldn't get path from id "+id)
 	}
 
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't open file with id "+id)
-	}
-
-	defer file.Close()
-
-	decoder, err := zlib.NewReader(file)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't create zlib reader")
-	}
-	defer decoder.Close()
-
-	contents, err := io.ReadAll(decoder)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't read object content for id "+id)
-	}
-
-	obj := &Blob{}
-	var header []byte
-
-	for i := range contents {
-		if contents[i] == 0x0 {
-			header = contents[:i]
-			obj.Contents = contents[i+1:]
-			break
-		}
-	}
-
-	headerParts := bytes.SplitN(header, []byte(" "), 2)
-	if len(headerParts) != 2 {
-		return nil, errors.New("invalid object header for id " + id + ", header: " + string(header))
-	}
-
-	obj.Type = string(headerParts[0])
-	size, err := strconv.Atoi(string(headerParts[1]))
-	if err != nil {
-		return nil, errors.Wrap(err, "invalid object header size for id "+id+", header: "+string(header))
-	}
-
-	if size != len(obj.Contents) {
-		return nil, fmt.Errorf("objects: header size mismatch: %d != %d", obj.Size(), len(obj.Contents))
-	}
-
-	return obj, nil
+	return store.Read(id)
 }
 
 func NewBlobFromFile(path string) (*Blob, error) {
@@ -165,7 +93,7 @@ func BlobFromBytes(content []byte) *Blob {
 
 func catBlob(id string) {
 	object, err := ReadBlobWithID(id)
-	die.If(err)
+	trace2.DieIf(err)
 
 	fmt.Print(object)
 }
@@ -177,7 +105,7 @@ func CatFile(args []string) {
 	flagset.StringVar(&objectPath, "p", "", "object `ID` as a hash")
 	err := flagset.Parse(args)
 	if err != nil {
-		die.If(err)
+		trace2.DieIf(err)
 	}
 
 	if objectPath != "" {
@@ -191,7 +119,13 @@ func Hash(args []string) {
 	flagset := flag.NewFlagSet("hash-object", flag.ExitOnError)
 	flagset.BoolVar(&writeObjects, "w", false, "write objects to git repository")
 	err := flagset.Parse(args)
-	die.If(err)
+	trace2.DieIf(err)
+
+	var store ObjectStore
+	if writeObjects {
+		store, err = RepositoryStore()
+		trace2.DieIf(err)
+	}
 
 	succeeded := true
 	for _, arg := range flagset.Args() {
@@ -204,7 +138,7 @@ func Hash(args []string) {
 
 		fmt.Printf("%s\n", blob.HashString())
 		if writeObjects {
-			err = blob.Write()
+			err = blob.Write(store)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "objects: couldn't write blob %s with id %s: %v\n",
 					arg, blob.HashString(), err)
@@ -215,6 +149,6 @@ func Hash(args []string) {
 	}
 
 	if !succeeded {
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 }
@@ -2,9 +2,11 @@ package objects
 
 import (
 	"fmt"
-	"git.wntrmute.dev/kyle/goutils/die"
 	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/pkg/errors"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -12,11 +14,13 @@ import (
 const TypeCommit = "commit"
 
 type Commit struct {
-	Author    string
-	Timestamp time.Time
-	Tree      string
-	Parents   []string
-	Message   string
+	Author     string
+	Timestamp  time.Time
+	Committer  string
+	CommitTime time.Time
+	Tree       string
+	Parents    []string
+	Message    string
 }
 
 func (c *Commit) parents() string {
@@ -25,12 +29,25 @@ func (c *Commit) parents() string {
 		buf += "parent " + parent + "\n"
 	}
 
-	return strings.TrimSpace(buf)
+	return buf
 }
 
+func (c *Commit) committer() (string, time.Time) {
+	if c.Committer == "" {
+		return c.Author, c.Timestamp
+	}
+
+	return c.Committer, c.CommitTime
+}
+
+// String returns the commit in the form git stores it, which its ID is the
+// hash of. The message always ends with a single newline, as git
+// commit-tree leaves it.
 func (c *Commit) String() string {
-	return fmt.Sprintf("tree %s\n%s\nauthor %s\ncommiter %s\n\n%s\n",
-		c.Tree, c.parents(), AuthorLine(c.Author, c.Timestamp), AuthorLine(c.Author, c.Timestamp), c.Message)
+	committer, commitTime := c.committer()
+	return fmt.Sprintf("tree %s\n%sauthor %s\ncommitter %s\n\n%s\n",
+		c.Tree, c.parents(), AuthorLine(c.Author, c.Timestamp), AuthorLine(committer, commitTime),
+		strings.TrimSuffix(c.Message, "\n"))
 }
 
 func (c *Commit) blob() *Blob {
@@ -48,8 +65,78 @@ func (c *Commit) HashString() string {
 	return c.blob().HashString()
 }
 
-func (c *Commit) Write() error {
-	return c.blob().Write()
+func (c *Commit) Write(store ObjectStore) error {
+	return c.blob().Write(store)
+}
+
+// parseAuthorLine splits "name <email> 1700000000 -0700" into the identity
+// and its timestamp.
+func parseAuthorLine(line string) (string, time.Time, error) {
+	end := strings.LastIndex(line, ">")
+	if end < 0 {
+		return "", time.Time{}, fmt.Errorf("invalid identity %q", line)
+	}
+
+	fields := strings.Fields(line[end+1:])
+	if len(fields) != 2 {
+		return "", time.Time{}, fmt.Errorf("invalid timestamp in identity %q", line)
+	}
+
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "parsing timestamp in identity")
+	}
+
+	zone, err := time.Parse(TimeFormat, fields[1])
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "parsing timezone in identity")
+	}
+
+	return line[:end+1], time.Unix(seconds, 0).In(zone.Location()), nil
+}
+
+func CommitFromBlob(blob *Blob) (*Commit, error) {
+	if blob.Type != TypeCommit {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", blob.HashString(), blob.Type)
+	}
+
+	commit := &Commit{}
+	header, message, _ := strings.Cut(string(blob.Contents), "\n\n")
+	commit.Message = message
+
+	var err error
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			commit.Author, commit.Timestamp, err = parseAuthorLine(value)
+		case "committer":
+			commit.Committer, commit.CommitTime, err = parseAuthorLine(value)
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing commit "+blob.HashString())
+		}
+	}
+
+	if commit.Tree == "" {
+		return nil, fmt.Errorf("commit %s has no tree", blob.HashString())
+	}
+
+	return commit, nil
+}
+
+func ReadCommit(store ObjectStore, id string) (*Commit, error) {
+	blob, err := store.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return CommitFromBlob(blob)
 }
 
 func NewCommitFromTree(tree, parent, message string) *Commit {
@@ -71,7 +158,7 @@ func CommitTree(args []string) {
 	var parent, message string
 	if len(args) < 3 {
 		fmt.Fprintf(os.Stderr, "Usage: commit [tree] [-p parent] [-m message]\n")
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
 	treeID := args[0]
@@ -86,7 +173,7 @@ func CommitTree(args []string) {
 		default:
 			fmt.Fprintf(os.Stderr, "Unknown argument '%s'\n", args[i])
 			fmt.Fprintf(os.Stderr, "Usage: commit [tree] [-p parent] [-m message]\n")
-			os.Exit(1)
+			trace2.ExitProcess(1)
 		}
 		i++
 	}
@@ -94,17 +181,20 @@ func CommitTree(args []string) {
 	if message == "" {
 		fmt.Fprintf(os.Stderr, "missing commit message\n")
 		fmt.Fprintf(os.Stderr, "Usage: %s commit-tree <tree_sha> -m <message>\n", os.Args[0])
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
 	commit := NewCommitFromTree(treeID, parent, message)
 	commit.Author = DefaultAuthor()
 
-	err := commit.Write()
-	die.If(err)
+	store, err := RepositoryStore()
+	trace2.DieIf(err)
+
+	err = commit.Write(store)
+	trace2.DieIf(err)
 
 	err = paths.WriteRef("heads/master", commit.HashString())
-	die.If(err)
+	trace2.DieIf(err)
 
 	fmt.Println(commit.HashString())
 }
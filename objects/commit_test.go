@@ -0,0 +1,90 @@
+package objects
+
+import (
+	"testing"
+	"time"
+)
+
+// The expected objects were made by git commit-tree, with the author and
+// committer set in the environment.
+func TestCommitString(t *testing.T) {
+	author := Author("A U Thor", "author@example.com")
+	authorTime := time.Unix(1700000000, 0).In(time.FixedZone("", -7*3600))
+	committer := Author("C O Mitter", "committer@example.com")
+	commitTime := time.Unix(1700003600, 0).In(time.FixedZone("", 2*3600))
+	emptyTree := "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+	testCases := []struct {
+		Commit   *Commit
+		ID       string
+		Contents string
+	}{
+		{
+			Commit: &Commit{
+				Author: author, Timestamp: authorTime,
+				Committer: committer, CommitTime: commitTime,
+				Tree:    emptyTree,
+				Message: "root commit",
+			},
+			ID: "4a1b808b7b825802cb97df946c15c149c259c39c",
+			Contents: "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+				"author A U Thor <author@example.com> 1700000000 -0700\n" +
+				"committer C O Mitter <committer@example.com> 1700003600 +0200\n" +
+				"\n" +
+				"root commit\n",
+		},
+		{
+			Commit: &Commit{
+				Author: author, Timestamp: authorTime,
+				Committer: committer, CommitTime: commitTime,
+				Tree: emptyTree,
+				Parents: []string{
+					"4a1b808b7b825802cb97df946c15c149c259c39c",
+					"efb11d3ccbb0ede642d54a6470cbfa74a8ff3d30",
+				},
+				Message: "merge\n\nwith a body\n",
+			},
+			ID: "d76ad3273afad87c20ccb55269375f0cb892fd42",
+			Contents: "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+				"parent 4a1b808b7b825802cb97df946c15c149c259c39c\n" +
+				"parent efb11d3ccbb0ede642d54a6470cbfa74a8ff3d30\n" +
+				"author A U Thor <author@example.com> 1700000000 -0700\n" +
+				"committer C O Mitter <committer@example.com> 1700003600 +0200\n" +
+				"\n" +
+				"merge\n\nwith a body\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		if contents := tc.Commit.String(); contents != tc.Contents {
+			t.Fatalf("expected commit\n%q\nhave\n%q", tc.Contents, contents)
+		}
+
+		if id := tc.Commit.HashString(); id != tc.ID {
+			t.Fatalf("expected commit %s, have %s", tc.ID, id)
+		}
+
+		// A commit that's read back is written out the same.
+		commit, err := CommitFromBlob(tc.Commit.blob())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if commit.HashString() != tc.ID {
+			t.Fatalf("commit %s read back as %s", tc.ID, commit.HashString())
+		}
+	}
+
+	// Without a committer, the author is the committer.
+	commit := *testCases[0].Commit
+	commit.Committer = ""
+	commit.CommitTime = time.Time{}
+	expected := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author A U Thor <author@example.com> 1700000000 -0700\n" +
+		"committer A U Thor <author@example.com> 1700000000 -0700\n" +
+		"\n" +
+		"root commit\n"
+	if commit.String() != expected {
+		t.Fatalf("expected commit\n%q\nhave\n%q", expected, commit.String())
+	}
+}
@@ -0,0 +1,110 @@
+package objects
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ReadDeltaSize decodes one of the little-endian base-128 sizes at the start
+// of a delta, returning the size and the number of bytes consumed.
+func ReadDeltaSize(delta []byte) (uint64, int, error) {
+	var size uint64
+	var shift uint
+
+	for i := 0; i < len(delta); i++ {
+		size |= uint64(delta[i]&0x7f) << shift
+		shift += 7
+		if delta[i]&0x80 == 0 {
+			return size, i + 1, nil
+		}
+	}
+
+	return 0, 0, errors.New("delta: truncated size")
+}
+
+// ApplyDelta reconstructs an object from its base and a git delta.
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, n, err := ReadDeltaSize(delta)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading delta base size")
+	}
+	delta = delta[n:]
+
+	if baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("delta: base size mismatch; have %d, want %d", len(base), baseSize)
+	}
+
+	resultSize, n, err := ReadDeltaSize(delta)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading delta result size")
+	}
+	delta = delta[n:]
+
+	// The size comes from the delta, which may not be trusted: each
+	// instruction byte yields at most 64 KiB, and the buffer only starts
+	// out as large as the base and delta together.
+	if resultSize > uint64(len(delta))*0x10000 {
+		return nil, fmt.Errorf("delta: result size %d is larger than the delta can produce", resultSize)
+	}
+
+	capacity := resultSize
+	if limit := uint64(len(base) + len(delta)); capacity > limit {
+		capacity = limit
+	}
+
+	result := make([]byte, 0, capacity)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case op&0x80 != 0:
+			var offset, size uint64
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+
+				if len(delta) == 0 {
+					return nil, errors.New("delta: truncated copy instruction")
+				}
+
+				if i < 4 {
+					offset |= uint64(delta[0]) << (8 * i)
+				} else {
+					size |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+
+			if size == 0 {
+				size = 0x10000
+			}
+
+			if offset+size > uint64(len(base)) {
+				return nil, fmt.Errorf("delta: copy out of bounds (offset %d, size %d, base %d)",
+					offset, size, len(base))
+			}
+			result = append(result, base[offset:offset+size]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, errors.New("delta: truncated insert instruction")
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("delta: invalid opcode 0")
+		}
+
+		if uint64(len(result)) > resultSize {
+			return nil, fmt.Errorf("delta: result is larger than its size %d", resultSize)
+		}
+	}
+
+	if uint64(len(result)) != resultSize {
+		return nil, fmt.Errorf("delta: result size mismatch; have %d, want %d", len(result), resultSize)
+	}
+
+	return result, nil
+}
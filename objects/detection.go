@@ -13,6 +13,7 @@ type Object interface {
 const (
 	TypeBlob = "blob"
 	TypeTree = "tree"
+	TypeTag  = "tag"
 )
 
 func ReadObjectFromFile(id string) (Object, error) {
@@ -26,6 +27,8 @@ func ReadObjectFromFile(id string) (Object, error) {
 		return obj, nil
 	case "tree":
 		return TreeFromBlob(obj)
+	case "commit", "tag":
+		return obj, nil
 	}
 
 	panic("unknown object type " + obj.Type)
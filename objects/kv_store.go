@@ -0,0 +1,174 @@
+package objects
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"github.com/kisom/codecrafters/git-go/kvdb"
+	"github.com/pkg/errors"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// KVStoreName is the name of the database a KVStore keeps in an objects
+// directory.
+const KVStoreName = "objects.db"
+
+// KVStore keeps objects in a single-file key-value database, keyed by
+// their raw IDs and compressed as loose objects are. A repository with
+// millions of objects takes up one file rather than millions.
+type KVStore struct {
+	Path string
+}
+
+func NewKVStore(path string) *KVStore {
+	return &KVStore{Path: path}
+}
+
+var (
+	kvDatabasesLock sync.Mutex
+	kvDatabases     = map[string]*kvdb.DB{}
+)
+
+// db opens the store's database. It stays open until the process exits,
+// shared by every store using it.
+func (s *KVStore) db() (*kvdb.DB, error) {
+	path, err := filepath.Abs(s.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't find object database")
+	}
+
+	kvDatabasesLock.Lock()
+	defer kvDatabasesLock.Unlock()
+
+	db, ok := kvDatabases[path]
+	if !ok {
+		db, err = kvdb.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		kvDatabases[path] = db
+	}
+
+	return db, nil
+}
+
+func (s *KVStore) Has(id string) bool {
+	rawID, err := hex.DecodeString(id)
+	if err != nil {
+		return false
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return false
+	}
+
+	ok, _ := db.Has(rawID)
+	return ok
+}
+
+func (s *KVStore) get(id string) ([]byte, error) {
+	rawID, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid object id "+id)
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := db.Get(rawID)
+	if err == kvdb.ErrNotFound {
+		return nil, &MissingObjectError{ID: id}
+	}
+	return data, errors.Wrap(err, "couldn't read object with id "+id)
+}
+
+func (s *KVStore) Read(id string) (*Blob, error) {
+	data, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadLooseObject(bytes.NewReader(data), id)
+}
+
+func compressObject(blob *Blob) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := zlib.NewWriter(buf)
+	_, err := encoder.Write(blob.Raw())
+	if err == nil {
+		err = encoder.Close()
+	}
+	return buf.Bytes(), errors.Wrap(err, "couldn't compress object")
+}
+
+func (s *KVStore) Write(blob *Blob) error {
+	return s.WriteBatch([]*Blob{blob})
+}
+
+// WriteBatch stores objects in a single transaction: either they're all
+// stored, or none of them are.
+func (s *KVStore) WriteBatch(blobs []*Blob) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *kvdb.Tx) error {
+		for _, blob := range blobs {
+			found, err := tx.Has(blob.Hash())
+			if err != nil {
+				return err
+			}
+
+			if found {
+				continue
+			}
+
+			data, err := compressObject(blob)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Put(blob.Hash(), data)
+			if err != nil {
+				return errors.Wrap(err, "couldn't store object with id "+blob.HashString())
+			}
+		}
+		return nil
+	})
+}
+
+func (s *KVStore) Stream(id string) (string, int64, io.ReadCloser, error) {
+	data, err := s.get(id)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	return streamLoose(io.NopCloser(bytes.NewReader(data)), id)
+}
+
+func (s *KVStore) Iterate(fn func(id string) error) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	keys, err := db.Keys()
+	if err != nil {
+		return errors.Wrap(err, "listing objects")
+	}
+
+	for _, key := range keys {
+		err = fn(hex.EncodeToString(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package objects
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// LooseStore keeps each object compressed in a file of its own, named by
+// its ID, under an objects directory.
+type LooseStore struct {
+	Dir string
+}
+
+func NewLooseStore(objectsDir string) *LooseStore {
+	return &LooseStore{Dir: objectsDir}
+}
+
+func (s *LooseStore) Has(id string) bool {
+	path, err := paths.PathFromIDInDir(s.Dir, id)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func (s *LooseStore) Read(id string) (*Blob, error) {
+	path, err := paths.PathFromIDInDir(s.Dir, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get path from id "+id)
+	}
+
+	obj, err := readLooseObject(path, id)
+	return obj, missing(id, err)
+}
+
+func (s *LooseStore) Write(blob *Blob) error {
+	id := blob.HashString()
+	path, err := paths.PathFromIDInDir(s.Dir, id)
+	if err != nil {
+		return errors.Wrap(err, "couldn't find git path")
+	}
+
+	// Objects never change, so one that's already there can be left
+	// alone; another process may be reading it.
+	if _, err = os.Stat(path); err == nil {
+		return nil
+	}
+
+	parent := filepath.Dir(path)
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create parent directory "+parent)
+	}
+
+	// Write to a temporary file and rename it into place, so readers
+	// never see a partially written object.
+	file, err := os.CreateTemp(parent, "tmp_obj_")
+	if err != nil {
+		return errors.Wrap(err, "couldn't create temporary file in "+parent)
+	}
+	defer os.Remove(file.Name())
+
+	encoder := zlib.NewWriter(file)
+	_, err = io.Copy(encoder, bytes.NewReader(blob.Raw()))
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err == nil {
+		err = file.Chmod(0444)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "couldn't write to file "+file.Name())
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "couldn't rename object into place at "+path)
+	}
+
+	return nil
+}
+
+// looseStream reads an object's contents as they're decompressed.
+type looseStream struct {
+	io.Reader
+	decoder io.Closer
+	file    io.Closer
+}
+
+func (ls *looseStream) Close() error {
+	ls.decoder.Close()
+	return ls.file.Close()
+}
+
+// readLooseHeader reads the "<type> <size>\x00" an object starts with.
+func readLooseHeader(r *bufio.Reader, id string) (string, int64, error) {
+	objectType, err := r.ReadString(' ')
+	if err != nil {
+		return "", 0, errors.Wrap(err, "invalid object header for id "+id)
+	}
+
+	size, err := r.ReadString(0)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "invalid object header for id "+id)
+	}
+
+	n, err := strconv.ParseInt(size[:len(size)-1], 10, 64)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "invalid object header size for id "+id)
+	}
+
+	return objectType[:len(objectType)-1], n, nil
+}
+
+func (s *LooseStore) Stream(id string) (string, int64, io.ReadCloser, error) {
+	path, err := paths.PathFromIDInDir(s.Dir, id)
+	if err != nil {
+		return "", 0, nil, errors.Wrap(err, "couldn't get path from id "+id)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, nil, missing(id, errors.Wrap(err, "couldn't open file with id "+id))
+	}
+
+	return streamLoose(file, id)
+}
+
+// streamLoose streams an object in the compressed form it's stored in as a
+// loose object, closing file once the stream is closed.
+func streamLoose(file io.ReadCloser, id string) (string, int64, io.ReadCloser, error) {
+	decoder, err := zlib.NewReader(file)
+	if err != nil {
+		file.Close()
+		return "", 0, nil, errors.Wrap(err, "couldn't create zlib reader")
+	}
+
+	r := bufio.NewReader(decoder)
+	objectType, size, err := readLooseHeader(r, id)
+	if err != nil {
+		decoder.Close()
+		file.Close()
+		return "", 0, nil, err
+	}
+
+	return objectType, size, &looseStream{Reader: io.LimitReader(r, size), decoder: decoder, file: file}, nil
+}
+
+func (s *LooseStore) Iterate(fn func(id string) error) error {
+	dirs, err := filepath.Glob(filepath.Join(s.Dir, "[0-9a-f][0-9a-f]"))
+	if err != nil {
+		return errors.Wrap(err, "listing loose objects")
+	}
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return errors.Wrap(err, "listing loose objects")
+		}
+
+		for _, entry := range entries {
+			id := filepath.Base(dir) + entry.Name()
+			if len(id) != paths.ObjectIDLength {
+				continue
+			}
+
+			err = fn(id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readLooseObject(path, id string) (*Blob, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open file with id "+id)
+	}
+
+	defer file.Close()
+
+	return ReadLooseObject(file, id)
+}
+
+// ReadLooseObject reads an object in the compressed form it's stored in as
+// a loose object.
+func ReadLooseObject(r io.Reader, id string) (*Blob, error) {
+	decoder, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create zlib reader")
+	}
+	defer decoder.Close()
+
+	contents, err := io.ReadAll(decoder)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read object content for id "+id)
+	}
+
+	obj := &Blob{}
+	var header []byte
+
+	for i := range contents {
+		if contents[i] == 0x0 {
+			header = contents[:i]
+			obj.Contents = contents[i+1:]
+			break
+		}
+	}
+
+	headerParts := bytes.SplitN(header, []byte(" "), 2)
+	if len(headerParts) != 2 {
+		return nil, errors.New("invalid object header for id " + id + ", header: " + string(header))
+	}
+
+	obj.Type = string(headerParts[0])
+	size, err := strconv.Atoi(string(headerParts[1]))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid object header size for id "+id+", header: "+string(header))
+	}
+
+	if size != len(obj.Contents) {
+		return nil, fmt.Errorf("objects: header size mismatch: %d != %d", obj.Size(), len(obj.Contents))
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,73 @@
+package objects
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps objects in memory, for when they don't need to
+// outlive the process.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]*Blob
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string]*Blob{}}
+}
+
+func (s *MemoryStore) Has(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.objects[id]
+	return ok
+}
+
+func (s *MemoryStore) Read(id string) (*Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, ok := s.objects[id]
+	if !ok {
+		return nil, &MissingObjectError{ID: id}
+	}
+	return &Blob{Type: blob.Type, Contents: blob.Contents}, nil
+}
+
+// Write stores a copy of the object, so the caller may go on changing
+// its contents.
+func (s *MemoryStore) Write(blob *Blob) error {
+	stored := &Blob{Type: blob.Type, Contents: append([]byte{}, blob.Contents...)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[stored.HashString()] = stored
+	return nil
+}
+
+func (s *MemoryStore) Stream(id string) (string, int64, io.ReadCloser, error) {
+	return streamBlob(s.Read(id))
+}
+
+// Iterate visits the objects in order of their IDs. The store may be
+// written to while it's iterated over.
+func (s *MemoryStore) Iterate(fn func(id string) error) error {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.objects))
+	for id := range s.objects {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		err := fn(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
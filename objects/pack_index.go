@@ -0,0 +1,209 @@
+package objects
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+)
+
+var packIndexMagic = []byte{0xff, 't', 'O', 'c'}
+
+const packIndexVersion = 2
+
+// PackIndex is a version 2 pack index (.idx). Entries are sorted by ID.
+type PackIndex struct {
+	Fanout       [256]uint32
+	IDs          [][]byte
+	CRCs         []uint32
+	Offsets      []uint64
+	PackChecksum []byte
+}
+
+func (idx *PackIndex) Len() int {
+	return len(idx.IDs)
+}
+
+func (idx *PackIndex) Lookup(id []byte) (uint64, bool) {
+	if len(id) == 0 {
+		return 0, false
+	}
+
+	lo := uint32(0)
+	if id[0] > 0 {
+		lo = idx.Fanout[id[0]-1]
+	}
+	hi := idx.Fanout[id[0]]
+
+	n := sort.Search(int(hi-lo), func(i int) bool {
+		return bytes.Compare(idx.IDs[int(lo)+i], id) >= 0
+	})
+
+	i := int(lo) + n
+	if i < int(hi) && bytes.Equal(idx.IDs[i], id) {
+		return idx.Offsets[i], true
+	}
+
+	return 0, false
+}
+
+func ReadPackIndex(r io.Reader) (*PackIndex, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, errors.Wrap(err, "reading pack index header")
+	}
+
+	if !bytes.Equal(header[:4], packIndexMagic) {
+		return nil, fmt.Errorf("pack index has invalid magic %x", header[:4])
+	}
+
+	if version := binary.BigEndian.Uint32(header[4:]); version != packIndexVersion {
+		return nil, fmt.Errorf("unsupported pack index version %d", version)
+	}
+
+	idx := &PackIndex{}
+	if err := binary.Read(r, binary.BigEndian, idx.Fanout[:]); err != nil {
+		return nil, errors.Wrap(err, "reading pack index fanout table")
+	}
+
+	for i := 1; i < len(idx.Fanout); i++ {
+		if idx.Fanout[i] < idx.Fanout[i-1] {
+			return nil, fmt.Errorf("pack index fanout table decreases at entry %d", i)
+		}
+	}
+
+	// The object count comes from the file, so the IDs are read into a
+	// buffer that grows as they arrive rather than allocated up front: a
+	// corrupt count runs out of file before it runs out of memory. The
+	// tables after them are then no larger than what's been read.
+	count := int(idx.Fanout[255])
+	idBuf := &bytes.Buffer{}
+	if _, err := io.CopyN(idBuf, r, int64(count)*20); err != nil {
+		return nil, errors.Wrap(err, "reading pack index object IDs")
+	}
+	ids := idBuf.Bytes()
+
+	idx.IDs = make([][]byte, count)
+	for i := range idx.IDs {
+		idx.IDs[i] = ids[i*20 : (i+1)*20]
+	}
+
+	idx.CRCs = make([]uint32, count)
+	if err := binary.Read(r, binary.BigEndian, idx.CRCs); err != nil {
+		return nil, errors.Wrap(err, "reading pack index CRCs")
+	}
+
+	offsets := make([]uint32, count)
+	if err := binary.Read(r, binary.BigEndian, offsets); err != nil {
+		return nil, errors.Wrap(err, "reading pack index offsets")
+	}
+
+	large := 0
+	for _, offset := range offsets {
+		if offset&0x80000000 != 0 {
+			large++
+		}
+	}
+
+	largeOffsets := make([]uint64, large)
+	if err := binary.Read(r, binary.BigEndian, largeOffsets); err != nil {
+		return nil, errors.Wrap(err, "reading pack index large offsets")
+	}
+
+	idx.Offsets = make([]uint64, count)
+	for i, offset := range offsets {
+		if offset&0x80000000 == 0 {
+			idx.Offsets[i] = uint64(offset)
+			continue
+		}
+
+		j := int(offset & 0x7fffffff)
+		if j >= len(largeOffsets) {
+			return nil, fmt.Errorf("pack index large offset %d out of range", j)
+		}
+		idx.Offsets[i] = largeOffsets[j]
+	}
+
+	idx.PackChecksum = make([]byte, 20)
+	if _, err := io.ReadFull(r, idx.PackChecksum); err != nil {
+		return nil, errors.Wrap(err, "reading pack index pack checksum")
+	}
+
+	// All that's left should be the index's own checksum.
+	n, err := io.ReadFull(r, make([]byte, 21))
+	if n != 20 || err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("pack index is the wrong length for %d objects", count)
+	}
+
+	return idx, nil
+}
+
+type PackIndexEntry struct {
+	ID     []byte
+	CRC    uint32
+	Offset uint64
+}
+
+func NewPackIndex(entries []PackIndexEntry, packChecksum []byte) *PackIndex {
+	sorted := make([]PackIndexEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].ID, sorted[j].ID) < 0
+	})
+
+	idx := &PackIndex{
+		IDs:          make([][]byte, len(sorted)),
+		CRCs:         make([]uint32, len(sorted)),
+		Offsets:      make([]uint64, len(sorted)),
+		PackChecksum: packChecksum,
+	}
+
+	for i, entry := range sorted {
+		idx.IDs[i] = entry.ID
+		idx.CRCs[i] = entry.CRC
+		idx.Offsets[i] = entry.Offset
+		idx.Fanout[entry.ID[0]]++
+	}
+
+	for i := 1; i < len(idx.Fanout); i++ {
+		idx.Fanout[i] += idx.Fanout[i-1]
+	}
+
+	return idx
+}
+
+// WriteTo writes the index in version 2 format, followed by its own SHA-1.
+func (idx *PackIndex) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	buf.Write(packIndexMagic)
+	binary.Write(buf, binary.BigEndian, uint32(packIndexVersion))
+	binary.Write(buf, binary.BigEndian, idx.Fanout[:])
+
+	for _, id := range idx.IDs {
+		buf.Write(id)
+	}
+
+	binary.Write(buf, binary.BigEndian, idx.CRCs)
+
+	var largeOffsets []uint64
+	for _, offset := range idx.Offsets {
+		if offset < 0x80000000 {
+			binary.Write(buf, binary.BigEndian, uint32(offset))
+			continue
+		}
+
+		binary.Write(buf, binary.BigEndian, uint32(len(largeOffsets))|0x80000000)
+		largeOffsets = append(largeOffsets, offset)
+	}
+
+	binary.Write(buf, binary.BigEndian, largeOffsets)
+	buf.Write(idx.PackChecksum)
+
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	return buf.WriteTo(w)
+}
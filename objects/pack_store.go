@@ -0,0 +1,80 @@
+package objects
+
+import (
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+// PackStore reads the objects in the packs in an objects directory. Packs
+// are written whole, by indexing a pack, so objects can't be written to
+// one. Delta bases that aren't in the same pack are read from Bases,
+// which is the packs themselves unless it's set.
+type PackStore struct {
+	Dir   string
+	Bases ObjectStore
+}
+
+func NewPackStore(objectsDir string) *PackStore {
+	return &PackStore{Dir: objectsDir}
+}
+
+func (s *PackStore) Has(id string) bool {
+	rawID, err := hex.DecodeString(id)
+	if err != nil {
+		return false
+	}
+
+	packs, err := PacksInDir(s.Dir)
+	if err != nil {
+		return false
+	}
+
+	for _, pack := range packs {
+		if pack.Has(rawID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *PackStore) Read(id string) (*Blob, error) {
+	bases := s.Bases
+	if bases == nil {
+		bases = s
+	}
+
+	obj, err := readPackedObject(s.Dir, id, bases.Read)
+	if os.IsNotExist(err) {
+		return nil, &MissingObjectError{ID: id}
+	}
+	return obj, errors.Wrap(err, "couldn't read packed object with id "+id)
+}
+
+func (s *PackStore) Write(blob *Blob) error {
+	return errors.New("objects: a pack store can't be written to")
+}
+
+func (s *PackStore) Stream(id string) (string, int64, io.ReadCloser, error) {
+	return streamBlob(s.Read(id))
+}
+
+func (s *PackStore) Iterate(fn func(id string) error) error {
+	packs, err := PacksInDir(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, pack := range packs {
+		for _, rawID := range pack.Index.IDs {
+			err = fn(hex.EncodeToString(rawID))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package objects
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var packMagic = []byte("PACK")
+
+// maxDeltaDepth bounds delta chain resolution so that a corrupt pack can't
+// send us into an endless loop.
+const maxDeltaDepth = 4096
+
+type PackObjectType byte
+
+const (
+	PackCommit      PackObjectType = 1
+	PackTree        PackObjectType = 2
+	PackBlob        PackObjectType = 3
+	PackTag         PackObjectType = 4
+	PackOffsetDelta PackObjectType = 6
+	PackRefDelta    PackObjectType = 7
+)
+
+var packObjectTypeNames = map[PackObjectType]string{
+	PackCommit:      TypeCommit,
+	PackTree:        TypeTree,
+	PackBlob:        TypeBlob,
+	PackTag:         TypeTag,
+	PackOffsetDelta: "ofs-delta",
+	PackRefDelta:    "ref-delta",
+}
+
+func (t PackObjectType) String() string {
+	if name, ok := packObjectTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", byte(t))
+}
+
+func (t PackObjectType) IsDelta() bool {
+	return t == PackOffsetDelta || t == PackRefDelta
+}
+
+func PackObjectTypeFor(objectType string) (PackObjectType, error) {
+	switch objectType {
+	case TypeCommit:
+		return PackCommit, nil
+	case TypeTree:
+		return PackTree, nil
+	case TypeBlob:
+		return PackBlob, nil
+	case TypeTag:
+		return PackTag, nil
+	}
+
+	return 0, fmt.Errorf("object type %q can't be stored in a pack", objectType)
+}
+
+// ReadPackEntryHeader reads the type and inflated size that start every
+// pack entry.
+func ReadPackEntryHeader(r io.ByteReader) (PackObjectType, uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	objectType := PackObjectType((b >> 4) & 0x7)
+	size := uint64(b & 0x0f)
+	shift := uint(4)
+
+	for b&0x80 != 0 {
+		if shift > 63 {
+			return 0, 0, errors.New("pack entry size overflows")
+		}
+
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "reading pack entry size")
+		}
+
+		size |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+
+	return objectType, size, nil
+}
+
+func EncodePackEntryHeader(objectType PackObjectType, size uint64) []byte {
+	b := byte(objectType)<<4 | byte(size&0x0f)
+	size >>= 4
+
+	var header []byte
+	for size != 0 {
+		header = append(header, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+
+	return append(header, b)
+}
+
+func EncodeOffsetDeltaDistance(distance uint64) []byte {
+	encoded := []byte{byte(distance & 0x7f)}
+	distance >>= 7
+
+	for distance != 0 {
+		distance--
+		encoded = append([]byte{byte(0x80 | (distance & 0x7f))}, encoded...)
+		distance >>= 7
+	}
+
+	return encoded
+}
+
+// ReadOffsetDeltaDistance reads the negative offset to the base object of an
+// OFS_DELTA entry.
+func ReadOffsetDeltaDistance(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	distance := uint64(b & 0x7f)
+	for b&0x80 != 0 {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		distance = ((distance + 1) << 7) | uint64(b&0x7f)
+	}
+
+	return distance, nil
+}
+
+func inflate(r io.Reader, size uint64) ([]byte, error) {
+	decoder, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create zlib reader")
+	}
+	defer decoder.Close()
+
+	if size >= math.MaxInt64 {
+		return nil, fmt.Errorf("pack entry size %d is too large", size)
+	}
+
+	// The size is read from the pack, so the buffer grows with the data
+	// rather than being allocated from it. One byte more than the size is
+	// asked for, to catch entries that inflate to too much.
+	contents, err := io.ReadAll(io.LimitReader(decoder, int64(size)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "inflating pack entry")
+	}
+
+	if uint64(len(contents)) != size {
+		return nil, fmt.Errorf("pack entry inflated to %d bytes, want %d", len(contents), size)
+	}
+
+	return contents, nil
+}
+
+// Resolver looks up objects that a pack refers to but doesn't contain.
+type Resolver func(id string) (*Blob, error)
+
+type Packfile struct {
+	Path  string
+	Index *PackIndex
+
+	file *os.File
+	size int64
+}
+
+func IndexPathForPack(packPath string) string {
+	return strings.TrimSuffix(packPath, ".pack") + ".idx"
+}
+
+func OpenPackfile(packPath string) (*Packfile, error) {
+	indexFile, err := os.Open(IndexPathForPack(packPath))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening pack index")
+	}
+	defer indexFile.Close()
+
+	index, err := ReadPackIndex(bufio.NewReader(indexFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading pack index for "+packPath)
+	}
+
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening packfile")
+	}
+
+	var header [12]byte
+	_, err = io.ReadFull(file, header[:])
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "reading packfile header")
+	}
+
+	if !bytes.Equal(header[:4], packMagic) {
+		file.Close()
+		return nil, fmt.Errorf("packfile %s has invalid magic %x", packPath, header[:4])
+	}
+
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 && version != 3 {
+		file.Close()
+		return nil, fmt.Errorf("packfile %s has unsupported version %d", packPath, version)
+	}
+
+	if count := binary.BigEndian.Uint32(header[8:]); int(count) != index.Len() {
+		file.Close()
+		return nil, fmt.Errorf("packfile %s has %d objects but its index has %d", packPath, count, index.Len())
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "reading packfile size")
+	}
+
+	return &Packfile{
+		Path:  packPath,
+		Index: index,
+		file:  file,
+		size:  fi.Size(),
+	}, nil
+}
+
+func (p *Packfile) Close() error {
+	return p.file.Close()
+}
+
+func (p *Packfile) Has(id []byte) bool {
+	_, ok := p.Index.Lookup(id)
+	return ok
+}
+
+func (p *Packfile) ReadObject(id []byte, resolve Resolver) (*Blob, error) {
+	offset, ok := p.Index.Lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("object %x not found in %s", id, p.Path)
+	}
+
+	return p.ReadObjectAt(offset, resolve)
+}
+
+func (p *Packfile) ReadObjectAt(offset uint64, resolve Resolver) (*Blob, error) {
+	objectType, contents, err := p.readEntry(offset, resolve, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Blob{
+		Type:     objectType.String(),
+		Contents: contents,
+	}, nil
+}
+
+func (p *Packfile) readEntry(offset uint64, resolve Resolver, depth int) (PackObjectType, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, fmt.Errorf("delta chain too deep at offset %d in %s", offset, p.Path)
+	}
+
+	if offset >= uint64(p.size) {
+		return 0, nil, fmt.Errorf("offset %d is past the end of %s", offset, p.Path)
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(p.file, int64(offset), p.size-int64(offset)))
+	objectType, size, err := ReadPackEntryHeader(r)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "reading entry header at offset %d in %s", offset, p.Path)
+	}
+
+	var baseType PackObjectType
+	var base []byte
+
+	switch objectType {
+	case PackCommit, PackTree, PackBlob, PackTag:
+		contents, err := inflate(r, size)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "reading entry at offset %d in %s", offset, p.Path)
+		}
+		return objectType, contents, nil
+	case PackOffsetDelta:
+		distance, err := ReadOffsetDeltaDistance(r)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "reading delta offset at offset %d in %s", offset, p.Path)
+		}
+
+		if distance == 0 || distance > offset {
+			return 0, nil, fmt.Errorf("invalid delta base distance %d at offset %d in %s", distance, offset, p.Path)
+		}
+
+		baseType, base, err = p.readEntry(offset-distance, resolve, depth+1)
+		if err != nil {
+			return 0, nil, err
+		}
+	case PackRefDelta:
+		baseID := make([]byte, 20)
+		_, err = io.ReadFull(r, baseID)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "reading delta base at offset %d in %s", offset, p.Path)
+		}
+
+		if baseOffset, ok := p.Index.Lookup(baseID); ok {
+			baseType, base, err = p.readEntry(baseOffset, resolve, depth+1)
+			if err != nil {
+				return 0, nil, err
+			}
+		} else {
+			if resolve == nil {
+				return 0, nil, fmt.Errorf("delta base %x at offset %d is not in %s", baseID, offset, p.Path)
+			}
+
+			blob, err := resolve(fmt.Sprintf("%x", baseID))
+			if err != nil {
+				return 0, nil, errors.Wrapf(err, "resolving delta base at offset %d in %s", offset, p.Path)
+			}
+
+			baseType, err = PackObjectTypeFor(blob.Type)
+			if err != nil {
+				return 0, nil, err
+			}
+			base = blob.Contents
+		}
+	default:
+		return 0, nil, fmt.Errorf("invalid object type %d at offset %d in %s", objectType, offset, p.Path)
+	}
+
+	delta, err := inflate(r, size)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "reading delta at offset %d in %s", offset, p.Path)
+	}
+
+	contents, err := ApplyDelta(base, delta)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "applying delta at offset %d in %s", offset, p.Path)
+	}
+
+	return baseType, contents, nil
+}
+
+var openPacks = struct {
+	sync.Mutex
+	packs map[string]*Packfile
+}{packs: map[string]*Packfile{}}
+
+// PacksInDir returns the packs in an objects directory. Packs stay open for
+// the life of the process, so repeated lookups don't reread the indexes.
+func PacksInDir(objectsDir string) ([]*Packfile, error) {
+	matches, err := filepath.Glob(filepath.Join(paths.PackDir(objectsDir), "*.pack"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing packfiles")
+	}
+
+	openPacks.Lock()
+	defer openPacks.Unlock()
+
+	var packs []*Packfile
+	for _, match := range matches {
+		pack, ok := openPacks.packs[match]
+		if !ok {
+			pack, err = OpenPackfile(match)
+			if err != nil {
+				return nil, err
+			}
+			openPacks.packs[match] = pack
+		}
+
+		packs = append(packs, pack)
+	}
+
+	return packs, nil
+}
+
+func readPackedObject(objectsDir, id string, resolve Resolver) (*Blob, error) {
+	if len(id) != paths.ObjectIDLength {
+		return nil, fmt.Errorf("invalid object ID %q", id)
+	}
+
+	rawID, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing object ID "+id)
+	}
+
+	packs, err := PacksInDir(objectsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pack := range packs {
+		if pack.Has(rawID) {
+			return pack.ReadObject(rawID, resolve)
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
@@ -0,0 +1,198 @@
+package objects
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testPackObjects = []struct {
+	ID   string
+	Type string
+}{
+	{"5360bf5d7fc9dd6160cd2e77b578cbc5a86fadfa", "commit"},
+	{"5bf43106fe83e0ce8240444bc546c087531546e4", "commit"},
+	{"5978d7671a1c6119646d70561917ae64e924eb8a", "tree"},
+	{"ab716776e5876596815af438c2fd272e1834103d", "blob"},
+	{"ce013625030ba8dba906f756967f9e9ca394464a", "blob"},
+	{"0d879335a715f8c5afd36f6d247402e337d94e93", "tree"},
+	{"14c2f15e82e0230f46031b071dd43d838eeb8a26", "blob"}, // delta against ab7167
+}
+
+// chdirTestRepo creates a repository holding the named packs from testdata
+// in a temporary directory and makes it the working directory for the rest
+// of the test.
+func chdirTestRepo(t *testing.T, packs ...string) string {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, ".git", "objects", "pack"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range packs {
+		installTestPack(t, dir, name)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	return dir
+}
+
+func installTestPack(t *testing.T, dir, name string) {
+	for _, ext := range []string{".pack", ".idx"} {
+		contents, err := os.ReadFile(filepath.Join("testdata", name+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, ".git", "objects", "pack", name+ext), contents, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadPackedObjects(t *testing.T) {
+	for _, name := range []string{"pack-ofs", "pack-ref"} {
+		t.Run(name, func(t *testing.T) {
+			chdirTestRepo(t, name)
+
+			for _, tc := range testPackObjects {
+				blob, err := ReadBlobWithID(tc.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if blob.Type != tc.Type {
+					t.Errorf("%s: expected type %s, got %s", tc.ID, tc.Type, blob.Type)
+				}
+
+				if blob.HashString() != tc.ID {
+					t.Errorf("invalid object hash; have %s, want %s", blob.HashString(), tc.ID)
+				}
+
+				_, err = ReadObjectFromFile(tc.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMissingObject(t *testing.T) {
+	chdirTestRepo(t)
+
+	_, err := ReadBlobWithID("3b18e512dba79e4c8300dd08aeb37f8e728b8dad")
+	if err == nil {
+		t.Fatal("expected an error reading a missing object")
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world\n")
+	// base size 12, result size 18, copy 6 bytes at 0, insert "there ",
+	// copy 6 bytes at 6.
+	delta := []byte{12, 18, 0x90, 6, 6, 't', 'h', 'e', 'r', 'e', ' ', 0x91, 6, 6}
+	expected := []byte("hello there world\n")
+
+	result, err := ApplyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(result, expected) {
+		t.Fatalf("delta result doesn't match\nexpected: %q\nactual: %q", expected, result)
+	}
+
+	_, err = ApplyDelta(base[:5], delta)
+	if err == nil {
+		t.Fatal("expected a base size mismatch")
+	}
+
+	for _, bad := range [][]byte{
+		// A result size near 2^64, which can't be allocated.
+		{12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x90, 6},
+		// Instructions that produce more than the result size.
+		{12, 4, 0x90, 6},
+		// Instructions that produce less than the result size.
+		{12, 18, 0x90, 6},
+	} {
+		_, err = ApplyDelta(base, bad)
+		if err == nil {
+			t.Fatalf("expected delta %v to be rejected", bad)
+		}
+	}
+}
+
+func TestReadCorruptPackIndex(t *testing.T) {
+	var entries []PackIndexEntry
+	for i, id := range []string{"0d", "5b", "ab"} {
+		raw := bytes.Repeat([]byte{0}, 20)
+		raw[0] = []byte(id)[0]
+		raw[19] = byte(i)
+		entries = append(entries, PackIndexEntry{ID: raw, Offset: uint64(12 + i)})
+	}
+
+	buf := &bytes.Buffer{}
+	_, err := NewPackIndex(entries, make([]byte, 20)).WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	idx, err := ReadPackIndex(bytes.NewReader(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := idx.Lookup(entries[1].ID); !ok || offset != 13 {
+		t.Fatalf("expected the second entry at offset 13, have %d (%v)", offset, ok)
+	}
+
+	fanout := func(i int, value uint32) []byte {
+		corrupt := append([]byte(nil), valid...)
+		binary.BigEndian.PutUint32(corrupt[8+4*i:], value)
+		return corrupt
+	}
+
+	for name, corrupt := range map[string][]byte{
+		"decreasing fanout": fanout(0x40, 0),
+		"huge count":        fanout(255, 0xffffffff),
+		"truncated":         valid[:len(valid)-1],
+		"trailing data":     append(append([]byte(nil), valid...), 0),
+	} {
+		if _, err = ReadPackIndex(bytes.NewReader(corrupt)); err == nil {
+			t.Fatalf("expected an index with %s to be rejected", name)
+		}
+	}
+}
+
+func TestInflateSize(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	w := zlib.NewWriter(compressed)
+	w.Write([]byte("hello\n"))
+	w.Close()
+
+	contents, err := inflate(bytes.NewReader(compressed.Bytes()), 6)
+	if err != nil || string(contents) != "hello\n" {
+		t.Fatalf("expected %q, have %q (%v)", "hello\n", contents, err)
+	}
+
+	for _, size := range []uint64{5, 7, 1 << 40, 1 << 63} {
+		if _, err = inflate(bytes.NewReader(compressed.Bytes()), size); err == nil {
+			t.Fatalf("expected inflating 6 bytes with size %d to fail", size)
+		}
+	}
+}
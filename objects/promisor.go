@@ -0,0 +1,80 @@
+package objects
+
+import (
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+// promisorSuffix marks a pack fetched from a promisor remote. Objects that
+// a partial clone left out may be missing; the remote promises to send
+// them when they're needed.
+const promisorSuffix = ".promisor"
+
+// FetchPromisedObjects fetches objects a partial clone left out from its
+// promisor remote. It's set by the git package, which knows about remotes.
+var FetchPromisedObjects func(store *DirStore, ids []string) error
+
+// MissingObjectError is returned for an object that isn't stored. In a
+// partial clone, missing objects are expected and fetched on demand, so
+// Promised is set and Err says why the fetch failed.
+type MissingObjectError struct {
+	ID       string
+	Promised bool
+	Err      error
+}
+
+func (err *MissingObjectError) Error() string {
+	if err.Promised {
+		return fmt.Sprintf("promised object %s couldn't be fetched: %v", err.ID, err.Err)
+	}
+	return fmt.Sprintf("object %s not found", err.ID)
+}
+
+// IsMissingObject reports whether err is a MissingObjectError.
+func IsMissingObject(err error) bool {
+	_, ok := errors.Cause(err).(*MissingObjectError)
+	return ok
+}
+
+// IsPartialClone reports whether any packs in objectsDir came from a
+// promisor remote.
+func IsPartialClone(objectsDir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(paths.PackDir(objectsDir), "pack-*"+promisorSuffix))
+	return len(matches) > 0
+}
+
+// MarkPromisorPack records that the pack with the given checksum came
+// from a promisor remote.
+func MarkPromisorPack(objectsDir, checksum string) error {
+	path := filepath.Join(paths.PackDir(objectsDir), "pack-"+checksum+promisorSuffix)
+	err := os.WriteFile(path, nil, 0644)
+	return errors.Wrap(err, "marking promisor pack")
+}
+
+// FetchPromised fetches the objects in ids that are missing from a partial
+// clone.
+func FetchPromised(store *DirStore, ids []string) error {
+	var missing []string
+	for _, id := range ids {
+		if !store.Has(id) {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if FetchPromisedObjects == nil || !IsPartialClone(store.Dir) {
+		return &MissingObjectError{ID: missing[0]}
+	}
+
+	err := FetchPromisedObjects(store, missing)
+	if err != nil {
+		return errors.Wrapf(err, "fetching %d promised objects", len(missing))
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package objects
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ObjectStore is somewhere objects are kept, such as the loose objects or
+// the packs in an objects directory.
+type ObjectStore interface {
+	// Has reports whether an object is stored, without reading it.
+	Has(id string) bool
+
+	// Read returns an object, or a MissingObjectError if it isn't
+	// stored.
+	Read(id string) (*Blob, error)
+
+	// Write stores an object. Storing an object that's already stored
+	// isn't an error.
+	Write(blob *Blob) error
+
+	// Stream returns an object's type and size, and a reader for its
+	// contents, which the caller must close.
+	Stream(id string) (string, int64, io.ReadCloser, error)
+
+	// Iterate calls fn with the ID of each object stored, stopping at
+	// the first error fn returns.
+	Iterate(fn func(id string) error) error
+}
+
+// streamBlob streams an object that's been read whole.
+func streamBlob(blob *Blob, err error) (string, int64, io.ReadCloser, error) {
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return blob.Type, int64(blob.Size()), io.NopCloser(bytes.NewReader(blob.Contents)), nil
+}
+
+// BatchWriter is implemented by stores that can write many objects in a
+// single transaction.
+type BatchWriter interface {
+	WriteBatch(blobs []*Blob) error
+}
+
+// Batches reports whether store writes batches in a single transaction,
+// rather than one object at a time.
+func Batches(store ObjectStore) bool {
+	if dir, ok := store.(*DirStore); ok {
+		store = dir.Loose
+	}
+	_, ok := store.(BatchWriter)
+	return ok
+}
+
+// WriteBatch writes objects to store, in a single transaction if it
+// supports them.
+func WriteBatch(store ObjectStore, blobs []*Blob) error {
+	if batcher, ok := store.(BatchWriter); ok {
+		return batcher.WriteBatch(blobs)
+	}
+
+	for _, blob := range blobs {
+		err := store.Write(blob)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DirStore is an objects directory as git lays it out: loose objects,
+// with packs alongside them. Objects are written loose, or to the
+// database the repository's extensions.objectstorage setting selects. In
+// a partial clone, objects that are missing are fetched from the promisor
+// remote.
+type DirStore struct {
+	Dir   string
+	Loose ObjectStore
+	Packs *PackStore
+}
+
+func NewDirStore(objectsDir string) *DirStore {
+	loose, err := unpackedStore(objectsDir)
+	if err != nil {
+		loose = &failedStore{err: err}
+	}
+
+	store := &DirStore{
+		Dir:   objectsDir,
+		Loose: loose,
+		Packs: NewPackStore(objectsDir),
+	}
+	store.Packs.Bases = store
+	return store
+}
+
+// unpackedStore returns the store for the objects in objectsDir that
+// aren't packed. Like git, the repository has to be at format version 1
+// for its extensions to be looked at.
+func unpackedStore(objectsDir string) (ObjectStore, error) {
+	cfg, err := config.Load(filepath.Join(filepath.Dir(objectsDir), "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := cfg.GetInt("core.repositoryformatversion", 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid repository format version")
+	}
+
+	storage := "loose"
+	if version >= 1 {
+		storage = cfg.GetString("extensions.objectstorage", storage)
+	}
+
+	switch strings.ToLower(storage) {
+	case "loose":
+		return NewLooseStore(objectsDir), nil
+	case "kvdb":
+		return NewKVStore(filepath.Join(objectsDir, KVStoreName)), nil
+	default:
+		return nil, fmt.Errorf("unknown object storage %q", storage)
+	}
+}
+
+// failedStore stands in for a store that couldn't be opened.
+type failedStore struct {
+	err error
+}
+
+func (s *failedStore) Has(id string) bool {
+	return false
+}
+
+func (s *failedStore) Read(id string) (*Blob, error) {
+	return nil, s.err
+}
+
+func (s *failedStore) Write(blob *Blob) error {
+	return s.err
+}
+
+func (s *failedStore) Iterate(fn func(id string) error) error {
+	return s.err
+}
+
+func (s *failedStore) Stream(id string) (string, int64, io.ReadCloser, error) {
+	return "", 0, nil, s.err
+}
+
+// RepositoryStore returns the store for the repository the current
+// directory is in.
+func RepositoryStore() (*DirStore, error) {
+	objectsDir, err := paths.ObjectsDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't find git path")
+	}
+
+	return NewDirStore(objectsDir), nil
+}
+
+func (s *DirStore) Has(id string) bool {
+	return s.Loose.Has(id) || s.Packs.Has(id)
+}
+
+func (s *DirStore) Read(id string) (*Blob, error) {
+	defer trace2.StartTimer("objects", "read").Stop()
+
+	obj, err := s.Loose.Read(id)
+	if !IsMissingObject(err) {
+		return obj, err
+	}
+
+	obj, err = s.Packs.Read(id)
+	if !IsMissingObject(err) {
+		return obj, err
+	}
+
+	// A partial clone fetches the objects it left out when they're
+	// needed.
+	if FetchPromisedObjects == nil || !IsPartialClone(s.Dir) {
+		return nil, err
+	}
+
+	err = FetchPromisedObjects(s, []string{id})
+	if err != nil {
+		return nil, &MissingObjectError{ID: id, Promised: true, Err: err}
+	}
+
+	obj, err = s.Packs.Read(id)
+	if IsMissingObject(err) {
+		return nil, &MissingObjectError{ID: id, Promised: true, Err: errors.New("the remote didn't send it")}
+	}
+	return obj, err
+}
+
+func (s *DirStore) Write(blob *Blob) error {
+	return s.Loose.Write(blob)
+}
+
+func (s *DirStore) WriteBatch(blobs []*Blob) error {
+	return WriteBatch(s.Loose, blobs)
+}
+
+func (s *DirStore) Stream(id string) (string, int64, io.ReadCloser, error) {
+	objectType, size, r, err := s.Loose.Stream(id)
+	if !IsMissingObject(err) {
+		return objectType, size, r, err
+	}
+
+	return streamBlob(s.Read(id))
+}
+
+// Iterate visits each object once, even if it's stored both loose and
+// packed.
+func (s *DirStore) Iterate(fn func(id string) error) error {
+	seen := map[string]bool{}
+	visit := func(id string) error {
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		return fn(id)
+	}
+
+	err := s.Loose.Iterate(visit)
+	if err != nil {
+		return err
+	}
+	return s.Packs.Iterate(visit)
+}
+
+// missing turns a file that isn't there into a MissingObjectError.
+func missing(id string, err error) error {
+	if os.IsNotExist(errors.Cause(err)) {
+		return &MissingObjectError{ID: id}
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package objects
+
+import (
+	"github.com/kisom/codecrafters/git-go/paths"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStoreObjects writes a blob, a tree holding it and a commit of the
+// tree to store, returning them.
+func testStoreObjects(t *testing.T, store ObjectStore) []*Blob {
+	blob := BlobFromBytes([]byte("hello world\n"))
+	tree := &Tree{}
+	tree.Add(&TreeEntry{Hash: blob.Hash(), Name: "hello.txt", Mode: ModeRegular})
+	commit := NewCommitFromTree(tree.HashString(), "", "hello")
+
+	err := blob.Write(store)
+	if err == nil {
+		err = tree.Write(store)
+	}
+	if err == nil {
+		err = commit.Write(store)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []*Blob{blob, tree.blob(), commit.blob()}
+}
+
+func checkStoreObjects(t *testing.T, store ObjectStore, expected []*Blob) {
+	var ids []string
+	for _, blob := range expected {
+		id := blob.HashString()
+		ids = append(ids, id)
+		if !store.Has(id) {
+			t.Fatalf("store doesn't have %s", id)
+		}
+
+		read, err := store.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if read.Type != blob.Type || read.HashString() != id {
+			t.Fatalf("read %s %s in place of %s %s", read.Type, read.HashString(), blob.Type, id)
+		}
+
+		objectType, size, r, err := store.Stream(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if objectType != blob.Type || size != int64(len(contents)) || string(contents) != string(blob.Contents) {
+			t.Fatalf("streamed %s %d %q in place of %s %q", objectType, size, contents, blob.Type, blob.Contents)
+		}
+	}
+
+	var iterated []string
+	err := store.Iterate(func(id string) error {
+		iterated = append(iterated, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(ids)
+	sort.Strings(iterated)
+	if len(iterated) != len(ids) {
+		t.Fatalf("expected to iterate over %v, have %v", ids, iterated)
+	}
+
+	for i := range ids {
+		if ids[i] != iterated[i] {
+			t.Fatalf("expected to iterate over %v, have %v", ids, iterated)
+		}
+	}
+
+	missing := "3b18e512dba79e4c8300dd08aeb37f8e728b8dac"
+	if store.Has(missing) {
+		t.Fatalf("store has %s", missing)
+	}
+
+	_, err = store.Read(missing)
+	if !IsMissingObject(err) {
+		t.Fatalf("expected a missing object error, have %v", err)
+	}
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]ObjectStore{
+		"memory": NewMemoryStore(),
+		"loose":  NewLooseStore(filepath.Join(t.TempDir(), "objects")),
+		"dir":    NewDirStore(filepath.Join(t.TempDir(), "objects")),
+		"kv":     NewKVStore(filepath.Join(t.TempDir(), KVStoreName)),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			checkStoreObjects(t, store, testStoreObjects(t, store))
+		})
+	}
+}
+
+func TestLooseStoreWrite(t *testing.T) {
+	store := NewLooseStore(t.TempDir())
+	blob := BlobFromBytes([]byte("hello world\n"))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = store.Write(blob)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkStoreObjects(t, store, []*Blob{blob})
+
+	path, err := paths.PathFromIDInDir(store.Dir, blob.HashString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Write(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil || !os.SameFile(before, after) {
+		t.Fatalf("expected an existing object to be left alone (%v)", err)
+	}
+
+	leftover, err := filepath.Glob(filepath.Join(filepath.Dir(path), "tmp_*"))
+	if err != nil || len(leftover) != 0 {
+		t.Fatalf("expected no temporary files, have %v", leftover)
+	}
+}
+
+func TestPackStore(t *testing.T) {
+	dir := t.TempDir()
+	objectsDir := filepath.Join(dir, ".git", "objects")
+	err := os.MkdirAll(filepath.Join(objectsDir, "pack"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installTestPack(t, dir, "pack-ofs")
+
+	var expected []*Blob
+	for _, tc := range testPackObjects {
+		blob, err := NewDirStore(objectsDir).Read(tc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, blob)
+	}
+
+	packs := NewPackStore(objectsDir)
+	checkStoreObjects(t, packs, expected)
+
+	if packs.Write(BlobFromBytes([]byte("hello world\n"))) == nil {
+		t.Fatal("expected writing to a pack store to fail")
+	}
+
+	// Objects written to a directory go alongside the packs, loose.
+	store := NewDirStore(objectsDir)
+	expected = append(expected, testStoreObjects(t, store)...)
+	checkStoreObjects(t, store, expected)
+}
+
+func TestObjectStorageConfig(t *testing.T) {
+	dir := t.TempDir()
+	objectsDir := filepath.Join(dir, "objects")
+	err := os.MkdirAll(objectsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectStorage = kvdb\n"
+	err = os.WriteFile(filepath.Join(dir, "config"), []byte(cfg), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewDirStore(objectsDir)
+	if _, ok := store.Loose.(*KVStore); !ok {
+		t.Fatalf("expected objects to be stored in a database, have %T", store.Loose)
+	}
+	if !Batches(store) {
+		t.Fatal("expected the database store to write in batches")
+	}
+	if Batches(NewDirStore(t.TempDir())) {
+		t.Fatal("expected loose objects to be written one at a time")
+	}
+
+	blobs := []*Blob{BlobFromBytes([]byte("one\n")), BlobFromBytes([]byte("two\n"))}
+	err = WriteBatch(store, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(blobs, testStoreObjects(t, store)...)
+	checkStoreObjects(t, NewDirStore(objectsDir), expected)
+
+	loose, err := filepath.Glob(filepath.Join(objectsDir, "[0-9a-f][0-9a-f]"))
+	if err != nil || len(loose) != 0 {
+		t.Fatalf("expected no loose objects, have %v", loose)
+	}
+
+	cfg = strings.Replace(cfg, "kvdb", "other", 1)
+	err = os.WriteFile(filepath.Join(dir, "config"), []byte(cfg), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDirStore(objectsDir).Read(blobs[0].HashString())
+	if err == nil || IsMissingObject(err) {
+		t.Fatalf("expected an unknown object storage to be an error, have %v", err)
+	}
+}
@@ -1,9 +1,11 @@
 package objects
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
-	"git.wntrmute.dev/kyle/goutils/die"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/pkg/errors"
 	"os"
 	"sort"
 	"strings"
@@ -16,6 +18,7 @@ const (
 	ModeExecutable
 	ModeSymbolic
 	ModeDirectory
+	ModeSubmodule
 )
 
 var modeToString = map[entryMode]string{
@@ -23,6 +26,7 @@ var modeToString = map[entryMode]string{
 	ModeExecutable: "100755",
 	ModeSymbolic:   "120000",
 	ModeDirectory:  "40000",
+	ModeSubmodule:  "160000",
 }
 
 var modeFromString = map[string]entryMode{
@@ -31,6 +35,7 @@ var modeFromString = map[string]entryMode{
 	"120000": ModeSymbolic,
 	"040000": ModeDirectory,
 	"40000":  ModeDirectory,
+	"160000": ModeSubmodule,
 }
 
 type TreeEntry struct {
@@ -56,16 +61,13 @@ func (e *TreeEntry) String() string {
 	return fmt.Sprintf("%s %s %s\t%s", modeToString[e.Mode], obj.Type, id, e.Name)
 }
 
-func entryFromBytes(a, b []byte) *TreeEntry {
-	if len(b) != 20 {
-		panic("bad hash length")
+func entryFromBytes(header, hash []byte) (*TreeEntry, error) {
+	mode, name, ok := strings.Cut(string(header), " ")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid tree entry %q", header)
 	}
-	entry := &TreeEntry{}
-	s := strings.Fields(string(a))
-	entry.Mode = modeFromString[s[0]]
-	entry.Name = s[1]
-	entry.Hash = b
-	return entry
+
+	return &TreeEntry{Mode: modeFromString[mode], Name: name, Hash: hash}, nil
 }
 
 type treeEntries []*TreeEntry
@@ -97,6 +99,10 @@ func (tree *Tree) Add(entry *TreeEntry) {
 	tree.entries = append(tree.entries, entry)
 }
 
+func (tree *Tree) Entries() []*TreeEntry {
+	return tree.entries
+}
+
 func (tree *Tree) rawEntries() []byte {
 	var raw []byte
 	for _, entry := range tree.entries {
@@ -148,36 +154,39 @@ func (tree *Tree) ObjectType() string {
 	return TypeTree
 }
 
-func (tree *Tree) Write() error {
-	return tree.blob().Write()
+func (tree *Tree) Write(store ObjectStore) error {
+	return tree.blob().Write(store)
 }
 
-func scanEntries(contents []byte) []*TreeEntry {
-	line := []byte{}
+// scanEntries parses the "<mode> <name>\x00<20 byte id>" entries a tree
+// is made of.
+func scanEntries(contents []byte) ([]*TreeEntry, error) {
 	entries := []*TreeEntry{}
 
-	for i := 0; i < len(contents); i++ {
-		if contents[i] != '\x00' {
-			line = append(line, contents[i])
-			continue
+	for len(contents) > 0 {
+		end := bytes.IndexByte(contents, 0)
+		if end < 0 || len(contents) < end+21 {
+			return nil, errors.New("truncated tree entry")
 		}
 
-		i++
-		entry := entryFromBytes(line, contents[i:i+20])
-		line = []byte{}
-		i += 19
+		entry, err := entryFromBytes(contents[:end], contents[end+1:end+21])
+		if err != nil {
+			return nil, err
+		}
 		entries = append(entries, entry)
+		contents = contents[end+21:]
 	}
 
-	if len(line) > 0 {
-		panic("leftover data: " + string(line))
-	}
-	return entries
+	return entries, nil
 }
 
 func TreeFromBlob(blob *Blob) (*Tree, error) {
-	tree := &Tree{}
-	tree.entries = scanEntries(blob.Contents)
+	entries, err := scanEntries(blob.Contents)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing tree "+blob.HashString())
+	}
+
+	tree := &Tree{entries: entries}
 	sort.Sort(tree.entries)
 
 	return tree, nil
@@ -188,26 +197,26 @@ func ListTree(args []string) {
 	flagset := flag.NewFlagSet("ls-tree", flag.ExitOnError)
 	flagset.BoolVar(&nameOnly, "name-only", false, "Only show names")
 	err := flagset.Parse(args)
-	die.If(err)
+	trace2.DieIf(err)
 
 	if flagset.NArg() == 0 {
 		fmt.Fprintln(os.Stderr, "Usage: ls-tree [options] <object>")
 		flagset.PrintDefaults()
 
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
 	id := flagset.Arg(0)
 	obj, err := ReadBlobWithID(id)
-	die.If(err)
+	trace2.DieIf(err)
 
 	if obj.Type != TypeTree {
 		fmt.Fprintf(os.Stderr, "%s is not a tree\n", id)
-		os.Exit(1)
+		trace2.ExitProcess(1)
 	}
 
 	tree, err := TreeFromBlob(obj)
-	die.If(err)
+	trace2.DieIf(err)
 
 	if nameOnly {
 		fmt.Print(tree.Names())
@@ -29,3 +29,26 @@ func TestListTree(t *testing.T) {
 	objID := "74633606588dc5375716604aed882c6b106e4530"
 	ListTree([]string{objID})
 }
+
+func TestTreeFromTruncatedBlob(t *testing.T) {
+	tree := &Tree{}
+	tree.Add(&TreeEntry{Hash: BlobFromBytes([]byte("hello\n")).Hash(), Name: "hello world.txt", Mode: ModeRegular})
+	blob := tree.blob()
+
+	parsed, err := TreeFromBlob(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := parsed.Entries()
+	if len(entries) != 1 || entries[0].Name != "hello world.txt" {
+		t.Fatalf("expected a single entry named \"hello world.txt\", have %d entries", len(entries))
+	}
+
+	for _, n := range []int{1, 10, len(blob.Contents) - 1} {
+		_, err = TreeFromBlob(&Blob{Type: TypeTree, Contents: blob.Contents[:n]})
+		if err == nil {
+			t.Fatalf("expected an error parsing a tree truncated to %d bytes", n)
+		}
+	}
+}
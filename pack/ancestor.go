@@ -0,0 +1,42 @@
+package pack
+
+import (
+	"github.com/kisom/codecrafters/git-go/objects"
+)
+
+// IsAncestor reports whether ancestor is reachable from descendant. The
+// search stops at shallow commits, whose parents aren't stored.
+func IsAncestor(store *objects.DirStore, ancestor, descendant string) (bool, error) {
+	shallow, err := repoShallow(store.Dir)
+	if err != nil {
+		return false, err
+	}
+
+	queue := []string{descendant}
+	seen := map[string]bool{descendant: true}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == ancestor {
+			return true, nil
+		}
+
+		if shallow[id] {
+			continue
+		}
+
+		commit, err := objects.ReadCommit(store, id)
+		if err != nil {
+			return false, err
+		}
+
+		for _, parent := range commit.Parents {
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	return false, nil
+}
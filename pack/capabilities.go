@@ -0,0 +1,148 @@
+package pack
+
+import (
+	"strings"
+)
+
+// Capability is a single capability, either a bare name like ofs-delta or
+// a key/value pair like agent=git/2.39.5.
+type Capability struct {
+	Name  string
+	Value string
+}
+
+func ParseCapability(s string) Capability {
+	name, value, _ := strings.Cut(s, "=")
+	return Capability{Name: name, Value: value}
+}
+
+func (c Capability) String() string {
+	if c.Value == "" {
+		return c.Name
+	}
+	return c.Name + "=" + c.Value
+}
+
+// Capabilities is a list of capabilities in the order they were sent. Some,
+// like symref, can appear more than once.
+type Capabilities []Capability
+
+// ParseCapabilities parses a protocol v0 capability list, which is
+// separated by spaces.
+func ParseCapabilities(s string) Capabilities {
+	var caps Capabilities
+	for _, field := range strings.Fields(s) {
+		caps = append(caps, ParseCapability(field))
+	}
+	return caps
+}
+
+func (caps Capabilities) Has(name string) bool {
+	_, ok := caps.Value(name)
+	return ok
+}
+
+// Value returns the value of the first capability with the given name.
+func (caps Capabilities) Value(name string) (string, bool) {
+	for _, c := range caps {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+func (caps Capabilities) Values(name string) []string {
+	var values []string
+	for _, c := range caps {
+		if c.Name == name {
+			values = append(values, c.Value)
+		}
+	}
+	return values
+}
+
+// Supports reports whether a protocol v2 command capability, such as
+// fetch=shallow filter, lists a feature.
+func (caps Capabilities) Supports(command, feature string) bool {
+	value, _ := caps.Value(command)
+	for _, f := range strings.Fields(value) {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// SymbolicRefs maps each symbolic ref in symref capabilities, usually just
+// HEAD, to its target.
+func (caps Capabilities) SymbolicRefs() map[string]string {
+	refs := map[string]string{}
+	for _, value := range caps.Values("symref") {
+		if name, target, ok := strings.Cut(value, ":"); ok {
+			refs[name] = target
+		}
+	}
+	return refs
+}
+
+func (caps Capabilities) Agent() string {
+	agent, _ := caps.Value("agent")
+	return agent
+}
+
+// ObjectFormat returns the hash algorithm the remote uses, which is SHA-1
+// unless it says otherwise.
+func (caps Capabilities) ObjectFormat() string {
+	if format, ok := caps.Value("object-format"); ok {
+		return format
+	}
+	return "sha1"
+}
+
+// Without returns the capabilities other than those with the given names.
+func (caps Capabilities) Without(names ...string) Capabilities {
+	var kept Capabilities
+	for _, c := range caps {
+		drop := false
+		for _, name := range names {
+			drop = drop || c.Name == name
+		}
+
+		if !drop {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// Intersect returns the capabilities from ours that the remote also
+// advertised, with our values: this is what a client asks for. Only one
+// side-band can be used, so side-band-64k wins if both are shared.
+func (caps Capabilities) Intersect(ours Capabilities) Capabilities {
+	var shared Capabilities
+	for _, c := range ours {
+		if caps.Has(c.Name) {
+			shared = append(shared, c)
+		}
+	}
+
+	if shared.Has("side-band-64k") {
+		shared = shared.Without("side-band")
+	}
+	return shared
+}
+
+func (caps Capabilities) Strings() []string {
+	var strs []string
+	for _, c := range caps {
+		strs = append(strs, c.String())
+	}
+	return strs
+}
+
+// String formats the capabilities the way they're sent on the first want
+// or ref line.
+func (caps Capabilities) String() string {
+	return strings.Join(caps.Strings(), " ")
+}
@@ -0,0 +1,39 @@
+package pack
+
+import (
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	caps := ParseCapabilities("multi_ack thin-pack side-band side-band-64k ofs-delta shallow " +
+		"symref=HEAD:refs/heads/main symref=refs/remotes/origin/HEAD:refs/remotes/origin/main " +
+		"object-format=sha256 filter agent=git/2.39.5")
+
+	if !caps.Has("shallow") || !caps.Has("filter") || caps.Has("multi_ack_detailed") {
+		t.Fatalf("capabilities weren't parsed correctly: %v", caps)
+	}
+
+	if caps.Agent() != "git/2.39.5" || caps.ObjectFormat() != "sha256" {
+		t.Fatalf("unexpected agent %q or object format %q", caps.Agent(), caps.ObjectFormat())
+	}
+
+	if refs := caps.SymbolicRefs(); len(refs) != 2 || refs["HEAD"] != "refs/heads/main" {
+		t.Fatalf("unexpected symbolic refs %v", refs)
+	}
+
+	if format := (Capabilities{}).ObjectFormat(); format != "sha1" {
+		t.Fatalf("expected the object format to default to sha1, have %s", format)
+	}
+
+	// Only one side-band is asked for, and our agent is sent rather
+	// than the server's.
+	requested := caps.Intersect(fetchClientCapabilities).String()
+	if expected := "side-band-64k ofs-delta agent=" + Agent; requested != expected {
+		t.Fatalf("expected %q, have %q", expected, requested)
+	}
+
+	v2 := Capabilities{ParseCapability("fetch=shallow wait-for-done"), ParseCapability("ls-refs=unborn")}
+	if !v2.Supports("fetch", "shallow") || v2.Supports("fetch", "filter") || !v2.Supports("ls-refs", "unborn") {
+		t.Fatalf("command features weren't parsed correctly: %v", v2)
+	}
+}
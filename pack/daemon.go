@@ -0,0 +1,121 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// exportOKFile marks a repository the daemon may serve.
+const exportOKFile = "git-daemon-export-ok"
+
+// Daemon serves repositories read-only over the git:// protocol, like git
+// daemon.
+type Daemon struct {
+	// BasePath is the directory repository paths are relative to. If
+	// it's empty, they're taken as they are.
+	BasePath string
+
+	// ExportAll serves every repository, not just those with a
+	// git-daemon-export-ok file.
+	ExportAll bool
+}
+
+type daemonRequest struct {
+	service string
+	path    string
+	host    string
+}
+
+func readDaemonRequest(conn net.Conn) (*daemonRequest, error) {
+	line, err := readPktLine(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading request")
+	}
+
+	command, params, _ := bytes.Cut(line, []byte{0})
+	service, repoPath, ok := strings.Cut(string(command), " ")
+	if !ok || repoPath == "" {
+		return nil, fmt.Errorf("invalid request %q", line)
+	}
+
+	req := &daemonRequest{service: service, path: repoPath}
+	for _, param := range bytes.Split(params, []byte{0}) {
+		if host, ok := bytes.CutPrefix(param, []byte("host=")); ok {
+			req.host = string(host)
+		}
+	}
+	return req, nil
+}
+
+// findRepository maps a requested path to a git directory the daemon is
+// allowed to serve. Paths can't climb out of the base path.
+func (d *Daemon) findRepository(repoPath string) (string, bool) {
+	dir := filepath.FromSlash(path.Clean("/" + repoPath))
+	if d.BasePath != "" {
+		dir = filepath.Join(d.BasePath, dir)
+	}
+
+	gitDir, ok := paths.FindGitDir(dir)
+	if !ok {
+		return "", false
+	}
+
+	if !d.ExportAll {
+		if _, err := os.Stat(filepath.Join(gitDir, exportOKFile)); err != nil {
+			return "", false
+		}
+	}
+
+	return gitDir, true
+}
+
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+
+	req, err := readDaemonRequest(conn)
+	if err != nil {
+		log.Warnf("daemon: %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	log.Infof("daemon: %s: %s %s (host %s)", conn.RemoteAddr(), req.service, req.path, req.host)
+	if req.service != ServiceUploadPack {
+		writeLine(conn, "ERR service not enabled: "+req.service)
+		return
+	}
+
+	// Like git daemon, don't say whether the repository exists.
+	gitDir, ok := d.findRepository(req.path)
+	if !ok {
+		writeLine(conn, "ERR access denied or repository not exported: "+req.path)
+		return
+	}
+
+	err = UploadPack(gitDir, conn, conn, false)
+	if err != nil {
+		log.Warnf("daemon: %s: upload-pack %s: %v", conn.RemoteAddr(), gitDir, err)
+	}
+}
+
+// Serve accepts connections until the listener is closed.
+func (d *Daemon) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return errors.Wrap(err, "accepting connection")
+		}
+
+		go d.handle(conn)
+	}
+}
@@ -0,0 +1,67 @@
+package pack
+
+import (
+	"errors"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDaemon(t *testing.T) {
+	gitDir, commits := newTestRepository(t, 5)
+	workTree := filepath.Dir(gitDir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go (&Daemon{BasePath: filepath.Dir(workTree)}).Serve(l)
+	repo := "git://" + l.Addr().String() + "/" + filepath.Base(workTree)
+
+	// Repositories have to be exported.
+	session := testSession(t, repo, ServiceUploadPack, ProtocolV2)
+	_, err = ListRefs(session)
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || !strings.Contains(remoteErr.Message, "not exported") {
+		t.Fatalf("expected the repository not to be exported, have %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(gitDir, exportOKFile), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session = testSession(t, repo, ServiceUploadPack, ProtocolV2)
+	ra, err := ListRefs(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ref := ra.Lookup("refs/heads/main"); ref == nil || ref.ID != commits[4] {
+		t.Fatalf("expected refs/heads/main to be %s, have %#v", commits[4], ref)
+	}
+
+	store := objects.NewDirStore(filepath.Join(newBareRepository(t), "objects"))
+	_, err = FetchPack(session, ra, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range commits {
+		if !store.Has(id) {
+			t.Fatalf("commit %s wasn't fetched", id)
+		}
+	}
+
+	// The daemon is read-only.
+	session = testSession(t, repo, ServiceReceivePack, ProtocolV0)
+	_, err = ReadAdvertisement(session)
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("expected pushing to be refused, have %v", err)
+	}
+}
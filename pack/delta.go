@@ -0,0 +1,134 @@
+package pack
+
+const (
+	deltaBlockSize = 16
+
+	// maxCopySize is the largest copy that fits in a copy instruction
+	// without relying on the implicit 0x10000 size.
+	maxCopySize = 0xffff
+
+	maxInsertSize = 0x7f
+
+	// maxBlockCandidates caps how many base offsets are remembered for
+	// each block hash, so repetitive content doesn't make matching
+	// quadratic.
+	maxBlockCandidates = 8
+)
+
+func appendDeltaSize(delta []byte, size int) []byte {
+	for size >= 0x80 {
+		delta = append(delta, byte(size&0x7f)|0x80)
+		size >>= 7
+	}
+	return append(delta, byte(size))
+}
+
+func appendInsert(delta []byte, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxInsertSize {
+			n = maxInsertSize
+		}
+
+		delta = append(delta, byte(n))
+		delta = append(delta, data[:n]...)
+		data = data[n:]
+	}
+	return delta
+}
+
+func appendCopy(delta []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > maxCopySize {
+			n = maxCopySize
+		}
+
+		op := byte(0x80)
+		var args []byte
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				op |= 1 << i
+				args = append(args, b)
+			}
+		}
+
+		for i := uint(0); i < 2; i++ {
+			if b := byte(n >> (8 * i)); b != 0 {
+				op |= 1 << (4 + i)
+				args = append(args, b)
+			}
+		}
+
+		delta = append(delta, op)
+		delta = append(delta, args...)
+		offset += n
+		size -= n
+	}
+	return delta
+}
+
+// blockHash is FNV-1a, written out to avoid allocating a hash.Hash for
+// every byte of the target.
+func blockHash(block []byte) uint32 {
+	h := uint32(2166136261)
+	for _, b := range block {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h
+}
+
+// computeDelta produces a git delta that rebuilds target from base. It
+// indexes the base in fixed-size blocks and greedily extends matches found
+// in the target.
+func computeDelta(base, target []byte) []byte {
+	index := map[uint32][]int{}
+	for offset := 0; offset+deltaBlockSize <= len(base); offset += deltaBlockSize {
+		h := blockHash(base[offset : offset+deltaBlockSize])
+		if len(index[h]) < maxBlockCandidates {
+			index[h] = append(index[h], offset)
+		}
+	}
+
+	delta := appendDeltaSize(nil, len(base))
+	delta = appendDeltaSize(delta, len(target))
+
+	var pending []byte
+	for i := 0; i < len(target); {
+		bestOffset, bestSize := 0, 0
+		if i+deltaBlockSize <= len(target) {
+			for _, offset := range index[blockHash(target[i:i+deltaBlockSize])] {
+				size := 0
+				for offset+size < len(base) && i+size < len(target) && base[offset+size] == target[i+size] {
+					size++
+				}
+
+				if size > bestSize {
+					bestOffset, bestSize = offset, size
+				}
+			}
+		}
+
+		if bestSize < deltaBlockSize {
+			pending = append(pending, target[i])
+			i++
+			continue
+		}
+
+		// Pull preceding literal bytes into the copy when they also match.
+		for len(pending) > 0 && bestOffset > 0 && base[bestOffset-1] == pending[len(pending)-1] {
+			pending = pending[:len(pending)-1]
+			bestOffset--
+			bestSize++
+			i--
+		}
+
+		delta = appendInsert(delta, pending)
+		pending = pending[:0]
+		delta = appendCopy(delta, bestOffset, bestSize)
+		i += bestSize
+	}
+
+	return appendInsert(delta, pending)
+}
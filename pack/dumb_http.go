@@ -0,0 +1,394 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// get fetches a file from a repository served as plain files. A file
+// that isn't there has a nil response.
+func (s *httpSession) get(name string) (*http.Response, error) {
+	repoURL, err := normalizeRepoURL(s.repo)
+	if err != nil {
+		return nil, errors.Wrap(err, "normalizing repo URL")
+	}
+	repoURL.Path = path.Join(repoURL.Path, name)
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, repoURL.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "building request for "+name)
+	}
+
+	resp, err := s.auth.do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching "+name)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, nil
+	}
+
+	resp.Body.Close()
+	return nil, fmt.Errorf("fetching %s returned http status code %d", name, resp.StatusCode)
+}
+
+// parseInfoRefs reads the "<id>\t<name>" lines of an info/refs file.
+func parseInfoRefs(r io.Reader) ([]*Reference, error) {
+	var refs []*Reference
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		id, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("invalid info/refs line %q", line)
+		}
+
+		ref := &Reference{ID: id, Name: name}
+		err := checkReference(ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading info/refs")
+		}
+		refs = append(refs, ref)
+	}
+
+	return refs, errors.Wrap(scanner.Err(), "reading info/refs")
+}
+
+// dumbAdvertisement builds an advertisement from the info/refs and HEAD
+// files of a dumb server, so it reads like one from a smart server that
+// has no capabilities but symref.
+func (s *httpSession) dumbAdvertisement(body io.ReadCloser) (io.Reader, error) {
+	refs, err := parseInfoRefs(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	s.closeAdvertisement()
+	s.dumb = true
+
+	ra := &ReferenceAdvertisement{}
+	resp, err := s.get("HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp != nil {
+		head, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading HEAD")
+		}
+
+		value := strings.TrimSpace(string(head))
+		if target, ok := strings.CutPrefix(value, "ref: "); ok {
+			for _, ref := range refs {
+				if ref.Name == target {
+					ra.References = append(ra.References, &Reference{ID: ref.ID, Name: "HEAD"})
+					ra.Capabilities = append(ra.Capabilities, Capability{Name: "symref", Value: "HEAD:" + target})
+					break
+				}
+			}
+		} else if paths.IsObjectID(value) {
+			ra.References = append(ra.References, &Reference{ID: value, Name: "HEAD"})
+		}
+	}
+	ra.References = append(ra.References, refs...)
+
+	if len(ra.References) == 0 {
+		return bytes.NewReader(flushPkt), nil
+	}
+
+	var buf bytes.Buffer
+	err = ra.Encode(&buf)
+	return &buf, err
+}
+
+// dumbFetcher walks the history of the objects it's asked for, fetching
+// the ones it doesn't have as loose objects, or in whichever of the
+// server's packs holds them.
+type dumbFetcher struct {
+	s       *httpSession
+	store   *objects.DirStore
+	shallow map[string]bool
+	opts    *FetchOptions
+
+	// remotePacks lists the server's packs that haven't been fetched,
+	// once an object has been found missing.
+	remotePacks []string
+	listedPacks bool
+
+	// indexes holds the indexes of the server's packs that have been
+	// looked at, and fetched the ones for packs that were stored.
+	indexes map[string]*objects.PackIndex
+	fetched []*objects.PackIndex
+
+	checksum string
+}
+
+// listPacks reads objects/info/packs, leaving out packs already stored.
+func (f *dumbFetcher) listPacks() error {
+	f.listedPacks = true
+	resp, err := f.s.get("objects/info/packs")
+	if err != nil || resp == nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, ok := strings.CutPrefix(scanner.Text(), "P ")
+		if !ok {
+			continue
+		}
+
+		_, err = os.Stat(filepath.Join(paths.PackDir(f.store.Dir), name))
+		if err == nil {
+			continue
+		}
+		f.remotePacks = append(f.remotePacks, name)
+	}
+
+	return errors.Wrap(scanner.Err(), "reading objects/info/packs")
+}
+
+func (f *dumbFetcher) remoteIndex(name string) (*objects.PackIndex, error) {
+	if idx, ok := f.indexes[name]; ok {
+		return idx, nil
+	}
+
+	indexName := strings.TrimSuffix(name, ".pack") + ".idx"
+	resp, err := f.s.get("objects/pack/" + indexName)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("server has no index for %s", name)
+	}
+	defer resp.Body.Close()
+
+	idx, err := objects.ReadPackIndex(bufio.NewReader(resp.Body))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading "+indexName)
+	}
+
+	f.indexes[name] = idx
+	return idx, nil
+}
+
+// fetchPacked fetches the pack holding an object, returning false if none
+// of the server's packs does.
+func (f *dumbFetcher) fetchPacked(id string, rawID []byte) (bool, error) {
+	if !f.listedPacks {
+		err := f.listPacks()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for i, name := range f.remotePacks {
+		idx, err := f.remoteIndex(name)
+		if err != nil {
+			return false, err
+		}
+
+		if _, ok := idx.Lookup(rawID); !ok {
+			continue
+		}
+
+		log.Debugf("fetching %s for %s", name, id)
+		resp, err := f.s.get("objects/pack/" + name)
+		if err != nil {
+			return false, err
+		}
+		if resp == nil {
+			return false, fmt.Errorf("server is missing %s", name)
+		}
+		defer resp.Body.Close()
+
+		checksum, err := indexPackToDir(resp.Body, paths.PackDir(f.store.Dir), f.opts.Progress)
+		if err != nil {
+			return false, errors.Wrap(err, "storing "+name)
+		}
+
+		if f.opts.Promisor {
+			err = objects.MarkPromisorPack(f.store.Dir, checksum)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		f.checksum = checksum
+		f.fetched = append(f.fetched, idx)
+		f.remotePacks = append(f.remotePacks[:i], f.remotePacks[i+1:]...)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// fetchLoose fetches an object stored loose on the server, checking it's
+// the object asked for before storing it. It returns false if the server
+// doesn't have it loose.
+func (f *dumbFetcher) fetchLoose(id string) (bool, error) {
+	resp, err := f.s.get("objects/" + id[:2] + "/" + id[2:])
+	if err != nil || resp == nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	blob, err := objects.ReadLooseObject(resp.Body, id)
+	if err != nil {
+		return false, err
+	}
+
+	if blob.HashString() != id {
+		return false, fmt.Errorf("server sent object %s in place of %s", blob.HashString(), id)
+	}
+
+	return true, blob.Write(f.store)
+}
+
+// fetchedPacked reports whether an object came in a pack this fetch
+// stored.
+func (f *dumbFetcher) fetchedPacked(rawID []byte) bool {
+	for _, idx := range f.fetched {
+		if _, ok := idx.Lookup(rawID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch makes sure an object is stored, returning true if it's new, in
+// which case what it refers to has to be fetched too. Objects that were
+// already stored are taken to have their history with them.
+func (f *dumbFetcher) fetch(id string) (bool, error) {
+	rawID, err := hex.DecodeString(id)
+	if err != nil {
+		return false, errors.Wrap(err, "parsing object ID "+id)
+	}
+
+	if f.fetchedPacked(rawID) {
+		return true, nil
+	}
+
+	if f.store.Has(id) {
+		return false, nil
+	}
+
+	ok, err := f.fetchLoose(id)
+	if err == nil && !ok {
+		ok, err = f.fetchPacked(id, rawID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, fmt.Errorf("server doesn't have object %s", id)
+	}
+	return true, nil
+}
+
+// references lists the objects an object refers to.
+func (f *dumbFetcher) references(id string) ([]string, error) {
+	blob, err := f.store.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch blob.Type {
+	case "commit":
+		commit, err := objects.CommitFromBlob(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.shallow[id] {
+			return []string{commit.Tree}, nil
+		}
+		return append([]string{commit.Tree}, commit.Parents...), nil
+	case "tree":
+		tree, err := objects.TreeFromBlob(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		var ids []string
+		for _, entry := range tree.Entries() {
+			if entry.Mode != objects.ModeSubmodule {
+				ids = append(ids, hex.EncodeToString(entry.Hash))
+			}
+		}
+		return ids, nil
+	case "tag":
+		target, err := tagTarget(blob)
+		if err != nil {
+			return nil, err
+		}
+		return []string{target}, nil
+	}
+
+	return nil, nil
+}
+
+// fetchDumb fetches the wants from a dumb server, walking their history
+// one object at a time. It returns the checksum of the last pack stored,
+// if any.
+func fetchDumb(s *httpSession, wants []string, store *objects.DirStore, shallow map[string]bool, opts *FetchOptions) (string, error) {
+	f := &dumbFetcher{
+		s:       s,
+		store:   store,
+		shallow: shallow,
+		opts:    opts,
+		indexes: map[string]*objects.PackIndex{},
+	}
+
+	seen := map[string]bool{}
+	queue := append([]string{}, wants...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		isNew, err := f.fetch(id)
+		if err != nil {
+			return "", err
+		}
+		if !isNew {
+			continue
+		}
+
+		refs, err := f.references(id)
+		if err != nil {
+			return "", err
+		}
+		queue = append(queue, refs...)
+	}
+
+	return f.checksum, nil
+}
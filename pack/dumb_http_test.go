@@ -0,0 +1,89 @@
+package pack
+
+import (
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumbHTTP(t *testing.T) {
+	gitDir, commits := newTestRepository(t, 10)
+	objectsDir := filepath.Join(gitDir, "objects")
+	store := objects.NewDirStore(objectsDir)
+
+	// Pack the first half of the history, leaving the rest loose.
+	revObjects, err := RevList(store, commits[4:5], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = WritePackFiles(filepath.Join(paths.PackDir(objectsDir), "pack"), store, revObjects, &PackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, obj := range revObjects {
+		path, err := paths.PathFromIDInDir(objectsDir, obj.ID)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = UpdateServerInfo(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(gitDir))))
+	defer srv.Close()
+
+	session := testSession(t, srv.URL+"/.git", ServiceUploadPack, ProtocolV2)
+	ra, err := ListRefs(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target := ra.SymbolicRef("HEAD"); target != "refs/heads/main" {
+		t.Fatalf("expected HEAD to point to refs/heads/main, have %q", target)
+	}
+
+	if ref := ra.Lookup("refs/heads/main"); ref == nil || ref.ID != commits[9] {
+		t.Fatalf("expected refs/heads/main to be %s, have %#v", commits[9], ref)
+	}
+
+	cloneDir := filepath.Join(t.TempDir(), "objects")
+	err = os.MkdirAll(cloneDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone := objects.NewDirStore(cloneDir)
+	checksum, err := FetchPack(session, ra, clone, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checksum == "" {
+		t.Fatal("expected the packed history to be fetched as a pack")
+	}
+
+	for _, id := range commits {
+		if !clone.Has(id) {
+			t.Fatalf("commit %s wasn't fetched", id)
+		}
+	}
+
+	for _, obj := range revObjects {
+		path, _ := paths.PathFromIDInDir(cloneDir, obj.ID)
+		if _, err := os.Stat(path); err == nil {
+			t.Fatalf("packed object %s was fetched loose", obj.ID)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pack
+
+import (
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
+const Agent = "mygit/0.1"
+
+// FetchOptions controls how a pack is fetched.
+type FetchOptions struct {
+	// Progress receives the remote's progress messages. If it's nil,
+	// the remote is asked not to send any.
+	Progress io.Writer
+
+	// Wants lists the objects to fetch. If it's empty, everything the
+	// remote advertised is fetched.
+	Wants []string
+
+	// Haves are local commits, usually the tips of local refs, that the
+	// remote is told about so it only sends what's missing.
+	Haves []string
+
+	// Depth limits the history fetched to that many commits from each
+	// want. With DeepenRelative, it counts from the current shallow
+	// boundary instead.
+	Depth          int
+	DeepenRelative bool
+
+	// ShallowSince limits the history fetched to commits after it.
+	ShallowSince time.Time
+
+	// Filter leaves objects out of the pack for a partial clone.
+	// Promisor marks the pack as coming from a promisor remote, which
+	// will send the objects left out on demand; filtered packs always
+	// are.
+	Filter   *Filter
+	Promisor bool
+}
+
+// fetchRequest is what a fetch asks the remote for.
+type fetchRequest struct {
+	wants []string
+
+	// deepen holds the shallow and deepen lines sent with the wants.
+	// If deepening is set, the remote answers with the new shallow
+	// boundary, which is collected in update.
+	deepen    []string
+	deepening bool
+	update    *shallowUpdate
+
+	// filter is sent if the remote supports it.
+	filter *Filter
+}
+
+// args lists the lines sent after the wants.
+func (req *fetchRequest) args() []string {
+	args := append([]string{}, req.deepen...)
+	if req.filter != nil {
+		args = append(args, "filter "+req.filter.String())
+	}
+	return args
+}
+
+// fetchClientCapabilities are the capabilities a fetch can use.
+var fetchClientCapabilities = Capabilities{
+	{Name: "multi_ack_detailed"},
+	{Name: "side-band-64k"},
+	{Name: "side-band"},
+	{Name: "ofs-delta"},
+	{Name: "agent", Value: Agent},
+}
+
+// fetchCapabilities picks the capabilities to request. Shallow is needed
+// to send shallow or deepen lines.
+func fetchCapabilities(ra *ReferenceAdvertisement, opts *FetchOptions, shallow bool) Capabilities {
+	ours := fetchClientCapabilities
+	if opts.Progress == nil {
+		ours = append(ours[:len(ours):len(ours)], Capability{Name: "no-progress"})
+	}
+	if shallow {
+		ours = append(ours[:len(ours):len(ours)], Capability{Name: "shallow"})
+	}
+
+	if !opts.ShallowSince.IsZero() {
+		ours = append(ours[:len(ours):len(ours)], Capability{Name: "deepen-since"})
+	}
+	if opts.DeepenRelative {
+		ours = append(ours[:len(ours):len(ours)], Capability{Name: "deepen-relative"})
+	}
+	if opts.Filter != nil {
+		ours = append(ours[:len(ours):len(ours)], Capability{Name: "filter"})
+	}
+
+	return ra.Capabilities.Intersect(ours)
+}
+
+// wants returns the objects to ask for, leaving out any that are already
+// stored locally unless the history behind them is being deepened.
+func (opts *FetchOptions) wants(ra *ReferenceAdvertisement, store objects.ObjectStore) []string {
+	candidates := opts.Wants
+	if len(candidates) == 0 {
+		candidates = ra.wantIDs()
+	}
+
+	var wants []string
+	seen := map[string]bool{}
+	for _, id := range candidates {
+		if seen[id] || (!opts.deepening() && store.Has(id)) {
+			continue
+		}
+
+		seen[id] = true
+		wants = append(wants, id)
+	}
+	return wants
+}
+
+// FetchPack negotiates with the remote and stores the pack it sends in
+// store's objects directory, returning the pack's checksum. If there's
+// nothing to fetch, no pack is requested and the checksum is empty.
+func FetchPack(s Session, ra *ReferenceAdvertisement, store *objects.DirStore, opts *FetchOptions) (string, error) {
+	if opts == nil {
+		opts = &FetchOptions{}
+	}
+
+	req := &fetchRequest{
+		wants:     opts.wants(ra, store),
+		deepening: opts.deepening(),
+		update:    &shallowUpdate{},
+	}
+	if len(req.wants) == 0 {
+		return "", nil
+	}
+
+	shallow, err := repoShallow(store.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	if req.deepening {
+		err = opts.checkShallowCapabilities(ra)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// A shallow repository tells the remote where its history stops,
+	// if the remote can make sense of it.
+	if req.deepening || (len(shallow) > 0 && supportsShallow(ra)) {
+		req.deepen = opts.deepenArgs(shallow, ra.Version)
+	}
+
+	if opts.Filter != nil {
+		if supportsFilter(ra) {
+			req.filter = opts.Filter
+		} else {
+			log.Warnf("filtering not recognized by server, ignoring")
+		}
+	}
+
+	// A dumb server can't negotiate, so the objects are fetched by
+	// walking their history.
+	if hs, ok := s.(*httpSession); ok && hs.dumb {
+		return fetchDumb(hs, req.wants, store, shallow, opts)
+	}
+
+	checksum, err := fetchPackWithRequest(s, ra, store, req, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if checksum != "" && (opts.Promisor || req.filter != nil) {
+		err = objects.MarkPromisorPack(store.Dir, checksum)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(req.deepen) == 0 {
+		return checksum, nil
+	}
+	return checksum, req.update.apply(store.Dir, shallow)
+}
+
+func supportsFilter(ra *ReferenceAdvertisement) bool {
+	if ra.Version == ProtocolV2 {
+		return ra.Capabilities.Supports("fetch", "filter")
+	}
+	return ra.Capabilities.Has("filter")
+}
+
+func fetchPackWithRequest(s Session, ra *ReferenceAdvertisement, store *objects.DirStore, req *fetchRequest, opts *FetchOptions) (string, error) {
+	n := newNegotiator(store, opts.Haves)
+	packDir := paths.PackDir(store.Dir)
+	if ra.Version == ProtocolV2 {
+		return fetchPackV2(s, ra, req, n, packDir, opts)
+	}
+
+	capabilities := fetchCapabilities(ra, opts, len(req.deepen) > 0)
+
+	body, err := negotiateV0(s, ra, req, capabilities, n)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	if !capabilities.Has("side-band-64k") && !capabilities.Has("side-band") {
+		return indexPackToDir(body, packDir, opts.Progress)
+	}
+
+	return readSideBandPack(body, packDir, opts)
+}
+
+func readSideBandPack(r io.Reader, packDir string, opts *FetchOptions) (string, error) {
+	packStream := NewSideBandReader(r, opts.Progress)
+	checksum, err := indexPackToDir(packStream, packDir, opts.Progress)
+	if err != nil {
+		return "", err
+	}
+
+	// The remote may still have progress to report, or an error.
+	_, err = io.Copy(io.Discard, packStream)
+	if err != nil {
+		return "", errors.Wrap(err, "reading side-band")
+	}
+
+	return checksum, nil
+}
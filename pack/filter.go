@@ -0,0 +1,94 @@
+package pack
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// Filter is an object filter for a partial clone. It leaves blobs, and
+// possibly trees, out of a pack; explicitly wanted objects are always sent.
+type Filter struct {
+	spec string
+
+	// Blobs of at least blobLimit bytes are left out. Trees and blobs
+	// at least treeDepth below the root tree are left out. Negative
+	// values mean no limit.
+	blobLimit int64
+	treeDepth int
+}
+
+// ParseFilter parses a filter spec: blob:none, blob:limit=<n>[kmg] or
+// tree:<depth>.
+func ParseFilter(spec string) (*Filter, error) {
+	f := &Filter{spec: spec, blobLimit: -1, treeDepth: -1}
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "blob:none":
+		f.blobLimit = 0
+	case kind == "blob" && strings.HasPrefix(arg, "limit="):
+		limit, err := parseFilterSize(strings.TrimPrefix(arg, "limit="))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid filter "+spec)
+		}
+		f.blobLimit = limit
+	case kind == "tree":
+		depth, err := strconv.Atoi(arg)
+		if err != nil || depth < 0 {
+			return nil, fmt.Errorf("invalid filter %s", spec)
+		}
+		f.treeDepth = depth
+	default:
+		return nil, fmt.Errorf("unsupported filter %s", spec)
+	}
+
+	return f, nil
+}
+
+func parseFilterSize(size string) (int64, error) {
+	scale := int64(1)
+	switch {
+	case strings.HasSuffix(size, "k"):
+		scale = 1 << 10
+	case strings.HasSuffix(size, "m"):
+		scale = 1 << 20
+	case strings.HasSuffix(size, "g"):
+		scale = 1 << 30
+	}
+	if scale > 1 {
+		size = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * scale, nil
+}
+
+func (f *Filter) String() string {
+	return f.spec
+}
+
+// omitsTree reports whether a tree depth levels below a root tree is left
+// out. Root trees are at depth 0.
+func (f *Filter) omitsTree(depth int) bool {
+	return f != nil && f.treeDepth >= 0 && depth >= f.treeDepth
+}
+
+// omitsBlob reports whether a blob depth levels below a root tree is left
+// out.
+func (f *Filter) omitsBlob(depth int, size int64) bool {
+	if f == nil {
+		return false
+	}
+
+	return f.omitsTree(depth) || (f.blobLimit >= 0 && size >= f.blobLimit)
+}
+
+// needsSize reports whether omitsBlob needs real blob sizes, which means
+// reading each blob.
+func (f *Filter) needsSize() bool {
+	return f != nil && f.blobLimit > 0
+}
@@ -0,0 +1,125 @@
+package pack
+
+import (
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	for spec, expected := range map[string]Filter{
+		"blob:none":      {spec: "blob:none", blobLimit: 0, treeDepth: -1},
+		"blob:limit=512": {spec: "blob:limit=512", blobLimit: 512, treeDepth: -1},
+		"blob:limit=1k":  {spec: "blob:limit=1k", blobLimit: 1024, treeDepth: -1},
+		"tree:0":         {spec: "tree:0", blobLimit: -1, treeDepth: 0},
+	} {
+		filter, err := ParseFilter(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+
+		if *filter != expected {
+			t.Fatalf("%s: expected %#v, have %#v", spec, expected, *filter)
+		}
+	}
+
+	for _, spec := range []string{"blob:limit=", "blob:limit=1x", "tree:-1", "sparse:oid=HEAD", "blob"} {
+		if _, err := ParseFilter(spec); err == nil {
+			t.Fatalf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestPartialFetch(t *testing.T) {
+	srcDir, commits := newTestRepository(t, 3)
+	cfg := config.New()
+	cfg.Set("uploadpack.allowFilter", "true")
+	cfg.Set("uploadpack.allowReachableSHA1InWant", "true")
+	err := cfg.Save(filepath.Join(srcDir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewHTTPHandler(filepath.Dir(srcDir)))
+	defer srv.Close()
+
+	filter, err := ParseFilter("blob:none")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectsDir := filepath.Join(newBareRepository(t), "objects")
+	store := objects.NewDirStore(objectsDir)
+	fetch := func(wants []string) {
+		session := testSession(t, srv.URL+"/", ServiceUploadPack, ProtocolV0)
+		ra, err := ReadAdvertisement(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = FetchPack(session, ra, store, &FetchOptions{Wants: wants, Filter: filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetch(nil)
+	if !objects.IsPartialClone(objectsDir) {
+		t.Fatal("the filtered pack wasn't marked as a promisor pack")
+	}
+
+	commit, err := objects.ReadCommit(store, commits[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := store.Read(commit.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	treeObj, err := objects.TreeFromBlob(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blobID string
+	for _, entry := range treeObj.Entries() {
+		if entry.Mode != objects.ModeDirectory {
+			blobID = fmt.Sprintf("%x", entry.Hash)
+		}
+	}
+
+	if blobID == "" || store.Has(blobID) {
+		t.Fatalf("expected blob %q to be left out", blobID)
+	}
+
+	// Without a way to reach the remote, the blob is missing.
+	_, err = store.Read(blobID)
+	if !objects.IsMissingObject(err) {
+		t.Fatalf("expected a missing object error, have %v", err)
+	}
+
+	objects.FetchPromisedObjects = func(store *objects.DirStore, ids []string) error {
+		fetch(ids)
+		return nil
+	}
+	defer func() { objects.FetchPromisedObjects = nil }()
+
+	blob, err := store.Read(blobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blob.Type != objects.TypeBlob {
+		t.Fatalf("expected a blob, have a %s", blob.Type)
+	}
+
+	packs, err := filepath.Glob(filepath.Join(objectsDir, "pack", "*.promisor"))
+	if err != nil || len(packs) != 2 {
+		t.Fatalf("expected both packs to be promisor packs, have %v (%v)", packs, err)
+	}
+}
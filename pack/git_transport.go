@@ -0,0 +1,56 @@
+package pack
+
+import (
+	"context"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/transport"
+	"github.com/pkg/errors"
+	"net"
+)
+
+// DaemonPort is the port git daemon listens on.
+const DaemonPort = "9418"
+
+// writeDaemonRequest asks the daemon for a service. The host is the one
+// in the URL, so virtual hosting works; the protocol version comes after
+// an extra NUL, where older daemons don't look.
+func writeDaemonRequest(conn net.Conn, host, service, repoPath string, version int) error {
+	request := fmt.Sprintf("%s %s\x00host=%s\x00", service, repoPath, host)
+	if version != ProtocolV0 {
+		request += fmt.Sprintf("\x00version=%d\x00", version)
+	}
+
+	return writeLine(conn, request)
+}
+
+// connectGit talks to a git daemon over TCP.
+func connectGit(ctx context.Context, endpoint *transport.Endpoint, service string, opts *ConnectOptions) (Session, error) {
+	port := endpoint.Port
+	if port == "" {
+		port = DaemonPort
+	}
+
+	addr := net.JoinHostPort(endpoint.Host, port)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to git daemon")
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	err = writeDaemonRequest(conn, addr, service, endpoint.Path, opts.Version)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, errors.Wrap(err, "sending git daemon request")
+	}
+
+	return &streamSession{
+		r: conn,
+		w: conn,
+		close: func() error {
+			stop()
+			return nil
+		},
+	}, nil
+}
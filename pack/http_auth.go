@@ -0,0 +1,151 @@
+package pack
+
+import (
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/credential"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// httpAuth authenticates requests to a smart HTTP server. Like git, it
+// waits for the server to ask before sending a credential, then sends it
+// with every request that follows.
+type httpAuth struct {
+	// url is the repository URL, without any credentials.
+	url string
+
+	// base is what the URL says about the credential: the protocol,
+	// host, and any username and password.
+	base *credential.Credential
+
+	client      *httpClient
+	credentials *credential.Manager
+	current     *credential.Credential
+	approved    bool
+}
+
+// newHTTPAuth returns the authenticator for repo, along with repo stripped
+// of any credentials in it.
+func newHTTPAuth(repo string, credentials *credential.Manager, client *httpClient) (*httpAuth, string, error) {
+	repoURL, err := normalizeRepoURL(repo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	base := credential.FromURL(repoURL)
+	repoURL.User = nil
+	repo = repoURL.String()
+	return &httpAuth{url: repo, base: base, client: client, credentials: credentials}, repo, nil
+}
+
+func (a *httpAuth) authorize(req *http.Request) {
+	c := a.current
+	switch {
+	case c == nil:
+	case c.AuthType != "" && c.Credential != "":
+		req.Header.Set("Authorization", c.AuthType+" "+c.Credential)
+	default:
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// fill finds a credential for the server, trying the URL, then .netrc,
+// then the credential helpers.
+func (a *httpAuth) fill(wwwAuth []string) error {
+	c := *a.base
+	c.WWWAuth = wwwAuth
+	c.Capabilities = []string{"authtype"}
+
+	if !c.Complete() {
+		err := credential.Netrc(&c)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Complete() && a.credentials != nil {
+		err := a.credentials.Fill(&c)
+		if err != nil {
+			return fmt.Errorf("authentication required for %s: %v", a.url, err)
+		}
+	}
+
+	if !c.Complete() {
+		return fmt.Errorf("authentication required for %s", a.url)
+	}
+
+	a.current = &c
+	return nil
+}
+
+// rewindRequest returns a copy of req that can be sent again.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return retry, nil
+	}
+
+	if req.GetBody == nil {
+		return nil, errors.New("request is too large to resend with credentials")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, errors.Wrap(err, "rewinding request")
+	}
+
+	retry.Body = body
+	return retry, nil
+}
+
+// do sends req, authenticating if the server asks. A credential that
+// works is passed on to the helpers to save, and one that's refused is
+// passed on to them to forget.
+func (a *httpAuth) do(req *http.Request) (*http.Response, error) {
+	for {
+		a.authorize(req)
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			if resp.StatusCode < http.StatusMultipleChoices {
+				a.approve()
+			}
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if a.current != nil {
+			a.reject()
+			return nil, fmt.Errorf("authentication failed for %s", a.url)
+		}
+
+		err = a.fill(resp.Header.Values("WWW-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+
+		req, err = rewindRequest(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (a *httpAuth) approve() {
+	if a.approved || a.current == nil || a.credentials == nil {
+		return
+	}
+
+	a.credentials.Approve(a.current)
+	a.approved = true
+}
+
+func (a *httpAuth) reject() {
+	if a.credentials != nil {
+		a.credentials.Reject(a.current)
+	}
+	a.current = nil
+}
@@ -0,0 +1,147 @@
+package pack
+
+import (
+	"context"
+	"github.com/kisom/codecrafters/git-go/credential"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// requireAuth wraps handler so that it needs either the username and
+// password kyle:secret, or the bearer token s3cr3t.
+func requireAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		switch {
+		case ok && username == "kyle" && password == "secret":
+		case r.Header.Get("Authorization") == "Bearer s3cr3t":
+		default:
+			w.Header().Add("WWW-Authenticate", `Basic realm="git"`)
+			w.Header().Add("WWW-Authenticate", `Bearer realm="git"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func newHelper(t *testing.T, spec string) credential.Helper {
+	helper, err := credential.NewHelper(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return helper
+}
+
+func TestHTTPAuth(t *testing.T) {
+	srcDir, _ := newTestRepository(t, 2)
+	srv := httptest.NewServer(requireAuth(NewHTTPHandler(filepath.Dir(srcDir))))
+	defer srv.Close()
+
+	// Keep a .netrc in the home directory out of the way.
+	noNetrc := filepath.Join(t.TempDir(), "netrc")
+	t.Setenv("NETRC", noNetrc)
+
+	store := filepath.Join(t.TempDir(), "credentials")
+	storeHelper := newHelper(t, "store --file "+store)
+
+	clone := func(repo string, credentials *credential.Manager) error {
+		session, err := Connect(context.Background(), repo, ServiceUploadPack, &ConnectOptions{Credentials: credentials})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+
+		ra, err := ReadAdvertisement(session)
+		if err != nil {
+			return err
+		}
+
+		store := objects.NewDirStore(filepath.Join(newBareRepository(t), "objects"))
+		_, err = FetchPack(session, ra, store, &FetchOptions{})
+		return err
+	}
+
+	authURL := strings.Replace(srv.URL, "http://", "http://kyle:secret@", 1) + "/"
+	for _, test := range []struct {
+		name        string
+		repo        string
+		netrc       string
+		helpers     []credential.Helper
+		expectError string
+	}{
+		{name: "no credentials", repo: srv.URL + "/", expectError: "authentication required"},
+		{name: "URL", repo: authURL},
+		{
+			name:        "wrong URL password",
+			repo:        strings.Replace(authURL, "secret", "wrong", 1),
+			expectError: "authentication failed",
+		},
+		{name: "netrc", repo: srv.URL + "/", netrc: "default login kyle password secret\n"},
+		{
+			name:    "helper",
+			repo:    srv.URL + "/",
+			helpers: []credential.Helper{newHelper(t, "!echo username=kyle; echo password=secret; true"), storeHelper},
+		},
+		{
+			name:    "bearer",
+			repo:    srv.URL + "/",
+			helpers: []credential.Helper{newHelper(t, "!echo authtype=Bearer; echo credential=s3cr3t; true")},
+		},
+	} {
+		if test.netrc != "" {
+			netrc := filepath.Join(t.TempDir(), "netrc")
+			err := os.WriteFile(netrc, []byte(test.netrc), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.Setenv("NETRC", netrc)
+		}
+
+		err := clone(test.repo, &credential.Manager{Helpers: test.helpers})
+		os.Setenv("NETRC", noNetrc)
+		switch {
+		case test.expectError == "" && err != nil:
+			t.Fatalf("%s: %v", test.name, err)
+		case test.expectError != "" && (err == nil || !strings.Contains(err.Error(), test.expectError)):
+			t.Fatalf("%s: expected an error containing %q, have %v", test.name, test.expectError, err)
+		}
+	}
+
+	// The helper's credential worked, so it was approved and saved.
+	err := clone(srv.URL+"/", &credential.Manager{Helpers: []credential.Helper{storeHelper}})
+	if err != nil {
+		t.Fatalf("stored credential: %v", err)
+	}
+
+	// Once the server refuses it, it's forgotten.
+	err = storeHelper.Store(&credential.Credential{
+		Protocol: "http",
+		Host:     strings.TrimPrefix(srv.URL, "http://"),
+		Username: "kyle",
+		Password: "changed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = clone(srv.URL+"/", &credential.Manager{Helpers: []credential.Helper{storeHelper}})
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("expected authentication to fail, have %v", err)
+	}
+
+	data, err := os.ReadFile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 0 {
+		t.Fatalf("expected the refused credential to be erased, have %q", data)
+	}
+}
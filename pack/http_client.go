@@ -0,0 +1,209 @@
+package pack
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPOptions configures HTTP connections, as git's http.* settings do.
+type HTTPOptions struct {
+	// Proxy is the proxy to send requests through. Without one, the
+	// proxy environment variables are used.
+	Proxy string
+
+	// CAInfo names a file of certificates to verify servers with, in
+	// place of the system's.
+	CAInfo string
+
+	// SkipVerify turns off server certificate verification.
+	SkipVerify bool
+
+	// A transfer that averages less than LowSpeedLimit bytes a second
+	// for LowSpeedTime is aborted. Either being zero turns this off.
+	LowSpeedLimit int64
+	LowSpeedTime  time.Duration
+
+	// ExtraHeaders are "Name: value" headers sent with every request.
+	ExtraHeaders []string
+}
+
+// httpClient sends requests with the settings in HTTPOptions.
+type httpClient struct {
+	client *http.Client
+	opts   HTTPOptions
+	custom bool
+}
+
+func proxyURL(proxy string) (*url.URL, error) {
+	// Like git, a proxy without a scheme is taken to be an HTTP proxy.
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	u, err := url.Parse(proxy)
+	return u, errors.Wrap(err, "parsing proxy URL")
+}
+
+func newHTTPClient(opts HTTPOptions) (*httpClient, error) {
+	for _, header := range opts.ExtraHeaders {
+		if !strings.Contains(header, ":") {
+			return nil, fmt.Errorf("invalid extra header %q", header)
+		}
+	}
+
+	if opts.Proxy == "" && opts.CAInfo == "" && !opts.SkipVerify {
+		return &httpClient{client: http.DefaultClient, opts: opts}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Proxy != "" {
+		proxy, err := proxyURL(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.SkipVerify}
+	if opts.CAInfo != "" {
+		pem, err := os.ReadFile(opts.CAInfo)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA certificates")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates in %s", opts.CAInfo)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return &httpClient{client: &http.Client{Transport: transport}, opts: opts, custom: true}, nil
+}
+
+// speedCheck aborts a transfer that's too slow, counting the bytes that
+// pass through either way.
+type speedCheck struct {
+	limit    int64
+	interval time.Duration
+	count    atomic.Int64
+	slow     atomic.Bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newSpeedCheck(limit int64, interval time.Duration, cancel context.CancelFunc) *speedCheck {
+	sc := &speedCheck{limit: limit, interval: interval, cancel: cancel, done: make(chan struct{})}
+	go sc.watch()
+	return sc
+}
+
+func (sc *speedCheck) watch() {
+	ticker := time.NewTicker(sc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sc.done:
+			return
+		case <-ticker.C:
+			if float64(sc.count.Swap(0)) < float64(sc.limit)*sc.interval.Seconds() {
+				sc.slow.Store(true)
+				sc.cancel()
+				return
+			}
+		}
+	}
+}
+
+func (sc *speedCheck) stop() {
+	sc.stopOnce.Do(func() {
+		close(sc.done)
+		sc.cancel()
+	})
+}
+
+// check replaces the error from a transfer the check aborted.
+func (sc *speedCheck) check(err error) error {
+	if err != nil && sc.slow.Load() {
+		return fmt.Errorf("operation too slow: less than %d bytes/sec transferred the last %s",
+			sc.limit, sc.interval)
+	}
+	return err
+}
+
+// countedBody counts what's read through a request or response body.
+type countedBody struct {
+	io.ReadCloser
+	sc *speedCheck
+
+	// response is set on a response body, whose end is the end of the
+	// transfer.
+	response bool
+}
+
+func (b *countedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.sc.count.Add(int64(n))
+	if err == io.EOF {
+		return n, err
+	}
+	return n, b.sc.check(err)
+}
+
+func (b *countedBody) Close() error {
+	if b.response {
+		b.sc.stop()
+	}
+	return b.ReadCloser.Close()
+}
+
+// Do sends req with the extra headers, watching how fast it goes.
+func (c *httpClient) Do(req *http.Request) (*http.Response, error) {
+	// A request being resent already has the headers.
+	req = req.Clone(req.Context())
+	for _, header := range c.opts.ExtraHeaders {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if c.opts.LowSpeedLimit <= 0 || c.opts.LowSpeedTime <= 0 {
+		return c.client.Do(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	sc := newSpeedCheck(c.opts.LowSpeedLimit, c.opts.LowSpeedTime, cancel)
+	req = req.WithContext(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countedBody{ReadCloser: req.Body, sc: sc}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		sc.stop()
+		return nil, sc.check(err)
+	}
+
+	resp.Body = &countedBody{ReadCloser: resp.Body, sc: sc, response: true}
+	return resp, nil
+}
+
+// close lets go of idle connections, which a client of its own would
+// otherwise keep open.
+func (c *httpClient) close() {
+	if c.custom {
+		c.client.CloseIdleConnections()
+	}
+}
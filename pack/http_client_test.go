@@ -0,0 +1,123 @@
+package pack
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPOptions(t *testing.T) {
+	srcDir, _ := newTestRepository(t, 1)
+	handler := NewHTTPHandler(filepath.Dir(srcDir))
+
+	readAdvertisement := func(repo string, opts HTTPOptions) error {
+		session, err := Connect(context.Background(), repo, ServiceUploadPack, &ConnectOptions{HTTP: opts})
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+
+		_, err = ReadAdvertisement(session)
+		return err
+	}
+
+	// Requests for a host that doesn't exist reach the repository
+	// through the proxy, with the extra headers.
+	var proxied bool
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "git.example.invalid" || r.Header.Get("X-Extra") != "value" {
+			http.Error(w, "bad proxy request", http.StatusBadRequest)
+			return
+		}
+
+		proxied = true
+		handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	err := readAdvertisement("http://git.example.invalid/", HTTPOptions{
+		Proxy:        strings.TrimPrefix(proxy.URL, "http://"),
+		ExtraHeaders: []string{"X-Extra: value"},
+	})
+	if err != nil || !proxied {
+		t.Fatalf("proxied request failed: %v", err)
+	}
+
+	// The server logs the handshake the client refuses.
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	err = readAdvertisement(srv.URL+"/", HTTPOptions{})
+	if err == nil {
+		t.Fatal("expected a certificate error")
+	}
+
+	err = readAdvertisement(srv.URL+"/", HTTPOptions{SkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caInfo := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	err = os.WriteFile(caInfo, cert, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = readAdvertisement(srv.URL+"/", HTTPOptions{CAInfo: caInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPStall(t *testing.T) {
+	stall := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", advertisementContentType(ServiceUploadPack))
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(stall)
+
+	readAdvertisement := func(ctx context.Context, opts HTTPOptions) error {
+		session, err := Connect(ctx, srv.URL+"/", ServiceUploadPack, &ConnectOptions{HTTP: opts})
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+
+		_, err = ReadAdvertisement(session)
+		return err
+	}
+
+	err := readAdvertisement(context.Background(), HTTPOptions{
+		LowSpeedLimit: 1000,
+		LowSpeedTime:  100 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "too slow") {
+		t.Fatalf("expected the stalled transfer to be aborted, have %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = readAdvertisement(ctx, HTTPOptions{})
+	if err == nil || ctx.Err() == nil {
+		t.Fatalf("expected the cancelled transfer to fail, have %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package pack
+
+import (
+	"compress/gzip"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HTTPHandler serves the repositories under a directory over git's smart
+// HTTP protocol, like git http-backend. As there's no authentication,
+// pushes are only accepted by repositories with http.receivepack set.
+type HTTPHandler struct {
+	Root string
+}
+
+func NewHTTPHandler(root string) *HTTPHandler {
+	return &HTTPHandler{Root: root}
+}
+
+// findRepository maps a repository path from a URL to its git directory.
+func (h *HTTPHandler) findRepository(repoPath string) (string, bool) {
+	return paths.FindGitDir(filepath.Join(h.Root, filepath.FromSlash(path.Clean("/"+repoPath))))
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/info/refs"):
+		h.serveAdvertisement(w, r, strings.TrimSuffix(r.URL.Path, "/info/refs"))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+ServiceUploadPack):
+		h.serveService(w, r, strings.TrimSuffix(r.URL.Path, "/"+ServiceUploadPack), ServiceUploadPack)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+ServiceReceivePack):
+		h.serveService(w, r, strings.TrimSuffix(r.URL.Path, "/"+ServiceReceivePack), ServiceReceivePack)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *HTTPHandler) serveAdvertisement(w http.ResponseWriter, r *http.Request, repoPath string) {
+	gitDir, ok := h.findRepository(repoPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	service := r.URL.Query().Get("service")
+	if !serviceEnabled(gitDir, service) {
+		http.Error(w, "unsupported service "+service, http.StatusForbidden)
+		return
+	}
+
+	var ra *ReferenceAdvertisement
+	var err error
+	if service == ServiceReceivePack {
+		ra, err = advertiseReceivePack(gitDir, objects.NewDirStore(filepath.Join(gitDir, "objects")))
+	} else {
+		var capabilities Capabilities
+		capabilities, err = repoUploadPackCapabilities(gitDir)
+		if err == nil {
+			ra, err = AdvertiseReferences(gitDir, capabilities)
+		}
+	}
+	if err != nil {
+		log.Errf("advertising %s: %v", gitDir, err)
+		http.Error(w, "couldn't read references", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", advertisementContentType(service))
+	writeLine(w, "# service="+service)
+	writeFlush(w)
+	ra.Encode(w)
+}
+
+// serviceEnabled reports whether a repository can be used with a service.
+// Upload-pack always can, and receive-pack only with http.receivepack.
+func serviceEnabled(gitDir, service string) bool {
+	switch service {
+	case ServiceUploadPack:
+		return true
+	case ServiceReceivePack:
+		cfg, err := config.Load(filepath.Join(gitDir, "config"))
+		if err != nil {
+			return false
+		}
+
+		enabled, err := cfg.GetBool("http.receivepack", false)
+		return err == nil && enabled
+	default:
+		return false
+	}
+}
+
+func (h *HTTPHandler) serveService(w http.ResponseWriter, r *http.Request, repoPath, service string) {
+	gitDir, ok := h.findRepository(repoPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !serviceEnabled(gitDir, service) {
+		http.Error(w, "unsupported service "+service, http.StatusForbidden)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != requestContentType(service) {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "invalid gzip request", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	w.Header().Set("Content-Type", resultContentType(service))
+	var err error
+	if service == ServiceReceivePack {
+		err = ReceivePack(gitDir, body, w, true)
+	} else {
+		err = UploadPack(gitDir, body, w, true)
+	}
+	if err != nil {
+		log.Errf("%s for %s: %v", service, gitDir, err)
+	}
+}
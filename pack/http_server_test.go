@@ -0,0 +1,97 @@
+package pack
+
+import (
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRepository creates a repository with a main branch holding a
+// history of commits, returning its git directory and the commit IDs.
+func newTestRepository(t *testing.T, commits int) (string, []string) {
+	store, ids := newTestStore(t, commits)
+	gitDir := filepath.Dir(store.Dir)
+
+	err := paths.UpdateRef(gitDir, "refs/heads/main", ids[len(ids)-1])
+	if err == nil {
+		err = paths.UpdateSymbolicRef(gitDir, "HEAD", "refs/heads/main")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gitDir, ids
+}
+
+func TestHTTPHandler(t *testing.T) {
+	gitDir, commits := newTestRepository(t, 10)
+	srv := httptest.NewServer(NewHTTPHandler(filepath.Dir(gitDir)))
+	defer srv.Close()
+
+	session := testSession(t, srv.URL+"/", ServiceUploadPack, ProtocolV2)
+	ra, err := ListRefs(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target := ra.SymbolicRef("HEAD"); target != "refs/heads/main" {
+		t.Fatalf("expected HEAD to point to refs/heads/main, have %q", target)
+	}
+
+	if ref := ra.Lookup("refs/heads/main"); ref == nil || ref.ID != commits[9] {
+		t.Fatalf("expected refs/heads/main to be %s, have %#v", commits[9], ref)
+	}
+
+	// Fetch the first half of the history, then the rest.
+	objectsDir := filepath.Join(t.TempDir(), "objects")
+	err = os.MkdirAll(objectsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := objects.NewDirStore(objectsDir)
+
+	_, err = FetchPack(session, ra, store, &FetchOptions{Wants: commits[4:5]})
+	if err == nil {
+		t.Fatal("fetching an object that isn't a ref tip should fail")
+	}
+
+	err = paths.UpdateRef(gitDir, "refs/heads/old", commits[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ra, err = ListRefs(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = FetchPack(session, ra, store, &FetchOptions{Wants: commits[4:5]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := FetchPack(session, ra, store, &FetchOptions{Wants: commits[9:], Haves: commits[4:5]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := objects.OpenPackfile(filepath.Join(paths.PackDir(objectsDir), "pack-"+checksum+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pack.Close()
+
+	// Five new commits, each with a tree and a blob.
+	if pack.Index.Len() != 15 {
+		t.Fatalf("expected the second pack to have 15 objects, have %d", pack.Index.Len())
+	}
+
+	for _, id := range commits {
+		if !store.Has(id) {
+			t.Fatalf("commit %s wasn't fetched", id)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/pkg/errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CorruptEntryError reports a pack entry that couldn't be read or resolved.
+type CorruptEntryError struct {
+	Offset uint64
+	Err    error
+}
+
+func (err *CorruptEntryError) Error() string {
+	return fmt.Sprintf("corrupt pack entry at offset %d: %v", err.Offset, err.Err)
+}
+
+func (err *CorruptEntryError) Unwrap() error {
+	return err.Err
+}
+
+func corruptEntry(offset uint64, err error) error {
+	return &CorruptEntryError{Offset: offset, Err: err}
+}
+
+// packScanner reads a pack stream a byte at a time as far as the inflater
+// is concerned, so no more is consumed than each entry needs, while copying
+// everything it reads to the output and keeping the running checksums.
+type packScanner struct {
+	r      *bufio.Reader
+	w      *bufio.Writer
+	hash   hash.Hash
+	crc    hash.Hash32
+	offset uint64
+	err    error
+}
+
+func (s *packScanner) consume(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	s.hash.Write(p)
+	s.crc.Write(p)
+	s.offset += uint64(len(p))
+
+	if _, err := s.w.Write(p); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *packScanner) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	s.consume([]byte{b})
+	return b, nil
+}
+
+func (s *packScanner) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.consume(p[:n])
+	return n, err
+}
+
+type indexEntry struct {
+	offset     uint64
+	dataOffset uint64
+	objectType objects.PackObjectType
+	size       uint64
+	crc        uint32
+	id         []byte
+
+	baseOffset uint64
+	baseID     []byte
+}
+
+func objectID(objectType objects.PackObjectType, data []byte) []byte {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objectType, len(data))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// scanEntry reads one entry from the stream, checking that it inflates to
+// the size its header claims. Non-delta objects are hashed as they're read.
+func scanEntry(s *packScanner) (*indexEntry, error) {
+	entry := &indexEntry{offset: s.offset}
+	s.crc.Reset()
+
+	var err error
+	entry.objectType, entry.size, err = objects.ReadPackEntryHeader(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading entry header")
+	}
+
+	switch entry.objectType {
+	case objects.PackCommit, objects.PackTree, objects.PackBlob, objects.PackTag:
+	case objects.PackOffsetDelta:
+		distance, err := objects.ReadOffsetDeltaDistance(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading delta base offset")
+		}
+
+		if distance == 0 || distance > entry.offset {
+			return nil, fmt.Errorf("delta base distance %d is out of range", distance)
+		}
+		entry.baseOffset = entry.offset - distance
+	case objects.PackRefDelta:
+		entry.baseID = make([]byte, 20)
+		_, err = io.ReadFull(s, entry.baseID)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading delta base ID")
+		}
+	default:
+		return nil, fmt.Errorf("invalid object type %d", entry.objectType)
+	}
+
+	entry.dataOffset = s.offset
+	decoder, err := zlib.NewReader(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting to inflate entry")
+	}
+
+	h := sha1.New()
+	if !entry.objectType.IsDelta() {
+		fmt.Fprintf(h, "%s %d\x00", entry.objectType, entry.size)
+	}
+
+	n, err := io.Copy(h, decoder)
+	if err != nil {
+		return nil, errors.Wrap(err, "inflating entry")
+	}
+
+	err = decoder.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "inflating entry")
+	}
+
+	if uint64(n) != entry.size {
+		return nil, fmt.Errorf("entry inflated to %d bytes, but its header says %d", n, entry.size)
+	}
+
+	if !entry.objectType.IsDelta() {
+		entry.id = h.Sum(nil)
+	}
+	entry.crc = s.crc.Sum32()
+
+	return entry, nil
+}
+
+func inflateAt(ra io.ReaderAt, offset, size uint64) ([]byte, error) {
+	decoder, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(ra, int64(offset), 1<<62)))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(decoder, data)
+	return data, err
+}
+
+type deltaResolver struct {
+	ra       io.ReaderAt
+	byOffset map[uint64][]*indexEntry
+	byID     map[string][]*indexEntry
+	resolved int
+	meter    *progressMeter
+}
+
+// resolve computes the IDs of every delta built on top of base, and of
+// every delta built on those in turn.
+func (dr *deltaResolver) resolve(base *indexEntry, baseType objects.PackObjectType, data []byte) error {
+	children := append(dr.byOffset[base.offset], dr.byID[string(base.id)]...)
+	delete(dr.byOffset, base.offset)
+	delete(dr.byID, string(base.id))
+
+	for _, child := range children {
+		delta, err := inflateAt(dr.ra, child.dataOffset, child.size)
+		if err != nil {
+			return corruptEntry(child.offset, errors.Wrap(err, "rereading delta"))
+		}
+
+		result, err := objects.ApplyDelta(data, delta)
+		if err != nil {
+			return corruptEntry(child.offset, err)
+		}
+
+		child.id = objectID(baseType, result)
+		dr.resolved++
+		dr.meter.update(uint32(dr.resolved), 0)
+
+		err = dr.resolve(child, baseType, result)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IndexPack reads a pack from r, copying it to w, and returns its index. Deltas
+// are resolved by reading entries back through ra, which must see
+// everything written to w. The trailing pack checksum is verified.
+func IndexPack(r io.Reader, w io.Writer, ra io.ReaderAt) (*objects.PackIndex, error) {
+	return indexPack(r, w, ra, nil)
+}
+
+// indexPack is IndexPack, reporting the objects and bytes received and the
+// deltas resolved to progress as it goes, if it isn't nil.
+func indexPack(r io.Reader, w io.Writer, ra io.ReaderAt, progress io.Writer) (*objects.PackIndex, error) {
+	s := &packScanner{
+		r:    bufio.NewReader(r),
+		w:    bufio.NewWriter(w),
+		hash: sha1.New(),
+		crc:  crc32.NewIEEE(),
+	}
+
+	var header [12]byte
+	_, err := io.ReadFull(s, header[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "reading pack header")
+	}
+
+	if !bytes.Equal(header[:4], []byte("PACK")) {
+		return nil, fmt.Errorf("pack has invalid magic %x", header[:4])
+	}
+
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+
+	count := binary.BigEndian.Uint32(header[8:])
+	receiving := newProgressMeter(progress, "Receiving objects", count)
+
+	// The count comes from the remote, so it's only a hint for how much
+	// to allocate.
+	entries := make([]*indexEntry, 0, min(count, 1<<20))
+	for i := uint32(0); i < count; i++ {
+		offset := s.offset
+		entry, err := scanEntry(s)
+		if err != nil {
+			return nil, corruptEntry(offset, err)
+		}
+
+		if s.err != nil {
+			return nil, errors.Wrap(s.err, "writing pack")
+		}
+		entries = append(entries, entry)
+		receiving.update(i+1, s.offset)
+	}
+
+	checksum := s.hash.Sum(nil)
+	trailer := make([]byte, sha1.Size)
+	_, err = io.ReadFull(s.r, trailer)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading pack checksum")
+	}
+
+	if !bytes.Equal(checksum, trailer) {
+		return nil, fmt.Errorf("pack checksum mismatch; have %x, pack says %x", checksum, trailer)
+	}
+	receiving.done(count, s.offset+sha1.Size)
+
+	_, err = s.w.Write(trailer)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "writing pack")
+	}
+
+	dr := &deltaResolver{
+		ra:       ra,
+		byOffset: map[uint64][]*indexEntry{},
+		byID:     map[string][]*indexEntry{},
+	}
+
+	deltas := 0
+	for _, entry := range entries {
+		switch entry.objectType {
+		case objects.PackOffsetDelta:
+			dr.byOffset[entry.baseOffset] = append(dr.byOffset[entry.baseOffset], entry)
+			deltas++
+		case objects.PackRefDelta:
+			dr.byID[string(entry.baseID)] = append(dr.byID[string(entry.baseID)], entry)
+			deltas++
+		}
+	}
+
+	dr.meter = newProgressMeter(progress, "Resolving deltas", uint32(deltas))
+	for _, entry := range entries {
+		if entry.objectType.IsDelta() {
+			continue
+		}
+
+		if len(dr.byOffset[entry.offset]) == 0 && len(dr.byID[string(entry.id)]) == 0 {
+			continue
+		}
+
+		data, err := inflateAt(ra, entry.dataOffset, entry.size)
+		if err != nil {
+			return nil, corruptEntry(entry.offset, errors.Wrap(err, "rereading entry"))
+		}
+
+		err = dr.resolve(entry, entry.objectType, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dr.resolved != deltas {
+		for _, entry := range entries {
+			if entry.objectType.IsDelta() && entry.id == nil {
+				if entry.baseID != nil {
+					return nil, corruptEntry(entry.offset, fmt.Errorf("delta base %x is missing", entry.baseID))
+				}
+				return nil, corruptEntry(entry.offset, fmt.Errorf("delta base at offset %d is missing", entry.baseOffset))
+			}
+		}
+	}
+
+	dr.meter.done(uint32(dr.resolved), 0)
+
+	indexEntries := make([]objects.PackIndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		indexEntries = append(indexEntries, objects.PackIndexEntry{
+			ID:     entry.id,
+			CRC:    entry.crc,
+			Offset: entry.offset,
+		})
+	}
+
+	return objects.NewPackIndex(indexEntries, checksum), nil
+}
+
+// IndexPackToDir stores the pack read from r in packDir along with its
+// index, returning the pack checksum.
+func IndexPackToDir(r io.Reader, packDir string) (string, error) {
+	return indexPackToDir(r, packDir, nil)
+}
+
+func indexPackToDir(r io.Reader, packDir string, progress io.Writer) (string, error) {
+	err := os.MkdirAll(packDir, 0755)
+	if err != nil {
+		return "", errors.Wrap(err, "creating pack directory")
+	}
+
+	tmp, err := os.CreateTemp(packDir, "tmp_pack_")
+	if err != nil {
+		return "", errors.Wrap(err, "creating temporary packfile")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	index, err := indexPack(r, tmp, tmp, progress)
+	if err != nil {
+		return "", err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "closing temporary packfile")
+	}
+
+	return finishPackFiles(tmp.Name(), filepath.Join(packDir, "pack"), index)
+}
+
+// IndexPackFile writes an index for an existing packfile to indexPath.
+func IndexPackFile(packPath, indexPath string) (string, error) {
+	file, err := os.Open(packPath)
+	if err != nil {
+		return "", errors.Wrap(err, "opening packfile")
+	}
+	defer file.Close()
+
+	index, err := IndexPack(file, io.Discard, file)
+	if err != nil {
+		return "", err
+	}
+
+	indexFile, err := os.Create(indexPath)
+	if err != nil {
+		return "", errors.Wrap(err, "creating pack index")
+	}
+	defer indexFile.Close()
+
+	_, err = index.WriteTo(indexFile)
+	if err != nil {
+		return "", errors.Wrap(err, "writing pack index")
+	}
+
+	return hex.EncodeToString(index.PackChecksum), indexFile.Close()
+}
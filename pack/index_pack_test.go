@@ -0,0 +1,149 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func indexTestPack(t *testing.T, contents []byte) ([]byte, error) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	index, err := IndexPack(bytes.NewReader(contents), file, file)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	_, err = index.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(written, contents) {
+		t.Fatal("pack written by IndexPack doesn't match its input")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func TestIndexPack(t *testing.T) {
+	store, commits := newTestStore(t, 20)
+
+	revObjects, err := RevList(store, commits[len(commits)-1:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packed := &bytes.Buffer{}
+	expected, err := WritePack(packed, store, revObjects, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedIndex := &bytes.Buffer{}
+	_, err = expected.WriteTo(expectedIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := indexTestPack(t, packed.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(index, expectedIndex.Bytes()) {
+		t.Fatal("index doesn't match the one written with the pack")
+	}
+}
+
+func TestIndexPackRefDeltas(t *testing.T) {
+	contents, err := os.ReadFile("testdata/pack-ref.pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := os.ReadFile("testdata/pack-ref.idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := indexTestPack(t, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(index, expected) {
+		t.Fatal("index doesn't match the one git wrote")
+	}
+}
+
+func TestIndexPackCorrupt(t *testing.T) {
+	contents, err := os.ReadFile("testdata/pack-ref.pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := append([]byte{}, contents...)
+	corrupt[320] ^= 0xff
+
+	_, err = indexTestPack(t, corrupt)
+	var entryErr *CorruptEntryError
+	if !errors.As(err, &entryErr) {
+		t.Fatalf("expected a corrupt entry error, have %v", err)
+	}
+
+	if entryErr.Offset != 304 {
+		t.Fatalf("expected corruption to be reported at offset 304, have %d", entryErr.Offset)
+	}
+
+	badChecksum := append([]byte{}, contents...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+	_, err = indexTestPack(t, badChecksum)
+	if err == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+}
+
+func TestIndexPackProgress(t *testing.T) {
+	store, commits := newTestStore(t, 20)
+	revObjects, err := RevList(store, commits[len(commits)-1:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packed := &bytes.Buffer{}
+	_, err = WritePack(packed, store, revObjects, DefaultPackOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress := &bytes.Buffer{}
+	_, err = indexPackToDir(packed, t.TempDir(), progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(progress.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Receiving objects:") || !strings.HasPrefix(lines[1], "Resolving deltas:") {
+		t.Fatalf("unexpected progress %q", progress.String())
+	}
+
+	expected := fmt.Sprintf("100%% (%d/%d), ", len(revObjects), len(revObjects))
+	if !strings.Contains(lines[0], expected) || !strings.HasSuffix(lines[0], ", done.") {
+		t.Fatalf("expected the final count of received objects, have %q", lines[0])
+	}
+}
@@ -0,0 +1,77 @@
+package pack
+
+import (
+	"context"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/transport"
+	"io"
+	"path/filepath"
+)
+
+// LocalGitDir returns the git directory of a repository given as a local
+// path or file:// URL.
+func LocalGitDir(repo string) (string, bool) {
+	endpoint, err := transport.ParseEndpoint(repo)
+	if err != nil || endpoint.Protocol != transport.ProtocolFile {
+		return "", false
+	}
+
+	gitDir, ok := paths.FindGitDir(endpoint.Path)
+	if !ok {
+		return "", false
+	}
+
+	abs, err := filepath.Abs(gitDir)
+	if err != nil {
+		return "", false
+	}
+	return abs, true
+}
+
+// connectLocal runs the service in-process against a repository on this
+// machine, talking to it over a pair of pipes.
+func connectLocal(ctx context.Context, dir, service string) (Session, error) {
+	gitDir, ok := paths.FindGitDir(dir)
+	if !ok {
+		return nil, fmt.Errorf("%s does not appear to be a git repository", dir)
+	}
+
+	var serve func(gitDir string, r io.Reader, w io.Writer, stateless bool) error
+	switch service {
+	case ServiceUploadPack:
+		serve = UploadPack
+	case ServiceReceivePack:
+		serve = ReceivePack
+	default:
+		return nil, fmt.Errorf("unsupported service %s", service)
+	}
+
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := serve(gitDir, serverR, serverW, false)
+
+		// Once the server's finished, the client can't write to it
+		// any more, and reads what it's written then an error or EOF.
+		serverR.Close()
+		serverW.CloseWithError(err)
+		done <- err
+	}()
+
+	stop := context.AfterFunc(ctx, func() {
+		clientR.CloseWithError(ctx.Err())
+		serverR.CloseWithError(ctx.Err())
+	})
+
+	return &streamSession{
+		r: clientR,
+		w: clientW,
+		close: func() error {
+			stop()
+			clientR.Close()
+			return <-done
+		},
+	}, nil
+}
@@ -0,0 +1,132 @@
+package pack
+
+import (
+	"context"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testSession(t *testing.T, repo, service string, version int) Session {
+	s, err := Connect(context.Background(), repo, service, &ConnectOptions{Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newBareRepository(t *testing.T) string {
+	gitDir := filepath.Join(t.TempDir(), "repo.git")
+	for _, dir := range []string{"objects/pack", "refs/heads", "refs/tags"} {
+		err := os.MkdirAll(filepath.Join(gitDir, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := paths.UpdateSymbolicRef(gitDir, "HEAD", "refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gitDir
+}
+
+func TestLocalFetch(t *testing.T) {
+	srcDir, commits := newTestRepository(t, 10)
+	err := paths.UpdateRef(srcDir, "refs/heads/old", commits[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectsDir := filepath.Join(newBareRepository(t), "objects")
+	store := objects.NewDirStore(objectsDir)
+	session := testSession(t, "file://"+filepath.Dir(srcDir), ServiceUploadPack, ProtocolV2)
+	ra, err := ListRefs(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = FetchPack(session, ra, store, &FetchOptions{Wants: commits[4:5]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second fetch negotiates over a single stateful session.
+	session = testSession(t, srcDir, ServiceUploadPack, ProtocolV2)
+	ra, err = ListRefs(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := FetchPack(session, ra, store, &FetchOptions{Wants: commits[9:], Haves: commits[4:5]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := objects.OpenPackfile(filepath.Join(paths.PackDir(objectsDir), "pack-"+checksum+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pack.Close()
+
+	if pack.Index.Len() != 15 {
+		t.Fatalf("expected the second pack to have 15 objects, have %d", pack.Index.Len())
+	}
+}
+
+func TestLocalPush(t *testing.T) {
+	srcDir, commits := newTestRepository(t, 10)
+	dstDir := newBareRepository(t)
+
+	session := testSession(t, dstDir, ServiceReceivePack, ProtocolV0)
+	ra, err := ReadAdvertisement(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ra.References) != 0 || !ra.HasCapability("report-status") {
+		t.Fatalf("unexpected advertisement from an empty repository: %#v", ra)
+	}
+
+	update := &RefUpdate{Name: "refs/heads/main", OldID: ZeroID, NewID: commits[9]}
+	report, err := SendPack(session, ra, objects.NewDirStore(filepath.Join(srcDir, "objects")), []*RefUpdate{update}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := report.Status("refs/heads/main"); report.UnpackError != "" || status == nil || status.Error != "" {
+		t.Fatalf("push failed: %#v", report)
+	}
+
+	if id, err := paths.ReadRef(dstDir, "refs/heads/main"); err != nil || id != commits[9] {
+		t.Fatalf("expected refs/heads/main to be %s, have %s (%v)", commits[9], id, err)
+	}
+
+	dst := objects.NewDirStore(filepath.Join(dstDir, "objects"))
+	for _, id := range commits {
+		if !dst.Has(id) {
+			t.Fatalf("commit %s wasn't pushed", id)
+		}
+	}
+
+	// The source repository has main checked out, so it can't be moved.
+	session = testSession(t, srcDir, ServiceReceivePack, ProtocolV0)
+	ra, err = ReadAdvertisement(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update = &RefUpdate{Name: "refs/heads/main", OldID: commits[9], NewID: commits[4]}
+	report, err = SendPack(session, ra, dst, []*RefUpdate{update}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := report.Status("refs/heads/main"); status == nil || status.Error != "branch is currently checked out" {
+		t.Fatalf("expected the push to be refused, have %#v", status)
+	}
+}
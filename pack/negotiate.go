@@ -0,0 +1,338 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	haveBatchSize = 32
+
+	// maxHavesInVain is how many haves git sends without finding anything
+	// in common before it gives up and asks for the pack.
+	maxHavesInVain = 256
+)
+
+type negotiationCommit struct {
+	id      string
+	time    time.Time
+	parents []string
+	common  bool
+}
+
+// negotiator walks local history newest first to produce have lines. Once
+// the server says it has a commit, its ancestors aren't offered.
+type negotiator struct {
+	store  objects.ObjectStore
+	queue  []*negotiationCommit
+	seen   map[string]*negotiationCommit
+	common []string
+	inVain int
+
+	// shallow commits are offered without their parents, which aren't
+	// stored locally.
+	shallow map[string]bool
+}
+
+func newNegotiator(store *objects.DirStore, tips []string) *negotiator {
+	n := &negotiator{
+		store: store,
+		seen:  map[string]*negotiationCommit{},
+	}
+
+	shallow, err := repoShallow(store.Dir)
+	if err != nil {
+		log.Debugf("negotiation: reading shallow commits: %v", err)
+	}
+	n.shallow = shallow
+
+	for _, id := range tips {
+		n.push(id)
+	}
+	return n
+}
+
+// push queues a commit, peeling tags. Objects that aren't commits, or that
+// can't be read, are left out; they're only hints to the server.
+func (n *negotiator) push(id string) {
+	for n.seen[id] == nil {
+		blob, err := n.store.Read(id)
+		if err != nil {
+			log.Debugf("negotiation: skipping %s: %v", id, err)
+			return
+		}
+
+		switch blob.Type {
+		case objects.TypeTag:
+			id, err = tagTarget(blob)
+			if err != nil {
+				return
+			}
+			continue
+		case objects.TypeCommit:
+		default:
+			return
+		}
+
+		commit, err := objects.CommitFromBlob(blob)
+		if err != nil {
+			return
+		}
+
+		nc := &negotiationCommit{id: id, time: commitTime(commit), parents: commit.Parents}
+		if n.shallow[id] {
+			nc.parents = nil
+		}
+
+		n.seen[id] = nc
+		i := sort.Search(len(n.queue), func(i int) bool {
+			return n.queue[i].time.Before(nc.time)
+		})
+		n.queue = append(n.queue, nil)
+		copy(n.queue[i+1:], n.queue[i:])
+		n.queue[i] = nc
+	}
+}
+
+// next returns the newest commit that hasn't been offered yet.
+func (n *negotiator) next() (string, bool) {
+	for len(n.queue) > 0 {
+		nc := n.queue[0]
+		n.queue = n.queue[1:]
+
+		if nc.common {
+			continue
+		}
+
+		for _, parent := range nc.parents {
+			n.push(parent)
+		}
+		return nc.id, true
+	}
+
+	return "", false
+}
+
+// haves returns the next batch of commits to offer.
+func (n *negotiator) haves() []string {
+	var batch []string
+	for len(batch) < haveBatchSize {
+		id, ok := n.next()
+		if !ok {
+			break
+		}
+		batch = append(batch, id)
+	}
+
+	n.inVain += len(batch)
+	return batch
+}
+
+func (n *negotiator) done() bool {
+	return len(n.queue) == 0 || n.inVain >= maxHavesInVain
+}
+
+// markCommon records that the server has a commit, and so everything
+// reachable from it.
+func (n *negotiator) markCommon(id string) {
+	nc := n.seen[id]
+	if nc == nil || nc.common {
+		return
+	}
+
+	n.common = append(n.common, id)
+	n.inVain = 0
+
+	stack := []*negotiationCommit{nc}
+	for len(stack) > 0 {
+		nc = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		nc.common = true
+
+		for _, parent := range nc.parents {
+			if pc := n.seen[parent]; pc != nil && !pc.common {
+				stack = append(stack, pc)
+			}
+		}
+	}
+}
+
+func writeWants(w io.Writer, wants []string, capabilities Capabilities) error {
+	for i, id := range wants {
+		ref := &Reference{ID: id}
+		var err error
+		if i == 0 {
+			err = ref.Want(w, capabilities)
+		} else {
+			err = ref.Want(w, nil)
+		}
+		if err != nil {
+			return errors.Wrap(err, "writing wants")
+		}
+	}
+
+	return nil
+}
+
+func writeHaves(w io.Writer, haves []string) {
+	for _, id := range haves {
+		(&Reference{ID: id}).Have(w)
+	}
+}
+
+// readAcks reads a multi_ack_detailed response up to its NAK, returning
+// true once the server is ready to send a pack.
+func (n *negotiator) readAcks(r io.Reader) (bool, error) {
+	ready := false
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return false, errors.Wrap(err, "reading acknowledgments")
+		}
+
+		fields := strings.Fields(string(line))
+		switch {
+		case len(fields) == 1 && fields[0] == "NAK":
+			return ready, nil
+		case len(fields) == 3 && fields[0] == "ACK":
+			n.markCommon(fields[1])
+			if fields[2] == "ready" {
+				ready = true
+			}
+		default:
+			return false, fmt.Errorf("unexpected acknowledgment %q", line)
+		}
+	}
+}
+
+// readFinalAck skips the acknowledgments sent in response to done, which
+// end with a NAK or a bare ACK just before the pack.
+func readFinalAck(r io.Reader) error {
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading upload-pack response")
+		}
+
+		fields := strings.Fields(string(line))
+		switch {
+		case len(fields) == 1 && fields[0] == "NAK":
+			return nil
+		case len(fields) == 2 && fields[0] == "ACK":
+			return nil
+		case len(fields) == 3 && fields[0] == "ACK":
+			continue
+		}
+
+		return fmt.Errorf("unexpected upload-pack response %q", line)
+	}
+}
+
+// v0Requester builds the requests of a protocol v0 negotiation. Over a
+// stateless transport, every request repeats the wants and the commits
+// already found in common, since the server doesn't keep any state between
+// them; over a stateful one, the wants are sent once and each request
+// only carries the new haves.
+type v0Requester struct {
+	s            Session
+	req          *fetchRequest
+	capabilities Capabilities
+	sentWants    bool
+}
+
+func (v *v0Requester) request(n *negotiator, haves []string, done bool) (io.ReadCloser, error) {
+	request := &bytes.Buffer{}
+	firstRequest := !v.sentWants
+	if v.s.Stateless() || firstRequest {
+		err := writeWants(request, v.req.wants, v.capabilities)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range v.req.args() {
+			writeLine(request, line)
+		}
+		writeFlush(request)
+		v.sentWants = true
+	}
+
+	if v.s.Stateless() {
+		writeHaves(request, n.common)
+	}
+	writeHaves(request, haves)
+
+	if done {
+		writeLine(request, "done")
+	} else {
+		writeFlush(request)
+	}
+
+	body, err := v.s.Request(request)
+	if err != nil || !v.req.deepening || !(v.s.Stateless() || firstRequest) {
+		return body, err
+	}
+
+	// The answer to the wants comes first.
+	err = v.req.update.readShallowInfo(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+// negotiateV0 runs the protocol v0 negotiation and returns the response
+// carrying the pack.
+func negotiateV0(s Session, ra *ReferenceAdvertisement, req *fetchRequest, capabilities Capabilities, n *negotiator) (io.ReadCloser, error) {
+	v := &v0Requester{s: s, req: req, capabilities: capabilities}
+	multiAck := ra.HasCapability("multi_ack_detailed")
+	for multiAck && !n.done() {
+		batch := n.haves()
+		if len(batch) == 0 {
+			break
+		}
+
+		body, err := v.request(n, batch, false)
+		if err != nil {
+			return nil, err
+		}
+
+		ready, err := n.readAcks(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugf("negotiation: %d commits in common", len(n.common))
+		if ready {
+			break
+		}
+	}
+
+	// Without multi_ack_detailed, there's a single round, so offer as
+	// much as git would before giving up.
+	var haves []string
+	for !multiAck && !n.done() {
+		haves = append(haves, n.haves()...)
+	}
+
+	body, err := v.request(n, haves, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = readFinalAck(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	return body, nil
+}
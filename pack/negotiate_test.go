@@ -0,0 +1,35 @@
+package pack
+
+import "testing"
+
+func TestNegotiator(t *testing.T) {
+	store, commits := newTestStore(t, 100)
+
+	n := newNegotiator(store, commits[len(commits)-1:])
+	batch := n.haves()
+	if len(batch) != haveBatchSize {
+		t.Fatalf("expected %d haves, have %d", haveBatchSize, len(batch))
+	}
+
+	// Haves go newest first.
+	for i, id := range batch {
+		if expected := commits[len(commits)-1-i]; id != expected {
+			t.Fatalf("have %d is %s, expected %s", i, id, expected)
+		}
+	}
+
+	// Once the server has a commit, nothing older is offered.
+	n.markCommon(commits[70])
+	if len(n.common) != 1 || n.common[0] != commits[70] {
+		t.Fatalf("expected %s to be common, have %v", commits[70], n.common)
+	}
+
+	batch = n.haves()
+	if len(batch) != 0 {
+		t.Fatalf("expected no more haves, have %d", len(batch))
+	}
+
+	if !n.done() {
+		t.Fatal("negotiator should have run out of haves")
+	}
+}
@@ -1,7 +1,9 @@
 package pack
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"git.wntrmute.dev/kyle/goutils/log"
 	"github.com/pkg/errors"
@@ -12,14 +14,26 @@ import (
 )
 
 const (
-	serviceGitUploadPackAdvertisement = "git-upload-pack-advertisement"
-	serviceGitUploadPack              = "git-upload-pack"
+	ServiceUploadPack  = "git-upload-pack"
+	ServiceReceivePack = "git-receive-pack"
 )
 
 func serviceContentType(service string) string {
 	return fmt.Sprintf("application/x-%s", service)
 }
 
+func advertisementContentType(service string) string {
+	return serviceContentType(service + "-advertisement")
+}
+
+func requestContentType(service string) string {
+	return serviceContentType(service + "-request")
+}
+
+func resultContentType(service string) string {
+	return serviceContentType(service + "-result")
+}
+
 func normalizeRepoURL(repo string) (*url.URL, error) {
 	repoURL, err := url.Parse(repo)
 	if err != nil {
@@ -47,40 +61,203 @@ func serviceURL(repo, service string) (string, error) {
 	return repoURL.String(), nil
 }
 
-func FetchReferenceAdvertisement(repo string) (*ReferenceAdvertisement, error) {
-	repoURL, err := serviceURL(repo, serviceGitUploadPackAdvertisement)
+// setProtocolHeader asks the server to speak a newer protocol version. A
+// server that doesn't understand the header ignores it.
+func setProtocolHeader(req *http.Request, version int) {
+	if version != ProtocolV0 {
+		req.Header.Set(protocolHeader, fmt.Sprintf("version=%d", version))
+	}
+}
+
+// httpSession talks to a service over smart HTTP. Each request is a
+// separate POST, so the protocol is stateless.
+type httpSession struct {
+	ctx     context.Context
+	repo    string
+	service string
+	version int
+
+	// advertisement is the body of the advertisement response, which
+	// is read as a stream and closed with the session.
+	advertisement io.Closer
+
+	// auth sends requests, authenticating once the server asks.
+	auth *httpAuth
+
+	// dumb is set when the server turns out to be a plain file server,
+	// which objects are fetched from one by one.
+	dumb bool
+}
+
+func newHTTPSession(ctx context.Context, repo, service string, opts *ConnectOptions) (*httpSession, error) {
+	client, err := newHTTPClient(opts.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, repo, err := newHTTPAuth(repo, opts.Credentials, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpSession{ctx: ctx, repo: repo, service: service, version: opts.Version, auth: auth}, nil
+}
+
+func (s *httpSession) Advertisement() (io.Reader, error) {
+	repoURL, err := serviceURL(s.repo, s.service)
 	if err != nil {
 		return nil, errors.Wrap(err, "normalizing repo URL")
 	}
 
-	resp, err := http.Get(repoURL)
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, repoURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "building advertisement request")
+	}
+	setProtocolHeader(req, s.version)
+
+	resp, err := s.auth.do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "fetching repo packfile")
+		return nil, errors.Wrap(err, "fetching reference advertisement")
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
-		return nil, fmt.Errorf("fetching repo packfile returned http status code %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching reference advertisement returned http status code %d", resp.StatusCode)
 	}
 
-	if resp.Header.Get("Content-Type") != serviceContentType(serviceGitUploadPack) {
-		return nil, fmt.Errorf("unsupported content type %q (expect %s)",
-			resp.Header.Get("Content-Type"), serviceContentType(serviceGitUploadPack))
+	// A server that doesn't know the smart protocol sends info/refs as
+	// it's stored, which can still be fetched from.
+	if resp.Header.Get("Content-Type") != advertisementContentType(s.service) {
+		if s.service != ServiceUploadPack {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unsupported content type %q (expect %s)",
+				resp.Header.Get("Content-Type"), advertisementContentType(s.service))
+		}
+
+		log.Debugf("falling back to dumb HTTP for %s", s.repo)
+		return s.dumbAdvertisement(resp.Body)
+	}
+
+	s.closeAdvertisement()
+	s.advertisement = resp.Body
+	body := bufio.NewReader(resp.Body)
+	if s.version != ProtocolV0 {
+		return body, nil
 	}
 
-	packfile, err := io.ReadAll(resp.Body)
+	magic, err := body.Peek(5)
 	if err != nil {
-		return nil, errors.Wrap(err, "reading packfile")
+		return nil, errors.Wrap(err, "reading reference advertisement")
 	}
 
-	if !referenceAdvertisementMagic.Match(packfile[:5]) {
-		return nil, fmt.Errorf("packfile contains invalid magic %x", packfile[:5])
+	if !referenceAdvertisementMagic.Match(magic) {
+		return nil, fmt.Errorf("reference advertisement contains invalid magic %x", magic)
 	}
 
-	ra := &ReferenceAdvertisement{}
-	err = ra.UnmarshalReader(bytes.NewBuffer(packfile))
+	return body, nil
+}
+
+func (s *httpSession) Request(body io.Reader) (io.ReadCloser, error) {
+	request, err := bufferRequest(body)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing repo packfile body")
+		return nil, err
 	}
-	return ra, nil
+
+	return s.post(request)
+}
+
+func (s *httpSession) Stateless() bool {
+	return true
+}
+
+func (s *httpSession) closeAdvertisement() error {
+	if s.advertisement == nil {
+		return nil
+	}
+
+	err := s.advertisement.Close()
+	s.advertisement = nil
+	return err
+}
+
+func (s *httpSession) Close() error {
+	err := s.closeAdvertisement()
+	s.auth.client.close()
+	return err
+}
+
+func FetchReferenceAdvertisement(ctx context.Context, repo string) (*ReferenceAdvertisement, error) {
+	s, err := newHTTPSession(ctx, repo, ServiceUploadPack, &ConnectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return ReadAdvertisement(s)
+}
+
+func serviceRPCURL(repo, service string) (string, error) {
+	repoURL, err := normalizeRepoURL(repo)
+	if err != nil {
+		return "", errors.Wrap(err, "normalizing repo URL")
+	}
+
+	repoURL.Path = path.Join(repoURL.Path, service)
+	log.Debugf("repo service RPC URL: %s", repoURL.String())
+
+	return repoURL.String(), nil
+}
+
+// postBufferSize matches git's default http.postBuffer. Requests up to
+// this size are sent whole with a Content-Length; larger ones are streamed
+// with chunked encoding, which not every server supports.
+const postBufferSize = 1 << 20
+
+func bufferRequest(r io.Reader) (io.Reader, error) {
+	buf := make([]byte, postBufferSize)
+	n, err := io.ReadFull(r, buf)
+	switch err {
+	case nil:
+		return io.MultiReader(bytes.NewReader(buf), r), nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return bytes.NewReader(buf[:n]), nil
+	}
+
+	return nil, errors.Wrap(err, "building request")
+}
+
+// post sends a request body to the service and returns the response body,
+// which the caller must close.
+func (s *httpSession) post(body io.Reader) (io.ReadCloser, error) {
+	service := s.service
+	rpcURL, err := serviceRPCURL(s.repo, service)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, rpcURL, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "building "+service+" request")
+	}
+	req.Header.Set("Content-Type", requestContentType(service))
+	req.Header.Set("Accept", resultContentType(service))
+	setProtocolHeader(req, s.version)
+
+	resp, err := s.auth.do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "sending "+service+" request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s request returned http status code %d", service, resp.StatusCode)
+	}
+
+	if resp.Header.Get("Content-Type") != resultContentType(service) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unsupported content type %q (expect %s)",
+			resp.Header.Get("Content-Type"), resultContentType(service))
+	}
+
+	return resp.Body, nil
 }
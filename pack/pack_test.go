@@ -1,6 +1,9 @@
 package pack
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 )
@@ -11,9 +14,22 @@ func TestFetchPackfile(t *testing.T) {
 	}
 
 	repo := "github.com/kisom/codecrafters-git-go"
-	_, err := FetchReferenceAdvertisement(repo)
+	_, err := FetchReferenceAdvertisement(context.Background(), repo)
 	if err != nil {
 		t.Fatalf("FetchReferenceAdvertisement failed: %s", err)
 	}
 
 }
+
+func TestShortAdvertisement(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", advertisementContentType(ServiceUploadPack))
+		w.Write([]byte("00"))
+	}))
+	defer srv.Close()
+
+	_, err := FetchReferenceAdvertisement(context.Background(), srv.URL+"/repo.git")
+	if err == nil {
+		t.Fatal("expected a truncated advertisement to fail")
+	}
+}
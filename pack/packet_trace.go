@@ -0,0 +1,93 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// packetTrace is where pkt-lines are traced to, as GIT_TRACE_PACKET asks.
+// It's opened the first time a pkt-line is read or written.
+var packetTrace struct {
+	sync.Mutex
+	w      io.Writer
+	opened bool
+}
+
+// isBinary reports whether a payload is data rather than text, such as
+// part of a pack. Text may hold a NUL, which separates capabilities from
+// the first ref.
+func isBinary(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("PACK")) || !utf8.Valid(data) {
+		return true
+	}
+
+	for _, c := range data {
+		if (c < 0x20 && c != 0 && c != '\t' && c != '\n' && c != '\r') || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// packetPayload makes a payload printable. A side-band number is written
+// escaped ahead of its data, and binary data is summarized.
+func packetPayload(payload []byte) string {
+	var band string
+	data := payload
+	if len(payload) > 0 && payload[0] >= 1 && payload[0] <= 3 {
+		band = fmt.Sprintf("\\%d", payload[0])
+		data = payload[1:]
+	}
+
+	if isBinary(data) {
+		return fmt.Sprintf("%s<binary data, %d bytes>", band, len(data))
+	}
+
+	var buf strings.Builder
+	buf.WriteString(band)
+	for _, c := range bytes.TrimSuffix(data, []byte("\n")) {
+		if c >= 0x20 && c != 0x7f {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "\\%o", c)
+		}
+	}
+	return buf.String()
+}
+
+// tracePacket is the hook every pkt-line read (<) or written (>) passes
+// through.
+func tracePacket(direction string, kind PacketType, payload []byte) {
+	packetTrace.Lock()
+	defer packetTrace.Unlock()
+
+	if !packetTrace.opened {
+		packetTrace.w = trace2.Open("GIT_TRACE_PACKET", os.Getenv("GIT_TRACE_PACKET"))
+		packetTrace.opened = true
+	}
+
+	if packetTrace.w == nil {
+		return
+	}
+
+	var line string
+	switch kind {
+	case PacketFlush:
+		line = string(flushPkt)
+	case PacketDelim:
+		line = string(delimPkt)
+	case PacketResponseEnd:
+		line = string(responseEndPkt)
+	default:
+		line = packetPayload(payload)
+	}
+
+	fmt.Fprintf(packetTrace.w, "%s packet: %s %s\n", time.Now().Format("15:04:05.000000"), direction, line)
+}
@@ -13,44 +13,204 @@ import (
 // follows this convention. Accordingly, it's not flush-packet it's flush-pkt
 // etc...
 
-var flushPkt = []byte{0, 0, 0, 0}
+var flushPkt = []byte("0000")
 
-func readPktLine(r io.Reader) ([]byte, error) {
-	length := make([]byte, 4)
-	_, err := r.Read(length)
+// delimPkt separates sections of a protocol v2 message, and responseEndPkt
+// ends a stateless protocol v2 response.
+var (
+	delimPkt       = []byte("0001")
+	responseEndPkt = []byte("0002")
+)
+
+// MaxPktLineLength is the longest pkt-line git will send or accept,
+// including the four bytes of its length.
+const MaxPktLineLength = 65520
+
+// MaxPktLinePayload is the most data a single pkt-line can carry.
+const MaxPktLinePayload = MaxPktLineLength - 4
+
+type PacketType int
+
+const (
+	PacketData PacketType = iota
+	PacketFlush
+	PacketDelim
+	PacketResponseEnd
+)
+
+func (kind PacketType) String() string {
+	switch kind {
+	case PacketData:
+		return "data"
+	case PacketFlush:
+		return "flush-pkt"
+	case PacketDelim:
+		return "delim-pkt"
+	case PacketResponseEnd:
+		return "response-end-pkt"
+	}
+	return fmt.Sprintf("PacketType(%d)", int(kind))
+}
+
+// PktLineReader reads pkt-lines from a stream. It reads exactly as much as
+// each pkt-line needs, so the stream can be handed on afterwards, such as
+// to read a pack.
+type PktLineReader struct {
+	r      io.Reader
+	length [4]byte
+}
+
+func NewPktLineReader(r io.Reader) *PktLineReader {
+	return &PktLineReader{r: r}
+}
+
+// ReadPacket reads the next pkt-line, returning its payload exactly as it
+// was sent; this matters for binary data like side-band packets. Special
+// packets have no payload.
+func (pr *PktLineReader) ReadPacket() (PacketType, []byte, error) {
+	_, err := io.ReadFull(pr.r, pr.length[:])
 	if err != nil {
-		return nil, err
+		return PacketData, nil, err
 	}
 
-	lineLength, err := strconv.ParseInt(string(length), 16, 16)
+	lineLength, err := strconv.ParseUint(string(pr.length[:]), 16, 16)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse line length")
+		return PacketData, nil, errors.Wrap(err, "parse line length")
 	}
 
-	if lineLength < 5 {
-		if lineLength == 0 {
-			return nil, nil // nil signifies a flush-pkt
-		}
-		return nil, errors.New("empty pkt-line sent")
+	switch {
+	case lineLength == 0:
+		tracePacket("<", PacketFlush, nil)
+		return PacketFlush, nil, nil
+	case lineLength == 1:
+		tracePacket("<", PacketDelim, nil)
+		return PacketDelim, nil, nil
+	case lineLength == 2:
+		tracePacket("<", PacketResponseEnd, nil)
+		return PacketResponseEnd, nil, nil
+	case lineLength < 5:
+		return PacketData, nil, errors.New("empty pkt-line sent")
+	case lineLength > MaxPktLineLength:
+		return PacketData, nil, fmt.Errorf("pkt-line is %d bytes long; the limit is %d", lineLength, MaxPktLineLength)
 	}
 	lineLength -= 4
 
 	buf := make([]byte, lineLength)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(pr.r, buf)
 	if err != nil {
-		return nil, errors.Wrap(err, "read pkt-line")
+		if err == io.ErrUnexpectedEOF {
+			return PacketData, nil, fmt.Errorf("read pkt-line too small; have %d, want %d", n, lineLength)
+		}
+		return PacketData, nil, errors.Wrap(err, "read pkt-line")
 	}
 
-	if n != int(lineLength) {
-		return nil, fmt.Errorf("read pkt-line too small; have %d, want %d", n, lineLength)
+	tracePacket("<", PacketData, buf)
+	return PacketData, buf, nil
+}
+
+// ReadPayload returns the payload of the next pkt-line, or nil for a
+// flush-pkt. Any other special packet is an error.
+func (pr *PktLineReader) ReadPayload() ([]byte, error) {
+	kind, buf, err := pr.ReadPacket()
+	if err != nil {
+		return nil, err
 	}
 
-	return bytes.TrimSpace(buf), nil
+	if kind != PacketData && kind != PacketFlush {
+		return nil, fmt.Errorf("unexpected %s", kind)
+	}
+
+	return buf, nil // nil signifies a flush-pkt
+}
+
+// ReadLine reads a text pkt-line, dropping the newline it should end with.
+// It returns nil for a flush-pkt.
+func (pr *PktLineReader) ReadLine() ([]byte, error) {
+	buf, err := pr.ReadPayload()
+	if err != nil || buf == nil {
+		return buf, err
+	}
+
+	return bytes.TrimSuffix(buf, []byte("\n")), nil
+}
+
+// PktLineWriter writes pkt-lines to a stream.
+type PktLineWriter struct {
+	w io.Writer
 }
 
+func NewPktLineWriter(w io.Writer) *PktLineWriter {
+	return &PktLineWriter{w: w}
+}
+
+// WritePacket writes a payload as a single pkt-line, as it is.
+func (pw *PktLineWriter) WritePacket(payload []byte) error {
+	if len(payload) == 0 {
+		return errors.New("can't send an empty pkt-line")
+	}
+
+	if len(payload) > MaxPktLinePayload {
+		return fmt.Errorf("pkt-line payload is %d bytes long; the limit is %d", len(payload), MaxPktLinePayload)
+	}
+
+	tracePacket(">", PacketData, payload)
+	_, err := pw.w.Write(writePktLine(payload))
+	return err
+}
+
+// WriteLine writes a line of text, adding a newline.
+func (pw *PktLineWriter) WriteLine(line string) error {
+	return pw.WritePacket([]byte(line + "\n"))
+}
+
+func (pw *PktLineWriter) Flush() error {
+	tracePacket(">", PacketFlush, nil)
+	_, err := pw.w.Write(flushPkt)
+	return err
+}
+
+func (pw *PktLineWriter) Delim() error {
+	tracePacket(">", PacketDelim, nil)
+	_, err := pw.w.Write(delimPkt)
+	return err
+}
+
+func (pw *PktLineWriter) ResponseEnd() error {
+	tracePacket(">", PacketResponseEnd, nil)
+	_, err := pw.w.Write(responseEndPkt)
+	return err
+}
+
+func readPacket(r io.Reader) (PacketType, []byte, error) {
+	return NewPktLineReader(r).ReadPacket()
+}
+
+func readPktLinePayload(r io.Reader) ([]byte, error) {
+	return NewPktLineReader(r).ReadPayload()
+}
+
+func readPktLine(r io.Reader) ([]byte, error) {
+	return NewPktLineReader(r).ReadLine()
+}
+
+func writeLine(w io.Writer, line string) error {
+	return NewPktLineWriter(w).WriteLine(line)
+}
+
+func writeFlush(w io.Writer) error {
+	return NewPktLineWriter(w).Flush()
+}
+
+// writePacketLineString frames a line as a pkt-line without writing it,
+// so it isn't traced; pkt-lines that are sent go through a PktLineWriter.
 func writePacketLineString(line string) []byte {
 	length := len(line)
 	length += 5
 
 	return []byte(fmt.Sprintf("%04x%s\n", length, line))
 }
+
+// writePktLine frames a payload as a pkt-line without adding a newline.
+func writePktLine(payload []byte) []byte {
+	return append([]byte(fmt.Sprintf("%04x", len(payload)+4)), payload...)
+}
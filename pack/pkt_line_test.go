@@ -2,8 +2,11 @@ package pack
 
 import (
 	"bytes"
+	"io"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 const testPackFile = "testdata/packfile"
@@ -39,3 +42,134 @@ func TestWritePktLineString(t *testing.T) {
 		t.Fatalf("invalid first line\n\texpected: '%s'\n\t    have: '%s'\n", expectedPktLine, pktLine)
 	}
 }
+
+func TestPktLineReader(t *testing.T) {
+	stream := &bytes.Buffer{}
+	pw := NewPktLineWriter(stream)
+	binary := []byte{sideBandData, 0, '\n', ' ', 0xff, '\n'}
+	for _, err := range []error{
+		pw.WriteLine("command=ls-refs"),
+		pw.Delim(),
+		pw.WritePacket(binary),
+		pw.WriteLine("  indented  "),
+		pw.Flush(),
+		pw.ResponseEnd(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []struct {
+		kind    PacketType
+		payload string
+	}{
+		{PacketData, "command=ls-refs\n"},
+		{PacketDelim, ""},
+		{PacketData, string(binary)},
+		{PacketData, "  indented  \n"},
+		{PacketFlush, ""},
+		{PacketResponseEnd, ""},
+	}
+
+	// Read a byte at a time, as a slow network connection might.
+	pr := NewPktLineReader(iotest.OneByteReader(stream))
+	for _, packet := range expected {
+		kind, payload, err := pr.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if kind != packet.kind || string(payload) != packet.payload {
+			t.Fatalf("expected %s %q, have %s %q", packet.kind, packet.payload, kind, payload)
+		}
+	}
+
+	if _, _, err := pr.ReadPacket(); err != io.EOF {
+		t.Fatalf("expected EOF, have %v", err)
+	}
+}
+
+func TestPktLineLimits(t *testing.T) {
+	pw := NewPktLineWriter(io.Discard)
+	if err := pw.WritePacket(make([]byte, MaxPktLinePayload)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pw.WritePacket(make([]byte, MaxPktLinePayload+1)); err == nil {
+		t.Fatal("a payload over the limit should be refused")
+	}
+
+	if err := pw.WritePacket(nil); err == nil {
+		t.Fatal("an empty payload should be refused")
+	}
+
+	pr := NewPktLineReader(bytes.NewBufferString("fff1" + strings.Repeat("x", 0xfff1-4)))
+	if _, _, err := pr.ReadPacket(); err == nil {
+		t.Fatal("a pkt-line over the limit should be refused")
+	}
+
+	pr = NewPktLineReader(bytes.NewBufferString("0004"))
+	if _, err := pr.ReadLine(); err == nil {
+		t.Fatal("an empty pkt-line should be refused")
+	}
+
+	pr = NewPktLineReader(bytes.NewBufferString("0001"))
+	if _, err := pr.ReadLine(); err == nil {
+		t.Fatal("a delim-pkt isn't a line")
+	}
+}
+
+func TestPacketTrace(t *testing.T) {
+	var trace bytes.Buffer
+	packetTrace.Lock()
+	saved, savedOpened := packetTrace.w, packetTrace.opened
+	packetTrace.w, packetTrace.opened = &trace, true
+	packetTrace.Unlock()
+	defer func() {
+		packetTrace.Lock()
+		packetTrace.w, packetTrace.opened = saved, savedOpened
+		packetTrace.Unlock()
+	}()
+
+	var stream bytes.Buffer
+	pw := NewPktLineWriter(&stream)
+	pw.WriteLine(testHeadID + " HEAD\x00side-band-64k")
+	pw.Delim()
+	pw.WritePacket(append([]byte{sideBandData}, "PACK\x00\x00\x00\x02\x00\x00\x00\x03"...))
+	pw.WritePacket([]byte("\x02Counting objects: 3\r"))
+	pw.Flush()
+
+	pr := NewPktLineReader(&stream)
+	for i := 0; i < 5; i++ {
+		_, _, err := pr.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payloads := []string{
+		testHeadID + ` HEAD\0side-band-64k`,
+		"0001",
+		`\1<binary data, 12 bytes>`,
+		`\2Counting objects: 3\15`,
+		"0000",
+	}
+
+	lines := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n")
+	if len(lines) != 2*len(payloads) {
+		t.Fatalf("expected %d trace lines, have %d:\n%s", 2*len(payloads), len(lines), trace.String())
+	}
+
+	for i, line := range lines {
+		direction := ">"
+		if i >= len(payloads) {
+			direction = "<"
+		}
+
+		expected := "packet: " + direction + " " + payloads[i%len(payloads)]
+		if _, traced, _ := strings.Cut(line, " "); traced != expected {
+			t.Fatalf("expected trace line %q, have %q", expected, traced)
+		}
+	}
+}
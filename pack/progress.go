@@ -0,0 +1,85 @@
+package pack
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is how often a meter redraws when its percentage
+// hasn't changed, so that the byte count and rate keep moving.
+const progressInterval = time.Second
+
+// progressMeter draws a counter in place in the style of git's, such as
+// "Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s". A nil meter
+// draws nothing.
+type progressMeter struct {
+	w       io.Writer
+	title   string
+	total   uint32
+	start   time.Time
+	last    time.Time
+	percent int
+}
+
+func newProgressMeter(w io.Writer, title string, total uint32) *progressMeter {
+	if w == nil || total == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	return &progressMeter{w: w, title: title, total: total, start: now, last: now, percent: -1}
+}
+
+// humanBytes formats a byte count the way git's progress output does.
+func humanBytes(n float64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", n/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", n/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", n/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", int64(n))
+}
+
+func (pm *progressMeter) draw(count uint32, bytes uint64, end string) {
+	line := fmt.Sprintf("%s: %3d%% (%d/%d)", pm.title, pm.percent, count, pm.total)
+	if bytes > 0 {
+		line += ", " + humanBytes(float64(bytes))
+		if elapsed := time.Since(pm.start).Seconds(); elapsed > 0 {
+			line += " | " + humanBytes(float64(bytes)/elapsed) + "/s"
+		}
+	}
+
+	fmt.Fprintf(pm.w, "%s%s", line, end)
+}
+
+// update redraws the meter if there's something new to show. Pass zero
+// bytes to leave the byte count out.
+func (pm *progressMeter) update(count uint32, bytes uint64) {
+	if pm == nil {
+		return
+	}
+
+	percent := int(uint64(count) * 100 / uint64(pm.total))
+	now := time.Now()
+	if percent == pm.percent && now.Sub(pm.last) < progressInterval {
+		return
+	}
+
+	pm.percent = percent
+	pm.last = now
+	pm.draw(count, bytes, "\r")
+}
+
+// done draws the meter a final time.
+func (pm *progressMeter) done(count uint32, bytes uint64) {
+	if pm == nil {
+		return
+	}
+
+	pm.percent = int(uint64(count) * 100 / uint64(pm.total))
+	pm.draw(count, bytes, ", done.\n")
+}
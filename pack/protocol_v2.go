@@ -0,0 +1,317 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+const (
+	ProtocolV0 = 0
+	ProtocolV2 = 2
+)
+
+const protocolHeader = "Git-Protocol"
+
+// readV2Capabilities reads the capability advertisement that follows the
+// "version 2" line.
+func (ra *ReferenceAdvertisement) readV2Capabilities(r io.Reader) error {
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading capability advertisement")
+		}
+
+		if len(line) == 0 {
+			return nil
+		}
+
+		ra.Capabilities = append(ra.Capabilities, ParseCapability(string(line)))
+	}
+}
+
+// writeV2Command writes a protocol v2 command request: the command and its
+// capabilities, then its arguments.
+func writeV2Command(w io.Writer, ra *ReferenceAdvertisement, command string, args []string) error {
+	buf := &bytes.Buffer{}
+	pw := NewPktLineWriter(buf)
+	pw.WriteLine("command=" + command)
+	if ra.HasCapability("agent") {
+		pw.WriteLine("agent=" + Agent)
+	}
+
+	if ra.HasCapability("object-format") {
+		pw.WriteLine("object-format=" + ra.Capabilities.ObjectFormat())
+	}
+
+	pw.Delim()
+	for _, arg := range args {
+		pw.WriteLine(arg)
+	}
+	pw.Flush()
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// parseLsRefsLine parses "<id> <name> [symref-target:<target>]
+// [peeled:<id>]", returning the ref and, for annotated tags, its peeled
+// counterpart.
+func parseLsRefsLine(line []byte) (*Reference, *Reference, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return nil, nil, fmt.Errorf("invalid ls-refs line %q", line)
+	}
+
+	ref := &Reference{ID: fields[0], Name: fields[1]}
+	var peeled *Reference
+	for _, attribute := range fields[2:] {
+		switch {
+		case strings.HasPrefix(attribute, "symref-target:"):
+			ref.Target = strings.TrimPrefix(attribute, "symref-target:")
+		case strings.HasPrefix(attribute, "peeled:"):
+			peeled = &Reference{
+				ID:   strings.TrimPrefix(attribute, "peeled:"),
+				Name: ref.Name + peeledSuffix,
+			}
+		}
+	}
+
+	err := checkReference(ref)
+	if err == nil && peeled != nil {
+		err = checkReference(peeled)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ref, peeled, nil
+}
+
+func (ra *ReferenceAdvertisement) readLsRefs(r io.Reader) error {
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading ls-refs response")
+		}
+
+		if len(line) == 0 {
+			return nil
+		}
+
+		ref, peeled, err := parseLsRefsLine(line)
+		if err != nil {
+			return err
+		}
+
+		ra.References = append(ra.References, ref)
+		if peeled != nil {
+			ra.References = append(ra.References, peeled)
+		}
+	}
+}
+
+// ListRefs lists the remote's references. Protocol v2 is used if the server
+// supports it, in which case only refs starting with one of the prefixes
+// are listed; otherwise, this is the protocol v0 reference advertisement.
+func ListRefs(s Session, prefixes ...string) (*ReferenceAdvertisement, error) {
+	ra, err := ReadAdvertisement(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if ra.Version != ProtocolV2 {
+		log.Debugln("server doesn't support protocol v2")
+		return ra, nil
+	}
+
+	if !ra.HasCapability("ls-refs") {
+		return nil, errors.New("server doesn't support the ls-refs command")
+	}
+
+	args := []string{"symrefs", "peel"}
+	for _, prefix := range prefixes {
+		args = append(args, "ref-prefix "+prefix)
+	}
+
+	body, err := postV2Command(s, ra, "ls-refs", args)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	err = ra.readLsRefs(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return ra, nil
+}
+
+// skipV2Section reads the lines of a response section, returning true if
+// it was the last section.
+func skipV2Section(r io.Reader, name string) (bool, error) {
+	for {
+		kind, line, err := readPacket(r)
+		if err != nil {
+			return false, errors.Wrap(err, "reading "+name+" section")
+		}
+
+		switch kind {
+		case PacketDelim:
+			return false, nil
+		case PacketFlush, PacketResponseEnd:
+			return true, nil
+		}
+
+		log.Debugf("%s: %s", name, bytes.TrimSpace(line))
+	}
+}
+
+// readV2Acks reads the acknowledgments section of a fetch response,
+// returning true if the server is ready to send the pack, in which case
+// the rest of the response follows.
+func (n *negotiator) readV2Acks(r io.Reader) (bool, error) {
+	line, err := readPktLine(r)
+	if err != nil {
+		return false, errors.Wrap(err, "reading fetch response")
+	}
+
+	if string(line) != "acknowledgments" {
+		return false, fmt.Errorf("expected acknowledgments, have %q", line)
+	}
+
+	ready := false
+	for {
+		kind, line, err := readPacket(r)
+		if err != nil {
+			return false, errors.Wrap(err, "reading acknowledgments")
+		}
+
+		switch kind {
+		case PacketDelim:
+			return ready, nil
+		case PacketFlush, PacketResponseEnd:
+			return false, nil
+		}
+
+		fields := strings.Fields(string(line))
+		switch {
+		case len(fields) == 1 && fields[0] == "NAK":
+		case len(fields) == 1 && fields[0] == "ready":
+			ready = true
+		case len(fields) == 2 && fields[0] == "ACK":
+			n.markCommon(fields[1])
+		default:
+			return false, fmt.Errorf("unexpected acknowledgment %q", line)
+		}
+	}
+}
+
+// readV2FetchResponse reads the sections of a fetch response, such as
+// shallow-info, up to the packfile.
+func readV2FetchResponse(r io.Reader, packDir string, update *shallowUpdate, opts *FetchOptions) (string, error) {
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return "", errors.Wrap(err, "reading fetch response")
+		}
+
+		section := string(line)
+		if section == "packfile" {
+			return readSideBandPack(r, packDir, opts)
+		}
+
+		if section == "" {
+			return "", errors.New("fetch response has no packfile")
+		}
+
+		if section == "shallow-info" {
+			err = update.readV2ShallowInfo(r)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		last, err := skipV2Section(r, section)
+		if err != nil {
+			return "", err
+		}
+
+		if last {
+			return "", errors.New("fetch response has no packfile")
+		}
+	}
+}
+
+func postV2Command(s Session, ra *ReferenceAdvertisement, command string, args []string) (io.ReadCloser, error) {
+	request := &bytes.Buffer{}
+	err := writeV2Command(request, ra, command, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Request(request)
+}
+
+func haveArgs(ids []string) []string {
+	var args []string
+	for _, id := range ids {
+		args = append(args, "have "+id)
+	}
+	return args
+}
+
+// fetchPackV2 negotiates with fetch commands until the server is ready or
+// there's nothing left to offer, then asks for the pack. Protocol v2 is
+// stateless whatever the transport, so each request repeats everything
+// found in common so far.
+func fetchPackV2(s Session, ra *ReferenceAdvertisement, req *fetchRequest, n *negotiator, packDir string, opts *FetchOptions) (string, error) {
+	base := []string{"ofs-delta"}
+	if opts.Progress == nil {
+		base = append(base, "no-progress")
+	}
+
+	for _, id := range req.wants {
+		base = append(base, "want "+id)
+	}
+	base = append(base, req.args()...)
+
+	for !n.done() {
+		batch := n.haves()
+		if len(batch) == 0 {
+			break
+		}
+
+		args := append(append([]string{}, base...), haveArgs(n.common)...)
+		body, err := postV2Command(s, ra, "fetch", append(args, haveArgs(batch)...))
+		if err != nil {
+			return "", err
+		}
+
+		ready, err := n.readV2Acks(body)
+		if err == nil && ready {
+			defer body.Close()
+			return readV2FetchResponse(body, packDir, req.update, opts)
+		}
+
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+		log.Debugf("negotiation: %d commits in common", len(n.common))
+	}
+
+	args := append(append([]string{}, base...), haveArgs(n.common)...)
+	body, err := postV2Command(s, ra, "fetch", append(args, "done"))
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	return readV2FetchResponse(body, packDir, req.update, opts)
+}
@@ -0,0 +1,113 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testHeadID = "5360bfb1a8f0e8fcfba4f9d9e4ef2cf1e1b4c9d5"
+
+// v2TestServer answers protocol v2 requests with canned responses, failing
+// the test if the client doesn't ask for v2.
+func v2TestServer(t *testing.T, pack []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(protocolHeader) != "version=2" {
+			t.Errorf("request for %s didn't ask for protocol v2", r.URL.Path)
+		}
+
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", advertisementContentType(ServiceUploadPack))
+			for _, line := range []string{"version 2", "agent=git/2.39.5", "ls-refs=unborn", "fetch=shallow", "object-format=sha1"} {
+				w.Write(writePacketLineString(line))
+			}
+			w.Write(flushPkt)
+			return
+		}
+
+		request, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Header().Set("Content-Type", resultContentType(ServiceUploadPack))
+		switch {
+		case bytes.Contains(request, []byte("command=ls-refs")):
+			if !bytes.Contains(request, []byte("ref-prefix refs/heads/\n")) {
+				t.Errorf("ls-refs request is missing its ref-prefix: %q", request)
+			}
+
+			w.Write(writePacketLineString(testHeadID + " HEAD symref-target:refs/heads/main"))
+			w.Write(writePacketLineString(testHeadID + " refs/heads/main"))
+			w.Write(flushPkt)
+		case bytes.Contains(request, []byte("command=fetch")):
+			if !bytes.Contains(request, []byte("want "+testHeadID+"\n")) {
+				t.Errorf("fetch request is missing its want: %q", request)
+			}
+
+			w.Write(writePacketLineString("shallow-info"))
+			w.Write(writePacketLineString("shallow " + testHeadID))
+			w.Write(delimPkt)
+			w.Write(writePacketLineString("packfile"))
+			w.Write(sideBandPacket(sideBandProgress, "Enumerating objects: done.\n"))
+			for len(pack) > 0 {
+				n := min(len(pack), 1000)
+				w.Write(sideBandPacket(sideBandData, string(pack[:n])))
+				pack = pack[n:]
+			}
+			w.Write(flushPkt)
+		default:
+			t.Errorf("unexpected request %q", request)
+		}
+	}))
+}
+
+func TestProtocolV2(t *testing.T) {
+	pack, err := os.ReadFile("testdata/pack-ref.pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := v2TestServer(t, pack)
+	defer srv.Close()
+
+	session := testSession(t, srv.URL+"/repo.git", ServiceUploadPack, ProtocolV2)
+	ra, err := ListRefs(session, "HEAD", "refs/heads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ra.Version != ProtocolV2 {
+		t.Fatalf("expected protocol v2, have v%d", ra.Version)
+	}
+
+	if len(ra.References) != 2 {
+		t.Fatalf("expected 2 references, have %d", len(ra.References))
+	}
+
+	if target := ra.SymbolicRef("HEAD"); target != "refs/heads/main" {
+		t.Fatalf("expected HEAD to point to refs/heads/main, have %q", target)
+	}
+
+	progress := &strings.Builder{}
+	checksum, err := FetchPack(session, ra, objects.NewDirStore(t.TempDir()), &FetchOptions{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := fmt.Sprintf("%x", pack[len(pack)-20:]); checksum != expected {
+		t.Fatalf("unexpected pack checksum %s, expected %s", checksum, expected)
+	}
+
+	// The remote's progress is followed by the objects received.
+	if !strings.HasPrefix(progress.String(), "remote: Enumerating objects: done.\n") ||
+		!strings.Contains(progress.String(), "Receiving objects: 100% (7/7)") {
+		t.Fatalf("unexpected progress %q", progress.String())
+	}
+}
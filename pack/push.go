@@ -0,0 +1,221 @@
+package pack
+
+import (
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// ZeroID stands in for the old ID of a ref being created, or the new ID of
+// a ref being deleted.
+const ZeroID = "0000000000000000000000000000000000000000"
+
+// RefUpdate asks the remote to move a ref from one object to another.
+type RefUpdate struct {
+	Name  string
+	OldID string
+	NewID string
+}
+
+func (update *RefUpdate) IsDelete() bool {
+	return update.NewID == ZeroID
+}
+
+// PushOptions controls how a pack is pushed.
+type PushOptions struct {
+	// Progress receives the remote's progress messages. If it's nil,
+	// the remote is asked not to send any.
+	Progress io.Writer
+
+	// Atomic asks the remote to apply all of the updates or none of
+	// them.
+	Atomic bool
+}
+
+// RefStatus is the remote's verdict on one ref update. Error is empty if
+// the ref was updated.
+type RefStatus struct {
+	Name  string
+	Error string
+}
+
+// PushReport is the remote's report-status reply.
+type PushReport struct {
+	// UnpackError is empty if the remote unpacked the pack.
+	UnpackError string
+	Refs        []*RefStatus
+}
+
+func (report *PushReport) Status(name string) *RefStatus {
+	for _, status := range report.Refs {
+		if status.Name == name {
+			return status
+		}
+	}
+	return nil
+}
+
+func pushCapabilities(ra *ReferenceAdvertisement, opts *PushOptions) (Capabilities, error) {
+	ours := Capabilities{{Name: "report-status"}, {Name: "side-band-64k"}, {Name: "ofs-delta"}}
+	if opts.Atomic {
+		if !ra.HasCapability("atomic") {
+			return nil, errors.New("the receiving end does not support atomic pushes")
+		}
+		ours = append(ours, Capability{Name: "atomic"})
+	}
+
+	if opts.Progress == nil {
+		ours = append(ours, Capability{Name: "quiet"})
+	}
+
+	return ra.Capabilities.Intersect(append(ours, Capability{Name: "agent", Value: Agent})), nil
+}
+
+func writeRefUpdates(w io.Writer, updates []*RefUpdate, capabilities Capabilities) error {
+	for i, update := range updates {
+		line := update.OldID + " " + update.NewID + " " + update.Name
+		if i == 0 {
+			line += "\x00" + capabilities.String()
+		}
+
+		err := writeLine(w, line)
+		if err != nil {
+			return errors.Wrap(err, "writing ref updates")
+		}
+	}
+
+	return writeFlush(w)
+}
+
+// pushObjects lists the objects the remote needs for the updates, leaving
+// out anything reachable from refs it already has.
+func pushObjects(ra *ReferenceAdvertisement, store *objects.DirStore, updates []*RefUpdate) ([]RevObject, error) {
+	var include, exclude []string
+	for _, update := range updates {
+		if !update.IsDelete() {
+			include = append(include, update.NewID)
+		}
+	}
+
+	for _, ref := range ra.References {
+		if !ref.IsPeeled() && ref.ID != ZeroID && store.Has(ref.ID) {
+			exclude = append(exclude, ref.ID)
+		}
+	}
+
+	return RevList(store, include, exclude)
+}
+
+func readReportStatus(r io.Reader) (*PushReport, error) {
+	line, err := readPktLine(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading report-status")
+	}
+
+	unpack, ok := strings.CutPrefix(string(line), "unpack ")
+	if !ok {
+		return nil, fmt.Errorf("invalid report-status line %q", line)
+	}
+
+	report := &PushReport{}
+	if unpack != "ok" {
+		report.UnpackError = unpack
+	}
+
+	for {
+		line, err = readPktLine(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading report-status")
+		}
+
+		if len(line) == 0 {
+			return report, nil
+		}
+
+		status, rest, _ := strings.Cut(string(line), " ")
+		switch status {
+		case "ok":
+			report.Refs = append(report.Refs, &RefStatus{Name: rest})
+		case "ng":
+			name, reason, _ := strings.Cut(rest, " ")
+			report.Refs = append(report.Refs, &RefStatus{Name: name, Error: reason})
+		default:
+			return nil, fmt.Errorf("invalid report-status line %q", line)
+		}
+	}
+}
+
+// SendPack sends ref updates to the remote's receive-pack service, along
+// with a pack of the objects it's missing. If the remote doesn't support
+// report-status, every update is assumed to have succeeded.
+func SendPack(s Session, ra *ReferenceAdvertisement, store *objects.DirStore, updates []*RefUpdate, opts *PushOptions) (*PushReport, error) {
+	if opts == nil {
+		opts = &PushOptions{}
+	}
+
+	capabilities, err := pushCapabilities(ra, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	needPack := false
+	for _, update := range updates {
+		if update.IsDelete() {
+			if !ra.HasCapability("delete-refs") {
+				return nil, errors.New("the receiving end does not support deleting refs")
+			}
+			continue
+		}
+		needPack = true
+	}
+
+	var revObjects []RevObject
+	if needPack {
+		revObjects, err = pushObjects(ra, store, updates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Stream the commands and the pack into the request as it's
+	// written, rather than building it in memory.
+	pr, pw := io.Pipe()
+	go func() {
+		err := writeRefUpdates(pw, updates, capabilities)
+		if err == nil && needPack {
+			_, err = WritePack(pw, store, revObjects, nil)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	body, err := s.Request(pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	defer body.Close()
+
+	report := &PushReport{}
+	if !capabilities.Has("report-status") {
+		for _, update := range updates {
+			report.Refs = append(report.Refs, &RefStatus{Name: update.Name})
+		}
+		return report, nil
+	}
+
+	var r io.Reader = body
+	if capabilities.Has("side-band-64k") {
+		r = NewSideBandReader(body, opts.Progress)
+	}
+
+	report, err = readReportStatus(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drain any remaining progress.
+	_, err = io.Copy(io.Discard, r)
+	return report, errors.Wrap(err, "reading receive-pack response")
+}
@@ -0,0 +1,213 @@
+package pack
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadReportStatus(t *testing.T) {
+	response := &bytes.Buffer{}
+	for _, line := range []string{"unpack ok", "ok refs/heads/main", "ng refs/heads/topic pre-receive hook declined"} {
+		response.Write(writePacketLineString(line))
+	}
+	response.Write(flushPkt)
+
+	stream := &bytes.Buffer{}
+	stream.Write(sideBandPacket(sideBandProgress, "Resolving deltas: done.\n"))
+	stream.Write(sideBandPacket(sideBandData, response.String()))
+	stream.Write(flushPkt)
+
+	report, err := readReportStatus(NewSideBandReader(stream, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.UnpackError != "" {
+		t.Fatalf("unexpected unpack error %q", report.UnpackError)
+	}
+
+	if status := report.Status("refs/heads/main"); status == nil || status.Error != "" {
+		t.Fatalf("expected refs/heads/main to be updated, have %#v", status)
+	}
+
+	status := report.Status("refs/heads/topic")
+	if status == nil || status.Error != "pre-receive hook declined" {
+		t.Fatalf("expected refs/heads/topic to be rejected, have %#v", status)
+	}
+}
+
+func TestHTTPPush(t *testing.T) {
+	srcDir, commits := newTestRepository(t, 10)
+	src := objects.NewDirStore(filepath.Join(srcDir, "objects"))
+	dstDir := newBareRepository(t)
+
+	// Keep the body of each push to check what was sent.
+	var requests [][]byte
+	handler := NewHTTPHandler(filepath.Dir(dstDir))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/"+ServiceReceivePack) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			requests = append(requests, body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	url := srv.URL + "/repo.git"
+	session, err := Connect(context.Background(), url, ServiceReceivePack, nil)
+	if err == nil {
+		_, err = ReadAdvertisement(session)
+		session.Close()
+	}
+	if err == nil {
+		t.Fatal("expected pushing to be refused without http.receivepack")
+	}
+
+	cfg := "[http]\n\treceivepack = true\n[receive]\n\tdenyNonFastForwards = true\n"
+	err = os.WriteFile(filepath.Join(dstDir, "config"), []byte(cfg), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push := func(update *RefUpdate) *RefStatus {
+		session := testSession(t, url, ServiceReceivePack, ProtocolV0)
+		ra, err := ReadAdvertisement(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		report, err := SendPack(session, ra, src, []*RefUpdate{update}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.UnpackError != "" {
+			t.Fatalf("the remote couldn't unpack: %s", report.UnpackError)
+		}
+
+		status := report.Status(update.Name)
+		if status == nil {
+			t.Fatalf("no status reported for %s", update.Name)
+		}
+		return status
+	}
+
+	checkRef := func(id string) {
+		if current, err := paths.ReadRef(dstDir, "refs/heads/main"); err != nil || current != id {
+			t.Fatalf("expected refs/heads/main to be %s, have %s (%v)", id, current, err)
+		}
+	}
+
+	if status := push(&RefUpdate{Name: "refs/heads/main", OldID: ZeroID, NewID: commits[4]}); status.Error != "" {
+		t.Fatalf("creating main failed: %s", status.Error)
+	}
+	checkRef(commits[4])
+
+	if status := push(&RefUpdate{Name: "refs/heads/main", OldID: commits[4], NewID: commits[9]}); status.Error != "" {
+		t.Fatalf("fast-forwarding main failed: %s", status.Error)
+	}
+	checkRef(commits[9])
+
+	dst := objects.NewDirStore(filepath.Join(dstDir, "objects"))
+	for _, id := range commits {
+		if !dst.Has(id) {
+			t.Fatalf("commit %s wasn't pushed", id)
+		}
+	}
+
+	// The second push sends one command and only the commits the remote
+	// didn't have, each with its tree and blob.
+	r := bytes.NewReader(requests[len(requests)-1])
+	commands, _, err := readCommands(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(commands) != 1 || commands[0].RefUpdate != (RefUpdate{Name: "refs/heads/main", OldID: commits[4], NewID: commits[9]}) {
+		t.Fatalf("unexpected commands %#v", commands)
+	}
+
+	packDir := filepath.Join(t.TempDir(), "pack")
+	err = os.MkdirAll(packDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := IndexPackToDir(r, packDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := objects.OpenPackfile(filepath.Join(packDir, "pack-"+checksum+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pack.Close()
+
+	if pack.Index.Len() != 15 {
+		t.Fatalf("expected the pack to have 15 objects, have %d", pack.Index.Len())
+	}
+
+	for i, id := range commits {
+		rawID, _ := hex.DecodeString(id)
+		if pack.Has(rawID) != (i > 4) {
+			t.Fatalf("expected commit %d to be in the pack: %v", i, i > 4)
+		}
+	}
+
+	// Moving main back would lose commits.
+	if status := push(&RefUpdate{Name: "refs/heads/main", OldID: commits[9], NewID: commits[2]}); status.Error != "non-fast-forward" {
+		t.Fatalf("expected the push to be rejected as a non-fast-forward, have %q", status.Error)
+	}
+	checkRef(commits[9])
+}
+
+func TestReceivePackFunnyRefname(t *testing.T) {
+	gitDir := newBareRepository(t)
+	victim := filepath.Join(filepath.Dir(gitDir), "victim.txt")
+	err := os.WriteFile(victim, []byte("keep me\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &bytes.Buffer{}
+	request.Write(writePacketLineString(ZeroID + " " + ZeroID + " refs/../../victim.txt\x00report-status"))
+	request.Write(writePacketLineString(ZeroID + " " + ZeroID + " refs/heads/main.lock"))
+	request.Write(flushPkt)
+
+	response := &bytes.Buffer{}
+	err = ReceivePack(gitDir, request, response, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := readReportStatus(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"refs/../../victim.txt", "refs/heads/main.lock"} {
+		if status := report.Status(name); status == nil || status.Error != "funny refname" {
+			t.Fatalf("expected %s to be rejected as a funny refname, have %#v", name, status)
+		}
+	}
+
+	contents, err := os.ReadFile(victim)
+	if err != nil || string(contents) != "keep me\n" {
+		t.Fatalf("victim file changed: %q, %v", contents, err)
+	}
+}
@@ -0,0 +1,234 @@
+package pack
+
+import (
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+var receivePackCapabilities = Capabilities{
+	{Name: "report-status"},
+	{Name: "delete-refs"},
+	{Name: "side-band-64k"},
+	{Name: "quiet"},
+	{Name: "atomic"},
+	{Name: "ofs-delta"},
+}
+
+// advertiseReceivePack lists the refs that can be pushed to, which leaves
+// out HEAD and peeled tags.
+func advertiseReceivePack(gitDir string, store objects.ObjectStore) (*ReferenceAdvertisement, error) {
+	all, err := advertiseReferences(gitDir, store, receivePackCapabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	ra := &ReferenceAdvertisement{Capabilities: all.Capabilities.Without("symref")}
+
+	for _, ref := range all.References {
+		if ref.Name != "HEAD" && !ref.IsPeeled() {
+			ra.References = append(ra.References, ref)
+		}
+	}
+
+	return ra, nil
+}
+
+type receiveCommand struct {
+	RefUpdate
+	err string
+}
+
+func readCommands(r io.Reader) ([]*receiveCommand, Capabilities, error) {
+	var commands []*receiveCommand
+	var capabilities Capabilities
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "reading commands")
+		}
+
+		if len(line) == 0 {
+			return commands, capabilities, nil
+		}
+
+		command, caps, _ := strings.Cut(string(line), "\x00")
+		capabilities = append(capabilities, ParseCapabilities(caps)...)
+
+		fields := strings.Fields(command)
+		if len(fields) != 3 || len(fields[0]) != len(ZeroID) || len(fields[1]) != len(ZeroID) {
+			return nil, nil, fmt.Errorf("invalid command %q", line)
+		}
+
+		cmd := &receiveCommand{
+			RefUpdate: RefUpdate{OldID: fields[0], NewID: fields[1], Name: fields[2]},
+		}
+		if !strings.HasPrefix(cmd.Name, "refs/") || !paths.ValidRefName(cmd.Name) {
+			cmd.err = "funny refname"
+		}
+		commands = append(commands, cmd)
+	}
+}
+
+// checkCommand works out why an update can't be applied, if it can't.
+// With denyNonFastForwards, an update has to keep the ref's current commit
+// in its history.
+func checkCommand(gitDir string, store *objects.DirStore, refs map[string]string, cmd *receiveCommand, denyNonFastForwards bool) string {
+	current, ok := refs[cmd.Name]
+	if !ok {
+		current = ZeroID
+	}
+
+	switch {
+	case !strings.HasPrefix(cmd.Name, "refs/") || !paths.ValidRefName(cmd.Name):
+		return "funny refname"
+	case current != cmd.OldID:
+		return "failed to lock"
+	case !cmd.IsDelete() && !store.Has(cmd.NewID):
+		return "missing necessary objects"
+	}
+
+	if denyNonFastForwards && current != ZeroID && !cmd.IsDelete() {
+		fastForward, err := IsAncestor(store, current, cmd.NewID)
+		if err != nil || !fastForward {
+			return "non-fast-forward"
+		}
+	}
+
+	// Moving the checked-out branch would leave the working tree
+	// behind it.
+	if filepath.Base(gitDir) == ".git" {
+		head, _ := paths.ReadSymbolicRef(gitDir, "HEAD")
+		if head == cmd.Name {
+			return "branch is currently checked out"
+		}
+	}
+
+	return ""
+}
+
+func writeReportStatus(w io.Writer, unpackErr string, commands []*receiveCommand) error {
+	unpack := "ok"
+	if unpackErr != "" {
+		unpack = unpackErr
+	}
+
+	err := writeLine(w, "unpack "+unpack)
+	for _, cmd := range commands {
+		if err != nil {
+			return err
+		}
+
+		if cmd.err == "" {
+			err = writeLine(w, "ok "+cmd.Name)
+		} else {
+			err = writeLine(w, "ng "+cmd.Name+" "+cmd.err)
+		}
+	}
+
+	if err == nil {
+		err = writeFlush(w)
+	}
+	return err
+}
+
+// ReceivePack accepts a push into the repository at gitDir: ref updates,
+// then a pack with the objects they need. Each update is checked against
+// the ref's current value before it's applied, and with the atomic
+// capability, one failure fails them all.
+func ReceivePack(gitDir string, r io.Reader, w io.Writer, stateless bool) error {
+	store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+	ra, err := advertiseReceivePack(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	if !stateless {
+		err = ra.Encode(w)
+		if err != nil {
+			return errors.Wrap(err, "writing advertisement")
+		}
+	}
+
+	commands, capabilities, err := readCommands(r)
+	if err != nil || len(commands) == 0 {
+		return err
+	}
+
+	needPack := false
+	for _, cmd := range commands {
+		needPack = needPack || !cmd.IsDelete()
+	}
+
+	unpackErr := ""
+	if needPack {
+		_, err = IndexPackToDir(r, paths.PackDir(filepath.Join(gitDir, "objects")))
+		if err != nil {
+			unpackErr = err.Error()
+		}
+	}
+
+	refs, err := paths.ListRefs(gitDir)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return err
+	}
+
+	denyNonFastForwards, err := cfg.GetBool("receive.denyNonFastForwards", false)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, cmd := range commands {
+		switch {
+		case cmd.err != "":
+		case unpackErr != "":
+			cmd.err = "unpacker error"
+		default:
+			cmd.err = checkCommand(gitDir, store, refs, cmd, denyNonFastForwards)
+		}
+		failed = failed || cmd.err != ""
+	}
+
+	for _, cmd := range commands {
+		switch {
+		case cmd.err != "":
+			continue
+		case failed && capabilities.Has("atomic"):
+			cmd.err = "atomic push failure"
+			continue
+		case cmd.IsDelete():
+			err = paths.DeleteRef(gitDir, cmd.Name)
+		default:
+			err = paths.UpdateRef(gitDir, cmd.Name, cmd.NewID)
+		}
+
+		if err != nil {
+			cmd.err = "failed to update ref"
+		}
+	}
+
+	if !capabilities.Has("report-status") {
+		return nil
+	}
+
+	if !capabilities.Has("side-band-64k") {
+		return writeReportStatus(w, unpackErr, commands)
+	}
+
+	err = writeReportStatus(&sideBandWriter{w: w, band: sideBandData, max: 65515}, unpackErr, commands)
+	if err == nil {
+		err = writeFlush(w)
+	}
+	return err
+}
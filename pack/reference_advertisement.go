@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"git.wntrmute.dev/kyle/goutils/log"
+	"github.com/kisom/codecrafters/git-go/paths"
 	"github.com/pkg/errors"
 	"io"
 	"regexp"
@@ -15,40 +16,124 @@ var referenceAdvertisementMagic = regexp.MustCompile(`^[0-9a-f]{4}#$`)
 type Reference struct {
 	ID           string
 	Name         string
-	Capabilities []string
+	Capabilities Capabilities
+
+	// Target is the ref a symbolic ref points to, as reported by a
+	// protocol v2 ls-refs.
+	Target string
+}
+
+// Want writes the pkt-line asking for the ref's object, followed by
+// capabilities if any are given, as the first want of a request carries
+// them.
+func (ref *Reference) Want(w io.Writer, capabilities Capabilities) error {
+	line := "want " + ref.ID
+	if len(capabilities) > 0 {
+		line += " " + capabilities.String()
+	}
+	return writeLine(w, line)
 }
 
-func (ref *Reference) Want() []byte {
-	return writePacketLineString("want " + ref.ID)
+// Have writes the pkt-line telling the server the ref's object is already
+// here.
+func (ref *Reference) Have(w io.Writer) error {
+	return writeLine(w, "have "+ref.ID)
 }
 
-func (ref *Reference) Have() []byte {
-	return writePacketLineString("have " + ref.ID)
+// capabilitiesRef is the name the server uses to send capabilities when it
+// has no refs to advertise.
+const capabilitiesRef = "capabilities^{}"
+
+// peeledSuffix marks a ref whose ID is the object an annotated tag
+// points to, rather than the tag itself.
+const peeledSuffix = "^{}"
+
+func (ref *Reference) IsPeeled() bool {
+	return strings.HasSuffix(ref.Name, peeledSuffix)
 }
 
 type ReferenceAdvertisement struct {
-	References []*Reference
+	// Version is the protocol version the server is speaking. Under
+	// protocol v2, the advertisement only has capabilities; the
+	// references come from ls-refs.
+	Version      int
+	References   []*Reference
+	Capabilities Capabilities
+}
+
+func (ra *ReferenceAdvertisement) HasCapability(name string) bool {
+	return ra.Capabilities.Has(name)
+}
+
+// SymbolicRef returns the target the server advertised for a symbolic ref
+// such as HEAD, or the empty string if there isn't one.
+func (ra *ReferenceAdvertisement) SymbolicRef(name string) string {
+	if target, ok := ra.Capabilities.SymbolicRefs()[name]; ok {
+		return target
+	}
+
+	if ref := ra.Lookup(name); ref != nil {
+		return ref.Target
+	}
+	return ""
+}
+
+func (ra *ReferenceAdvertisement) Lookup(name string) *Reference {
+	for _, ref := range ra.References {
+		if ref.Name == name {
+			return ref
+		}
+	}
+	return nil
 }
 
 func (ra *ReferenceAdvertisement) UnmarshalReader(r io.Reader) error {
-	advertisementLine, err := readPktLine(r)
+	line, err := readPktLine(r)
 	if err != nil {
 		return errors.Wrap(err, "reading advertisement line")
 	}
 
-	if !bytes.Equal(advertisementLine, []byte("# service=git-upload-pack")) {
-		return fmt.Errorf("advertisement line is invalid")
+	// Servers speaking protocol v2 over HTTP may leave out the banner.
+	if bytes.HasPrefix(line, []byte("# service=")) {
+		service := string(bytes.TrimPrefix(line, []byte("# service=")))
+		if service != ServiceUploadPack && service != ServiceReceivePack {
+			return fmt.Errorf("advertisement line is invalid")
+		}
+
+		log.Debugln("advertisement line is good")
+
+		// should be a flush-pkt
+		_, err = readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading advertisement line")
+		}
+
+		line, err = readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading references")
+		}
 	}
 
-	log.Debugln("advertisement line is good")
+	// A server refusing the request says why in place of the
+	// advertisement.
+	if message, ok := bytes.CutPrefix(line, []byte("ERR ")); ok {
+		return &RemoteError{Message: string(bytes.TrimSpace(message))}
+	}
 
-	// should be a flush-pkt
-	_, err = readPktLine(r)
-	if err != nil {
-		return errors.Wrap(err, "reading advertisement line")
+	if bytes.Equal(line, []byte("version 2")) {
+		ra.Version = ProtocolV2
+		return ra.readV2Capabilities(r)
 	}
 
-	err = ra.readReferences(r)
+	// An empty repository may send nothing but a flush-pkt.
+	if len(line) == 0 {
+		return nil
+	}
+
+	err = ra.addReference(line)
+	if err == nil {
+		err = ra.readReferences(r)
+	}
 	if err != nil {
 		return errors.Wrap(err, "reading references")
 	}
@@ -56,16 +141,30 @@ func (ra *ReferenceAdvertisement) UnmarshalReader(r io.Reader) error {
 	return nil
 }
 
-func (ra *ReferenceAdvertisement) Want(w io.Writer) error {
+// wantIDs lists each advertised object once.
+func (ra *ReferenceAdvertisement) wantIDs() []string {
+	var ids []string
+	seen := map[string]bool{}
 	for _, ref := range ra.References {
-		_, err := w.Write(ref.Want())
-		if err != nil {
-			return errors.Wrap(err, "writing reference advertisement")
+		if ref.IsPeeled() || seen[ref.ID] {
+			continue
 		}
+
+		seen[ref.ID] = true
+		ids = append(ids, ref.ID)
 	}
+	return ids
+}
 
-	_, err := w.Write(flushPkt)
-	return err
+// Want writes a want line for every advertised object, followed by a
+// flush-pkt. The capabilities are sent on the first line.
+func (ra *ReferenceAdvertisement) Want(w io.Writer, capabilities Capabilities) error {
+	err := writeWants(w, ra.wantIDs(), capabilities)
+	if err != nil {
+		return errors.Wrap(err, "writing reference advertisement")
+	}
+
+	return writeFlush(w)
 }
 
 func (ra *ReferenceAdvertisement) readReferences(r io.Reader) error {
@@ -79,11 +178,26 @@ func (ra *ReferenceAdvertisement) readReferences(r io.Reader) error {
 			break
 		}
 
-		ref, err := parseReferenceLine(line)
+		err = ra.addReference(line)
 		if err != nil {
-			return errors.Wrap(err, "parsing references line")
+			return err
 		}
+	}
+
+	return nil
+}
+
+func (ra *ReferenceAdvertisement) addReference(line []byte) error {
+	ref, err := parseReferenceLine(line)
+	if err != nil {
+		return errors.Wrap(err, "parsing references line")
+	}
+
+	if ref.Capabilities != nil {
+		ra.Capabilities = ref.Capabilities
+	}
 
+	if ref.Name != capabilitiesRef {
 		ra.References = append(ra.References, ref)
 	}
 
@@ -98,12 +212,38 @@ func parseReferenceLine(line []byte) (*Reference, error) {
 	}
 
 	refDescription := strings.Fields(string(fields[0]))
+	if len(refDescription) != 2 {
+		return nil, fmt.Errorf("invalid reference line %q", fields[0])
+	}
+
 	ref.ID = strings.TrimSpace(refDescription[0])
 	ref.Name = strings.TrimSpace(refDescription[1])
+	err := checkReference(ref)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(fields) > 1 {
-		ref.Capabilities = strings.Fields(string(bytes.TrimSpace(fields[1])))
+		ref.Capabilities = ParseCapabilities(string(fields[1]))
 	}
 
 	return ref, nil
 }
+
+// checkReference makes sure an advertised ref has a full object ID and a
+// name git would accept. Names come from the remote and end up as paths
+// in the repository, so anything else is refused.
+func checkReference(ref *Reference) error {
+	if !paths.IsObjectID(ref.ID) {
+		return fmt.Errorf("invalid object ID %q for ref %q", ref.ID, ref.Name)
+	}
+
+	if ref.Name != capabilitiesRef && !paths.ValidRefName(strings.TrimSuffix(ref.Name, peeledSuffix)) {
+		return fmt.Errorf("invalid ref name %q", ref.Name)
+	}
+
+	if ref.Target != "" && !paths.ValidRefName(ref.Target) {
+		return fmt.Errorf("invalid symbolic ref target %q for ref %q", ref.Target, ref.Name)
+	}
+	return nil
+}
@@ -29,3 +29,69 @@ func TestUnmarshalReference(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestReferenceAdvertisementWant(t *testing.T) {
+	testReference := bytes.NewBufferString("0068378dced80975bb1e0ccf3aac86be65f058683e6c HEAD\x00side-band-64k ofs-delta symref=HEAD:refs/heads/master\n003f378dced80975bb1e0ccf3aac86be65f058683e6c refs/heads/master\n003a9a4e3e6c8e1c05ef4b9f6e8f2a8b4c4a3d1e2f3a refs/tags/v1\n003d378dced80975bb1e0ccf3aac86be65f058683e6c refs/tags/v1^{}\n0000")
+
+	ra := &ReferenceAdvertisement{}
+	err := ra.readReferences(testReference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ra.HasCapability("ofs-delta") || ra.HasCapability("thin-pack") {
+		t.Fatalf("capabilities weren't parsed correctly: %v", ra.Capabilities)
+	}
+
+	if target := ra.SymbolicRef("HEAD"); target != "refs/heads/master" {
+		t.Fatalf("expected HEAD to point to refs/heads/master, have %q", target)
+	}
+
+	buf := &bytes.Buffer{}
+	err = ra.Want(buf, Capabilities{{Name: "ofs-delta"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "003cwant 378dced80975bb1e0ccf3aac86be65f058683e6c ofs-delta\n" +
+		"0032want 9a4e3e6c8e1c05ef4b9f6e8f2a8b4c4a3d1e2f3a\n" +
+		"0000"
+	if buf.String() != expected {
+		t.Fatalf("unexpected want request:\n%q\nexpected:\n%q", buf.String(), expected)
+	}
+
+	buf.Reset()
+	err = ra.Lookup("refs/heads/master").Have(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected = "0032have 378dced80975bb1e0ccf3aac86be65f058683e6c\n"; buf.String() != expected {
+		t.Fatalf("unexpected have line %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestParseReferenceLineInvalid(t *testing.T) {
+	id := "378dced80975bb1e0ccf3aac86be65f058683e6c"
+	for _, line := range []string{
+		id + " refs/tags/../../../../pwned",
+		id + " refs/heads/main.lock",
+		id + " refs/heads/a:b",
+		"378dced HEAD",
+		"378DCED80975BB1E0CCF3AAC86BE65F058683E6C HEAD",
+	} {
+		if _, err := parseReferenceLine([]byte(line)); err == nil {
+			t.Fatalf("expected %q to be rejected", line)
+		}
+	}
+
+	for _, line := range []string{
+		id + " HEAD",
+		id + " refs/tags/v1^{}",
+		ZeroID + " capabilities^{}",
+	} {
+		if _, err := parseReferenceLine([]byte(line)); err != nil {
+			t.Fatalf("expected %q to be accepted: %v", line, err)
+		}
+	}
+}
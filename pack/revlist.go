@@ -0,0 +1,225 @@
+package pack
+
+import (
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// RevObject is an object found while walking history, along with the path
+// it was found at (if any), which is used to group delta candidates.
+type RevObject struct {
+	ID   string
+	Name string
+}
+
+type revWalker struct {
+	store   objects.ObjectStore
+	seen    map[string]bool
+	exclude map[string]bool
+	commits []RevObject
+	objects []RevObject
+
+	// shallow commits are treated as having no parents.
+	shallow map[string]bool
+
+	// filter leaves objects out of a partial clone.
+	filter *Filter
+}
+
+func (rw *revWalker) add(obj RevObject, isCommit bool) bool {
+	if rw.seen[obj.ID] || rw.exclude[obj.ID] {
+		return false
+	}
+	rw.seen[obj.ID] = true
+
+	if isCommit {
+		rw.commits = append(rw.commits, obj)
+	} else {
+		rw.objects = append(rw.objects, obj)
+	}
+	return true
+}
+
+// walkTree adds a tree found depth levels below a root tree, and what it
+// holds.
+func (rw *revWalker) walkTree(id, name string, depth int) error {
+	if !rw.add(RevObject{ID: id, Name: name}, false) {
+		return nil
+	}
+
+	blob, err := rw.store.Read(id)
+	if err != nil {
+		return errors.Wrap(err, "reading tree "+id)
+	}
+
+	tree, err := objects.TreeFromBlob(blob)
+	if err != nil {
+		return errors.Wrap(err, "parsing tree "+id)
+	}
+
+	for _, entry := range tree.Entries() {
+		entryID := fmt.Sprintf("%x", entry.Hash)
+		entryName := entry.Name
+		if name != "" {
+			entryName = name + "/" + entry.Name
+		}
+
+		switch entry.Mode {
+		case objects.ModeSubmodule:
+			// gitlinks point into another repository.
+			continue
+		case objects.ModeDirectory:
+			if rw.filter.omitsTree(depth + 1) {
+				continue
+			}
+
+			err = rw.walkTree(entryID, entryName, depth+1)
+			if err != nil {
+				return err
+			}
+		default:
+			omit, err := rw.omitsBlob(entryID, depth+1)
+			if err != nil {
+				return err
+			}
+
+			if !omit {
+				rw.add(RevObject{ID: entryID, Name: entryName}, false)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (rw *revWalker) omitsBlob(id string, depth int) (bool, error) {
+	if !rw.filter.needsSize() || rw.seen[id] || rw.exclude[id] {
+		return rw.filter.omitsBlob(depth, 0), nil
+	}
+
+	blob, err := rw.store.Read(id)
+	if err != nil {
+		return false, errors.Wrap(err, "reading blob "+id)
+	}
+	return rw.filter.omitsBlob(depth, int64(blob.Size())), nil
+}
+
+func (rw *revWalker) walkCommits(id string) error {
+	queue := []string{id}
+	for len(queue) > 0 {
+		id, queue = queue[0], queue[1:]
+		if !rw.add(RevObject{ID: id}, true) {
+			continue
+		}
+
+		commit, err := objects.ReadCommit(rw.store, id)
+		if err != nil {
+			return errors.Wrap(err, "reading commit "+id)
+		}
+
+		if !rw.filter.omitsTree(0) {
+			err = rw.walkTree(commit.Tree, "", 0)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !rw.shallow[id] {
+			queue = append(queue, commit.Parents...)
+		}
+	}
+
+	return nil
+}
+
+func (rw *revWalker) walk(id string) error {
+	blob, err := rw.store.Read(id)
+	if err != nil {
+		return errors.Wrap(err, "reading object "+id)
+	}
+
+	switch blob.Type {
+	case objects.TypeCommit:
+		return rw.walkCommits(id)
+	case objects.TypeTree:
+		return rw.walkTree(id, "", 0)
+	case objects.TypeBlob:
+		rw.add(RevObject{ID: id}, false)
+		return nil
+	case objects.TypeTag:
+		if !rw.add(RevObject{ID: id}, false) {
+			return nil
+		}
+
+		target, err := tagTarget(blob)
+		if err != nil {
+			return err
+		}
+		return rw.walk(target)
+	}
+
+	return fmt.Errorf("object %s has unknown type %s", id, blob.Type)
+}
+
+func tagTarget(tag *objects.Blob) (string, error) {
+	for _, line := range strings.Split(string(tag.Contents), "\n") {
+		if target, ok := strings.CutPrefix(line, "object "); ok {
+			return target, nil
+		}
+
+		if line == "" {
+			break
+		}
+	}
+
+	return "", fmt.Errorf("tag %s has no object", tag.HashString())
+}
+
+// RevList returns every object reachable from include that isn't reachable
+// from exclude. Commits come first, followed by trees, blobs and tags.
+// History stops at the repository's shallow commits.
+func RevList(store *objects.DirStore, include, exclude []string) ([]RevObject, error) {
+	shallow, err := repoShallow(store.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return revList(store, include, exclude, shallow, shallow, nil)
+}
+
+// revList is RevList with separate shallow boundaries for each side, as
+// when sending more history to a shallow client than it has, and an
+// optional filter for what's included.
+func revList(store objects.ObjectStore, include, exclude []string, includeShallow, excludeShallow map[string]bool, filter *Filter) ([]RevObject, error) {
+	excluded := &revWalker{
+		store:   store,
+		seen:    map[string]bool{},
+		shallow: excludeShallow,
+	}
+
+	for _, id := range exclude {
+		err := excluded.walk(id)
+		if err != nil {
+			return nil, errors.Wrap(err, "walking excluded revisions")
+		}
+	}
+
+	rw := &revWalker{
+		store:   store,
+		seen:    map[string]bool{},
+		exclude: excluded.seen,
+		shallow: includeShallow,
+		filter:  filter,
+	}
+
+	for _, id := range include {
+		err := rw.walk(id)
+		if err != nil {
+			return nil, errors.Wrap(err, "walking revisions")
+		}
+	}
+
+	return append(rw.commits, rw.objects...), nil
+}
@@ -0,0 +1,72 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// writeServerInfoFile replaces a file through a lock file, so a client
+// reading it never sees it partly written.
+func writeServerInfoFile(path string, contents []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrap(err, "creating directory for "+path)
+	}
+
+	lock := path + ".lock"
+	err = os.WriteFile(lock, contents, 0644)
+	if err != nil {
+		return errors.Wrap(err, "writing "+path)
+	}
+
+	err = os.Rename(lock, path)
+	if err != nil {
+		os.Remove(lock)
+		return errors.Wrap(err, "writing "+path)
+	}
+
+	return nil
+}
+
+// UpdateServerInfo writes the files a dumb HTTP client reads in place of
+// talking to a server: info/refs, which lists the references along with
+// what annotated tags point to, and objects/info/packs, which lists the
+// packs.
+func UpdateServerInfo(gitDir string) error {
+	ra, err := AdvertiseReferences(gitDir, nil)
+	if err != nil {
+		return err
+	}
+
+	var refs bytes.Buffer
+	for _, ref := range ra.References {
+		if ref.Name != "HEAD" {
+			fmt.Fprintf(&refs, "%s\t%s\n", ref.ID, ref.Name)
+		}
+	}
+
+	err = writeServerInfoFile(filepath.Join(gitDir, "info", "refs"), refs.Bytes())
+	if err != nil {
+		return err
+	}
+
+	objectsDir := filepath.Join(gitDir, "objects")
+	packPaths, err := filepath.Glob(filepath.Join(paths.PackDir(objectsDir), "pack-*.pack"))
+	if err != nil {
+		return errors.Wrap(err, "listing packfiles")
+	}
+	sort.Strings(packPaths)
+
+	var packs bytes.Buffer
+	for _, packPath := range packPaths {
+		fmt.Fprintf(&packs, "P %s\n", filepath.Base(packPath))
+	}
+	packs.WriteString("\n")
+
+	return writeServerInfoFile(filepath.Join(objectsDir, "info", "packs"), packs.Bytes())
+}
@@ -0,0 +1,143 @@
+package pack
+
+import (
+	"context"
+	"github.com/kisom/codecrafters/git-go/credential"
+	"github.com/kisom/codecrafters/git-go/transport"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// Session is a connection to one of a remote repository's services.
+type Session interface {
+	// Advertisement returns the service's initial advertisement. It
+	// must be read before any requests are made.
+	Advertisement() (io.Reader, error)
+
+	// Request sends a request and returns the response, which the
+	// caller must close. On a stateless session, each request stands
+	// alone and has to repeat what the server needs to know; on a
+	// stateful one, requests continue a single conversation.
+	Request(body io.Reader) (io.ReadCloser, error)
+
+	Stateless() bool
+	Close() error
+}
+
+// ConnectOptions controls how a session is started.
+type ConnectOptions struct {
+	// Version is the protocol version to ask for, which the server
+	// may or may not support.
+	Version int
+
+	// SSHCommand runs ssh, as core.sshCommand does.
+	SSHCommand string
+
+	// Credentials fills in credentials when an HTTP server asks for
+	// them. Without it, only credentials in the URL or .netrc are used.
+	Credentials *credential.Manager
+
+	// HTTP configures connections to HTTP servers.
+	HTTP HTTPOptions
+}
+
+// Connect starts a session with a service on the remote repository.
+// Cancelling ctx aborts the session, whatever it's doing.
+func Connect(ctx context.Context, repo, service string, opts *ConnectOptions) (Session, error) {
+	if opts == nil {
+		opts = &ConnectOptions{}
+	}
+
+	endpoint, err := transport.ParseEndpoint(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case transport.ProtocolGit:
+		return connectGit(ctx, endpoint, service, opts)
+	case transport.ProtocolSSH:
+		return connectSSH(ctx, endpoint, service, opts)
+	case transport.ProtocolFile:
+		return connectLocal(ctx, endpoint.Path, service)
+	}
+
+	s, err := newHTTPSession(ctx, repo, service, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadAdvertisement reads a session's advertisement.
+func ReadAdvertisement(s Session) (*ReferenceAdvertisement, error) {
+	r, err := s.Advertisement()
+	if err != nil {
+		return nil, err
+	}
+
+	ra := &ReferenceAdvertisement{}
+	err = ra.UnmarshalReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing repo packfile body")
+	}
+	return ra, nil
+}
+
+// streamSession runs a service over a single bidirectional stream, such as
+// a pipe to a process. The server keeps its state between requests.
+type streamSession struct {
+	r     io.Reader
+	w     io.WriteCloser
+	close func() error
+
+	requested bool
+	closed    bool
+	pending   chan error
+}
+
+func (s *streamSession) Advertisement() (io.Reader, error) {
+	return s.r, nil
+}
+
+// Request writes the request in the background, since the server may
+// start responding before it has read all of it.
+func (s *streamSession) Request(body io.Reader) (io.ReadCloser, error) {
+	if s.pending != nil {
+		if err := <-s.pending; err != nil {
+			return nil, errors.Wrap(err, "sending request")
+		}
+	}
+
+	s.requested = true
+	s.pending = make(chan error, 1)
+	go func(pending chan<- error) {
+		_, err := io.Copy(s.w, body)
+		pending <- err
+	}(s.pending)
+
+	return io.NopCloser(s.r), nil
+}
+
+func (s *streamSession) Stateless() bool {
+	return false
+}
+
+// Close ends the session. A server that hasn't been asked for anything is
+// told so with a flush-pkt, rather than having the connection drop.
+func (s *streamSession) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if !s.requested {
+		writeFlush(s.w)
+	}
+
+	s.w.Close()
+	if s.close != nil {
+		return s.close()
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfiniteDepth is the depth git asks for to unshallow a repository.
+const InfiniteDepth = 0x7fffffff
+
+// repoShallow returns the shallow commits of the repository that holds
+// objectsDir, which is always directly inside the git directory.
+func repoShallow(objectsDir string) (map[string]bool, error) {
+	return paths.ReadShallow(filepath.Dir(objectsDir))
+}
+
+func commitTime(commit *objects.Commit) time.Time {
+	if commit.CommitTime.IsZero() {
+		return commit.Timestamp
+	}
+	return commit.CommitTime
+}
+
+// deepenArgs lists the lines that tell the remote about this repository's
+// shallow commits and how much history to send. Protocol v0 asks for a
+// relative depth with a capability instead of a line.
+func (opts *FetchOptions) deepenArgs(shallow map[string]bool, version int) []string {
+	var args []string
+	for id := range shallow {
+		args = append(args, "shallow "+id)
+	}
+
+	switch {
+	case opts.Depth > 0:
+		args = append(args, fmt.Sprintf("deepen %d", opts.Depth))
+		if opts.DeepenRelative && version == ProtocolV2 {
+			args = append(args, "deepen-relative")
+		}
+	case !opts.ShallowSince.IsZero():
+		args = append(args, fmt.Sprintf("deepen-since %d", opts.ShallowSince.Unix()))
+	}
+	return args
+}
+
+func (opts *FetchOptions) deepening() bool {
+	return opts.Depth > 0 || !opts.ShallowSince.IsZero()
+}
+
+func supportsShallow(ra *ReferenceAdvertisement) bool {
+	if ra.Version == ProtocolV2 {
+		return ra.Capabilities.Supports("fetch", "shallow")
+	}
+	return ra.Capabilities.Has("shallow")
+}
+
+// checkShallowCapabilities makes sure the remote can deepen the way the
+// options ask it to.
+func (opts *FetchOptions) checkShallowCapabilities(ra *ReferenceAdvertisement) error {
+	has := ra.Capabilities.Has
+	if ra.Version == ProtocolV2 {
+		// Protocol v2 servers that can fetch shallow can deepen
+		// every way there is.
+		has = func(string) bool {
+			return supportsShallow(ra)
+		}
+	}
+
+	switch {
+	case opts.deepening() && !supportsShallow(ra):
+		return errors.New("the server does not support shallow clients")
+	case !opts.ShallowSince.IsZero() && !has("deepen-since"):
+		return errors.New("the server does not support --shallow-since")
+	case opts.DeepenRelative && !has("deepen-relative"):
+		return errors.New("the server does not support --deepen")
+	}
+	return nil
+}
+
+// shallowUpdate collects the shallow and unshallow lines the remote sends
+// in answer to a deepening fetch.
+type shallowUpdate struct {
+	shallow   []string
+	unshallow []string
+}
+
+// readLine handles a shallow or unshallow line, returning false for
+// anything else.
+func (update *shallowUpdate) readLine(line []byte) bool {
+	kind, id, ok := strings.Cut(string(line), " ")
+	if !ok || len(id) != len(ZeroID) {
+		return false
+	}
+
+	switch kind {
+	case "shallow":
+		update.shallow = append(update.shallow, id)
+	case "unshallow":
+		update.unshallow = append(update.unshallow, id)
+	default:
+		return false
+	}
+	return true
+}
+
+// readShallowInfo reads the block of shallow and unshallow lines that
+// starts a protocol v0 response to a deepening fetch. It replaces what was
+// read before, since each stateless response repeats it.
+func (update *shallowUpdate) readShallowInfo(r io.Reader) error {
+	update.shallow, update.unshallow = nil, nil
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading shallow info")
+		}
+
+		if len(line) == 0 {
+			return nil
+		}
+
+		if !update.readLine(line) {
+			return fmt.Errorf("unexpected shallow info %q", line)
+		}
+	}
+}
+
+// readV2ShallowInfo reads the shallow-info section of a protocol v2 fetch
+// response, which comes before the packfile.
+func (update *shallowUpdate) readV2ShallowInfo(r io.Reader) error {
+	for {
+		kind, line, err := readPacket(r)
+		if err != nil {
+			return errors.Wrap(err, "reading shallow-info section")
+		}
+
+		if kind == PacketDelim {
+			return nil
+		}
+
+		if kind != PacketData || !update.readLine(bytes.TrimSuffix(line, []byte("\n"))) {
+			return fmt.Errorf("unexpected shallow info %q", line)
+		}
+	}
+}
+
+// apply updates the repository's shallow file once the pack is stored.
+func (update *shallowUpdate) apply(objectsDir string, shallow map[string]bool) error {
+	if len(update.shallow) == 0 && len(update.unshallow) == 0 {
+		return nil
+	}
+
+	for _, id := range update.shallow {
+		shallow[id] = true
+	}
+
+	for _, id := range update.unshallow {
+		delete(shallow, id)
+	}
+
+	return paths.WriteShallow(filepath.Dir(objectsDir), shallow)
+}
+
+// deepenRequest is how far a client wants its history to go.
+type deepenRequest struct {
+	depth    int
+	since    time.Time
+	relative bool
+}
+
+func (d *deepenRequest) requested() bool {
+	return d.depth > 0 || !d.since.IsZero()
+}
+
+// parseLine handles the deepen lines in a want block, returning
+// false for anything else. A relative depth is asked for with the
+// deepen-relative capability.
+func (d *deepenRequest) parseLine(line string) (bool, error) {
+	var err error
+	switch {
+	case strings.HasPrefix(line, "deepen "):
+		d.depth, err = strconv.Atoi(strings.TrimPrefix(line, "deepen "))
+		if err == nil && d.depth <= 0 {
+			err = fmt.Errorf("invalid depth %d", d.depth)
+		}
+	case strings.HasPrefix(line, "deepen-since "):
+		var since int64
+		since, err = strconv.ParseInt(strings.TrimPrefix(line, "deepen-since "), 10, 64)
+		d.since = time.Unix(since, 0)
+	default:
+		return false, nil
+	}
+
+	return true, errors.Wrap(err, "parsing "+line)
+}
+
+type shallowWalkItem struct {
+	id    string
+	depth int
+}
+
+// shallowBoundary works out where the history sent to a client should stop:
+// the commits the client will hold without their parents. Its existing
+// shallow commits whose parents will be sent are unshallowed.
+func shallowBoundary(store objects.ObjectStore, wants []string, clientShallow, ownShallow map[string]bool, d *deepenRequest) (map[string]bool, []string, error) {
+	limit := d.depth
+	var queue []shallowWalkItem
+	if d.relative {
+		// The depth counts from the client's current boundary.
+		limit++
+		for id := range clientShallow {
+			queue = append(queue, shallowWalkItem{id: id, depth: 1})
+		}
+	} else {
+		for _, id := range wants {
+			queue = append(queue, shallowWalkItem{id: id, depth: 1})
+		}
+	}
+
+	boundary := map[string]bool{}
+	var unshallow []string
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		if seen[item.id] {
+			continue
+		}
+		seen[item.id] = true
+
+		blob, err := store.Read(item.id)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "reading "+item.id)
+		}
+
+		// Wants can be tags.
+		if blob.Type == objects.TypeTag {
+			target, err := tagTarget(blob)
+			if err != nil {
+				return nil, nil, err
+			}
+			queue = append(queue, shallowWalkItem{id: target, depth: item.depth})
+			continue
+		}
+
+		if blob.Type != objects.TypeCommit {
+			continue
+		}
+
+		commit, err := objects.CommitFromBlob(blob)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		parents := commit.Parents
+		if ownShallow[item.id] {
+			parents = nil
+		}
+
+		stop := len(parents) > 0 && d.depth > 0 && item.depth >= limit
+		if !d.since.IsZero() {
+			for _, parent := range parents {
+				parentCommit, err := objects.ReadCommit(store, parent)
+				if err != nil {
+					return nil, nil, errors.Wrap(err, "reading "+parent)
+				}
+
+				stop = stop || commitTime(parentCommit).Before(d.since)
+			}
+		}
+
+		if stop {
+			boundary[item.id] = true
+			continue
+		}
+
+		if clientShallow[item.id] && len(parents) > 0 {
+			unshallow = append(unshallow, item.id)
+		}
+
+		for _, parent := range parents {
+			queue = append(queue, shallowWalkItem{id: parent, depth: item.depth + 1})
+		}
+	}
+
+	return boundary, unshallow, nil
+}
@@ -0,0 +1,76 @@
+package pack
+
+import (
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestShallowFetch(t *testing.T) {
+	srcDir, commits := newTestRepository(t, 10)
+	srv := httptest.NewServer(NewHTTPHandler(filepath.Dir(srcDir)))
+	defer srv.Close()
+
+	// Stateless over HTTP, and stateful over the local transport.
+	for _, repo := range []string{srv.URL + "/", srcDir} {
+		gitDir := newBareRepository(t)
+		store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+		fetch := func(opts *FetchOptions) {
+			session := testSession(t, repo, ServiceUploadPack, ProtocolV0)
+			ra, err := ReadAdvertisement(session)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = FetchPack(session, ra, store, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		checkShallow := func(expected ...string) {
+			shallow, err := paths.ReadShallow(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(shallow) != len(expected) {
+				t.Fatalf("%s: expected shallow commits %v, have %v", repo, expected, shallow)
+			}
+
+			for _, id := range expected {
+				if !shallow[id] {
+					t.Fatalf("%s: expected shallow commits %v, have %v", repo, expected, shallow)
+				}
+			}
+		}
+
+		fetch(&FetchOptions{Depth: 2})
+		checkShallow(commits[8])
+		if store.Has(commits[7]) {
+			t.Fatalf("%s: a depth 2 fetch brought in %s", repo, commits[7])
+		}
+
+		fetch(&FetchOptions{Wants: commits[9:], Haves: commits[9:], Depth: 3, DeepenRelative: true})
+		checkShallow(commits[5])
+
+		revObjects, err := RevList(store, commits[9:], nil)
+		if err != nil {
+			t.Fatalf("%s: %v", repo, err)
+		}
+
+		if len(revObjects) != 15 {
+			t.Fatalf("%s: expected 15 objects down to the shallow boundary, have %d", repo, len(revObjects))
+		}
+
+		fetch(&FetchOptions{Wants: commits[9:], Haves: commits[9:], Depth: InfiniteDepth})
+		checkShallow()
+		for _, id := range commits {
+			if !store.Has(id) {
+				t.Fatalf("%s: commit %s is missing after unshallowing", repo, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	sideBandData     = 1
+	sideBandProgress = 2
+	sideBandError    = 3
+)
+
+// RemoteError is a fatal error sent by the remote, on side-band 3 or in an
+// ERR packet.
+type RemoteError struct {
+	Message string
+}
+
+func (err *RemoteError) Error() string {
+	return "remote error: " + err.Message
+}
+
+// SideBandReader reads the pack data multiplexed onto band 1 of a side-band
+// or side-band-64k stream. Progress messages on band 2 are copied to the
+// progress sink, if there is one, and an error on band 3 is returned as a
+// *RemoteError. The stream ends at a flush-pkt.
+type SideBandReader struct {
+	r        io.Reader
+	progress *progressWriter
+	buf      []byte
+	err      error
+}
+
+// NewSideBandReader returns a SideBandReader reading from r. If progress is
+// nil, progress messages are discarded.
+func NewSideBandReader(r io.Reader, progress io.Writer) *SideBandReader {
+	sb := &SideBandReader{r: r}
+	if progress != nil {
+		sb.progress = &progressWriter{w: progress}
+	}
+	return sb
+}
+
+func (sb *SideBandReader) Read(p []byte) (int, error) {
+	for len(sb.buf) == 0 {
+		if sb.err != nil {
+			return 0, sb.err
+		}
+		sb.err = sb.next()
+	}
+
+	n := copy(p, sb.buf)
+	sb.buf = sb.buf[n:]
+	return n, nil
+}
+
+// next reads pkt-lines until there's pack data to return or the stream
+// ends.
+func (sb *SideBandReader) next() error {
+	payload, err := readPktLinePayload(sb.r)
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if payload == nil {
+		if sb.progress != nil {
+			sb.progress.flush()
+		}
+		return io.EOF
+	}
+
+	switch payload[0] {
+	case sideBandData:
+		sb.buf = payload[1:]
+	case sideBandProgress:
+		if sb.progress != nil {
+			sb.progress.write(payload[1:])
+		}
+	case sideBandError:
+		return &RemoteError{Message: string(bytes.TrimSpace(payload[1:]))}
+	default:
+		return fmt.Errorf("invalid side-band %d", payload[0])
+	}
+
+	return nil
+}
+
+// progressWriter prefixes each line of progress with "remote: ", the way
+// git displays them. Lines may end in a carriage return so that counters
+// update in place, and may be split across packets, so partial lines are
+// held back until they're finished.
+type progressWriter struct {
+	w       io.Writer
+	partial []byte
+}
+
+func (pw *progressWriter) write(msg []byte) {
+	pw.partial = append(pw.partial, msg...)
+	for {
+		end := bytes.IndexAny(pw.partial, "\r\n")
+		if end < 0 {
+			return
+		}
+
+		fmt.Fprintf(pw.w, "remote: %s", pw.partial[:end+1])
+		pw.partial = pw.partial[end+1:]
+	}
+}
+
+func (pw *progressWriter) flush() {
+	if len(pw.partial) > 0 {
+		fmt.Fprintf(pw.w, "remote: %s\n", pw.partial)
+		pw.partial = nil
+	}
+}
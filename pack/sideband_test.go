@@ -0,0 +1,57 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func sideBandPacket(band byte, data string) []byte {
+	return writePktLine(append([]byte{band}, data...))
+}
+
+func TestSideBandReader(t *testing.T) {
+	stream := &bytes.Buffer{}
+	stream.Write(sideBandPacket(sideBandProgress, "Counting: 1\rCoun"))
+	stream.Write(sideBandPacket(sideBandData, "PACK"))
+	stream.Write(sideBandPacket(sideBandProgress, "ting: 2\r"))
+	stream.Write(sideBandPacket(sideBandData, "\x00\x01\x02"))
+	stream.Write(sideBandPacket(sideBandProgress, "done.\n"))
+	stream.Write(flushPkt)
+
+	progress := &bytes.Buffer{}
+	data, err := io.ReadAll(NewSideBandReader(stream, progress))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "PACK\x00\x01\x02" {
+		t.Fatalf("unexpected data %q", data)
+	}
+
+	expected := "remote: Counting: 1\rremote: Counting: 2\rremote: done.\n"
+	if progress.String() != expected {
+		t.Fatalf("unexpected progress %q, expected %q", progress.String(), expected)
+	}
+}
+
+func TestSideBandReaderError(t *testing.T) {
+	stream := &bytes.Buffer{}
+	stream.Write(sideBandPacket(sideBandData, "PACK"))
+	stream.Write(sideBandPacket(sideBandError, "upload-pack: not our ref\n"))
+
+	data, err := io.ReadAll(NewSideBandReader(stream, nil))
+	if string(data) != "PACK" {
+		t.Fatalf("unexpected data %q", data)
+	}
+
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("expected a remote error, have %v", err)
+	}
+
+	if remoteErr.Message != "upload-pack: not our ref" {
+		t.Fatalf("unexpected remote error message %q", remoteErr.Message)
+	}
+}
@@ -0,0 +1,108 @@
+package pack
+
+import (
+	"context"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/trace2"
+	"github.com/kisom/codecrafters/git-go/transport"
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// shellQuote quotes a string for the remote shell, which runs the service.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshCommand builds the command that runs the service on the remote.
+// GIT_SSH_COMMAND and core.sshCommand are run by the shell, so they can
+// carry arguments; GIT_SSH names a program.
+func sshCommand(ctx context.Context, endpoint *transport.Endpoint, service string, opts *ConnectOptions) *exec.Cmd {
+	var args []string
+	program := "ssh"
+	shell := os.Getenv("GIT_SSH_COMMAND")
+	if strings.TrimSpace(shell) == "" {
+		shell = opts.SSHCommand
+	}
+
+	useShell := false
+	if fields := strings.Fields(shell); len(fields) > 0 {
+		program = fields[0]
+		useShell = true
+	} else if os.Getenv("GIT_SSH") != "" {
+		program = os.Getenv("GIT_SSH")
+	}
+	isOpenSSH := filepath.Base(program) == "ssh"
+
+	// Protocol v2 is asked for through the environment, which OpenSSH
+	// has to be told to pass on.
+	var env []string
+	if opts.Version != ProtocolV0 {
+		env = append(env, fmt.Sprintf("GIT_PROTOCOL=version=%d", opts.Version))
+		if isOpenSSH {
+			args = append(args, "-o", "SendEnv=GIT_PROTOCOL")
+		}
+	}
+
+	if endpoint.Port != "" {
+		args = append(args, "-p", endpoint.Port)
+	}
+
+	// OpenSSH stops looking for options at --; other programs may not
+	// understand it.
+	if isOpenSSH {
+		args = append(args, "--")
+	}
+
+	host := endpoint.Host
+	if endpoint.User != "" {
+		host = endpoint.User + "@" + host
+	}
+	args = append(args, host, service+" "+shellQuote(endpoint.Path))
+
+	var cmd *exec.Cmd
+	if useShell {
+		cmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", shell + ` "$@"`, shell}, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, program, args...)
+	}
+
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// connectSSH runs the service on the remote over ssh, talking to it
+// through the ssh process's standard input and output.
+func connectSSH(ctx context.Context, endpoint *transport.Endpoint, service string, opts *ConnectOptions) (Session, error) {
+	cmd := sshCommand(ctx, endpoint, service, opts)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting over ssh")
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting over ssh")
+	}
+
+	child := trace2.ChildStart("transport/ssh", cmd)
+	err = cmd.Start()
+	if err != nil {
+		child.Exit(err)
+		return nil, errors.Wrap(err, "starting ssh")
+	}
+
+	return &streamSession{
+		r: stdout,
+		w: stdin,
+		close: func() error {
+			err := cmd.Wait()
+			child.Exit(err)
+			return errors.Wrap(err, "running ssh")
+		},
+	}, nil
+}
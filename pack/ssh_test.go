@@ -0,0 +1,154 @@
+package pack
+
+import (
+	"context"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/kisom/codecrafters/git-go/transport"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSSHHelper isn't a real test: it's the remote end of the fake ssh used
+// by TestSSHTransport, running the service it's asked for.
+func TestSSHHelper(t *testing.T) {
+	if os.Getenv("PACK_TEST_SSH_HELPER") == "" {
+		t.Skip("only run by the fake ssh")
+	}
+
+	service, quoted, _ := strings.Cut(os.Args[len(os.Args)-1], " ")
+	serve := UploadPack
+	if service == ServiceReceivePack {
+		serve = ReceivePack
+	}
+
+	gitDir, _ := paths.FindGitDir(strings.Trim(quoted, "'"))
+	err := serve(gitDir, os.Stdin, os.Stdout, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// fakeSSH writes a script that stands in for ssh, recording its arguments
+// in args.
+func fakeSSH(t *testing.T) (string, string) {
+	testBinary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ssh")
+	args := filepath.Join(dir, "args")
+	err = os.WriteFile(script, []byte(fmt.Sprintf(`#!/bin/sh
+echo "$@" >> '%s'
+PACK_TEST_SSH_HELPER=1 exec '%s' -test.run='^TestSSHHelper$' -- "$@"
+`, args, testBinary)), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return script, args
+}
+
+func TestSSHTransport(t *testing.T) {
+	script, args := fakeSSH(t)
+	srcDir, commits := newTestRepository(t, 10)
+	dstDir := newBareRepository(t)
+	t.Setenv("GIT_SSH_COMMAND", "")
+
+	opts := &ConnectOptions{Version: ProtocolV2, SSHCommand: script}
+	session, err := Connect(context.Background(), "ssh://git@example.com:2222"+srcDir, ServiceUploadPack, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ra, err := ListRefs(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := objects.NewDirStore(filepath.Join(dstDir, "objects"))
+	_, err = FetchPack(session, ra, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range commits {
+		if !dst.Has(id) {
+			t.Fatalf("commit %s wasn't fetched", id)
+		}
+	}
+
+	// Push the history back under another name, with GIT_SSH_COMMAND
+	// taking precedence over the configured command.
+	t.Setenv("GIT_SSH_COMMAND", script+" -v")
+	session, err = Connect(context.Background(), "example.com:"+srcDir, ServiceReceivePack, &ConnectOptions{SSHCommand: "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ra, err = ReadAdvertisement(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := &RefUpdate{Name: "refs/heads/copy", OldID: ZeroID, NewID: commits[9]}
+	report, err := SendPack(session, ra, dst, []*RefUpdate{update}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := report.Status("refs/heads/copy"); status == nil || status.Error != "" {
+		t.Fatalf("push failed: %#v", report)
+	}
+
+	err = session.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf("-o SendEnv=GIT_PROTOCOL -p 2222 -- git@example.com git-upload-pack '%s'\n", srcDir) +
+		fmt.Sprintf("-v -- example.com git-receive-pack '%s'\n", srcDir)
+	if string(recorded) != expected {
+		t.Fatalf("expected ssh to be run with\n%s\nhave\n%s", expected, recorded)
+	}
+}
+
+func TestSSHCommand(t *testing.T) {
+	for _, repo := range []string{"-oProxyCommand=touch${IFS}pwned:repo.git", "ssh://-oProxyCommand=x/repo"} {
+		_, err := Connect(context.Background(), repo, ServiceUploadPack, &ConnectOptions{SSHCommand: "false"})
+		if err == nil || !strings.Contains(err.Error(), "blocked") {
+			t.Fatalf("expected connecting to %s to be blocked, have %v", repo, err)
+		}
+	}
+
+	// An empty GIT_SSH_COMMAND is as good as an unset one.
+	t.Setenv("GIT_SSH", "")
+	endpoint := &transport.Endpoint{Protocol: transport.ProtocolSSH, Host: "example.com", Path: "repo.git"}
+	for _, shell := range []string{"", "  "} {
+		t.Setenv("GIT_SSH_COMMAND", shell)
+		cmd := sshCommand(context.Background(), endpoint, ServiceUploadPack, &ConnectOptions{})
+		expected := []string{"ssh", "--", "example.com", "git-upload-pack 'repo.git'"}
+		if strings.Join(cmd.Args, " ") != strings.Join(expected, " ") {
+			t.Fatalf("expected ssh to be run as %q, have %q", expected, cmd.Args)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package pack
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/config"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/kisom/codecrafters/git-go/paths"
+	"github.com/pkg/errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var uploadPackCapabilities = Capabilities{
+	{Name: "multi_ack_detailed"},
+	{Name: "side-band-64k"},
+	{Name: "side-band"},
+	{Name: "ofs-delta"},
+	{Name: "shallow"},
+	{Name: "deepen-since"},
+	{Name: "deepen-relative"},
+	{Name: "no-progress"},
+}
+
+// repoUploadPackCapabilities adds the capabilities a repository's config
+// turns on. As in git, serving partial clones is opt-in with
+// uploadpack.allowFilter, and so is sending objects that aren't ref tips
+// with uploadpack.allowReachableSHA1InWant, which partial clones need to
+// fetch what they left out.
+func repoUploadPackCapabilities(gitDir string) (Capabilities, error) {
+	cfg, err := config.Load(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities := append(Capabilities{}, uploadPackCapabilities...)
+	for _, option := range []struct{ key, capability string }{
+		{"uploadpack.allowFilter", "filter"},
+		{"uploadpack.allowReachableSHA1InWant", "allow-reachable-sha1-in-want"},
+	} {
+		allowed, err := cfg.GetBool(option.key, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if allowed {
+			capabilities = append(capabilities, Capability{Name: option.capability})
+		}
+	}
+
+	return capabilities, nil
+}
+
+// AdvertiseReferences builds the reference advertisement for a local
+// repository: HEAD, then every ref in name order, with annotated tags
+// followed by the objects they point to.
+func AdvertiseReferences(gitDir string, capabilities Capabilities) (*ReferenceAdvertisement, error) {
+	return advertiseReferences(gitDir, objects.NewDirStore(filepath.Join(gitDir, "objects")), capabilities)
+}
+
+// advertiseReferences is AdvertiseReferences with the tags peeled through
+// the repository's store.
+func advertiseReferences(gitDir string, store objects.ObjectStore, capabilities Capabilities) (*ReferenceAdvertisement, error) {
+	refs, err := paths.ListRefs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities = append(Capabilities{}, capabilities...)
+	ra := &ReferenceAdvertisement{}
+	head, err := paths.ReadRef(gitDir, "HEAD")
+	if err == nil {
+		ra.References = append(ra.References, &Reference{ID: head, Name: "HEAD"})
+	}
+
+	target, err := paths.ReadSymbolicRef(gitDir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := refs[target]; ok {
+		capabilities = append(capabilities, Capability{Name: "symref", Value: "HEAD:" + target})
+	}
+	ra.Capabilities = append(capabilities, Capability{Name: "agent", Value: Agent})
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ra.References = append(ra.References, &Reference{ID: refs[name], Name: name})
+
+		peeled, err := peelTag(store, refs[name])
+		if err != nil {
+			return nil, err
+		}
+
+		if peeled != refs[name] {
+			ra.References = append(ra.References, &Reference{ID: peeled, Name: name + peeledSuffix})
+		}
+	}
+
+	return ra, nil
+}
+
+// peelTag follows annotated tags to the object they finally point to.
+func peelTag(store objects.ObjectStore, id string) (string, error) {
+	for {
+		blob, err := store.Read(id)
+		if err != nil {
+			return "", errors.Wrap(err, "reading ref target")
+		}
+
+		if blob.Type != objects.TypeTag {
+			return id, nil
+		}
+
+		id, err = tagTarget(blob)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// Encode writes the advertisement as a series of pkt-lines, with the
+// capabilities after the first ref, followed by a flush-pkt.
+func (ra *ReferenceAdvertisement) Encode(w io.Writer) error {
+	capabilities := "\x00" + ra.Capabilities.String()
+	if len(ra.References) == 0 {
+		err := writeLine(w, ZeroID+" "+capabilitiesRef+capabilities)
+		if err == nil {
+			err = writeFlush(w)
+		}
+		return err
+	}
+
+	for i, ref := range ra.References {
+		line := ref.ID + " " + ref.Name
+		if i == 0 {
+			line += capabilities
+		}
+
+		err := writeLine(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFlush(w)
+}
+
+// sideBandWriter splits what's written to it into side-band packets.
+type sideBandWriter struct {
+	w    io.Writer
+	band byte
+	max  int
+}
+
+func (sb *sideBandWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), sb.max)
+		err := NewPktLineWriter(sb.w).WritePacket(append([]byte{sb.band}, p[:n]...))
+		if err != nil {
+			return written, err
+		}
+
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+type uploadPackRequest struct {
+	wants        []string
+	capabilities Capabilities
+	common       []string
+	done         bool
+
+	// shallow holds the client's shallow commits, and deepen how much
+	// history it wants.
+	shallow map[string]bool
+	deepen  deepenRequest
+
+	// boundary and unshallow are the shallow commits the client will
+	// have after the fetch, and those of its current ones that are
+	// getting their parents.
+	boundary  map[string]bool
+	unshallow []string
+
+	// filter leaves objects out for a partial clone.
+	filter *Filter
+}
+
+func (req *uploadPackRequest) readWants(r io.Reader, allowFilter bool) error {
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading wants")
+		}
+
+		if len(line) == 0 {
+			return nil
+		}
+
+		if id, ok := strings.CutPrefix(string(line), "shallow "); ok {
+			req.shallow[id] = true
+			continue
+		}
+
+		ok, err := req.deepen.parseLine(string(line))
+		if err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		if spec, ok := strings.CutPrefix(string(line), "filter "); ok && allowFilter {
+			req.filter, err = ParseFilter(spec)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 || fields[0] != "want" {
+			return fmt.Errorf("expected want, have %q", line)
+		}
+
+		req.wants = append(req.wants, fields[1])
+		req.capabilities = append(req.capabilities, ParseCapabilities(strings.Join(fields[2:], " "))...)
+	}
+}
+
+// checkWants makes sure every want is an advertised ref or, if the
+// repository allows it, reachable from one.
+func (req *uploadPackRequest) checkWants(store *objects.DirStore, ra *ReferenceAdvertisement) error {
+	allowed := map[string]bool{}
+	for _, ref := range ra.References {
+		allowed[ref.ID] = true
+	}
+
+	var reachable map[string]bool
+	for _, id := range req.wants {
+		if allowed[id] {
+			continue
+		}
+
+		if !ra.Capabilities.Has("allow-reachable-sha1-in-want") {
+			return fmt.Errorf("not our ref %s", id)
+		}
+
+		if reachable == nil {
+			revObjects, err := RevList(store, ra.wantIDs(), nil)
+			if err != nil {
+				return err
+			}
+
+			reachable = map[string]bool{}
+			for _, obj := range revObjects {
+				reachable[obj.ID] = true
+			}
+		}
+
+		if !reachable[id] {
+			return fmt.Errorf("not our ref %s", id)
+		}
+	}
+
+	return nil
+}
+
+// sendShallowInfo works out the client's new shallow boundary and tells it
+// about the changes, ending with a flush-pkt.
+func (req *uploadPackRequest) sendShallowInfo(w io.Writer, store objects.ObjectStore, ownShallow map[string]bool) error {
+	var err error
+	req.boundary, req.unshallow, err = shallowBoundary(store, req.wants, req.shallow, ownShallow, &req.deepen)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range sortedIDs(req.boundary) {
+		if !req.shallow[id] {
+			writeLine(w, "shallow "+id)
+		}
+	}
+
+	for _, id := range req.unshallow {
+		writeLine(w, "unshallow "+id)
+	}
+
+	return writeFlush(w)
+}
+
+func sortedIDs(ids map[string]bool) []string {
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// revList lists the objects to send. History stops at the client's shallow
+// boundary, and what the client has stops at its current one.
+func (req *uploadPackRequest) revList(store objects.ObjectStore, ownShallow map[string]bool) ([]RevObject, error) {
+	has := union(req.shallow, ownShallow)
+	if !req.deepen.requested() {
+		return revList(store, req.wants, req.common, has, has, req.filter)
+	}
+
+	// The unshallowed commits are already there, but their parents
+	// are needed now.
+	include := append([]string{}, req.wants...)
+	for _, id := range req.unshallow {
+		commit, err := objects.ReadCommit(store, id)
+		if err != nil {
+			return nil, err
+		}
+		include = append(include, commit.Parents...)
+	}
+
+	return revList(store, include, req.common, union(req.boundary, ownShallow), has, req.filter)
+}
+
+func union(a, b map[string]bool) map[string]bool {
+	u := map[string]bool{}
+	for id := range a {
+		u[id] = true
+	}
+	for id := range b {
+		u[id] = true
+	}
+	return u
+}
+
+// negotiate reads a block of haves, acknowledging the ones this side has
+// too. It returns at the flush-pkt that ends the block, or at done.
+func (req *uploadPackRequest) negotiate(r io.Reader, w io.Writer, store objects.ObjectStore) error {
+	found := false
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return errors.Wrap(err, "reading haves")
+		}
+
+		switch {
+		case len(line) == 0:
+			// With no way of telling whether the wants are
+			// covered, one round finding common commits is enough.
+			if found && req.capabilities.Has("multi_ack_detailed") {
+				writeLine(w, "ACK "+req.common[len(req.common)-1]+" ready")
+			}
+			err = writeLine(w, "NAK")
+			return err
+		case string(line) == "done":
+			req.done = true
+			if len(req.common) == 0 {
+				err = writeLine(w, "NAK")
+			} else {
+				err = writeLine(w, "ACK "+req.common[len(req.common)-1])
+			}
+			return err
+		}
+
+		id, ok := strings.CutPrefix(string(line), "have ")
+		if !ok {
+			return fmt.Errorf("expected have, have %q", line)
+		}
+
+		if store.Has(id) {
+			req.common = append(req.common, id)
+			found = true
+			if req.capabilities.Has("multi_ack_detailed") {
+				writeLine(w, "ACK "+id+" common")
+			}
+		}
+	}
+}
+
+func (req *uploadPackRequest) sendPack(w io.Writer, store objects.ObjectStore, ownShallow map[string]bool) error {
+	var progress io.Writer = io.Discard
+	var packWriter io.Writer = w
+	switch {
+	case req.capabilities.Has("side-band-64k"):
+		packWriter = &sideBandWriter{w: w, band: sideBandData, max: 65515}
+		progress = &sideBandWriter{w: w, band: sideBandProgress, max: 65515}
+	case req.capabilities.Has("side-band"):
+		packWriter = &sideBandWriter{w: w, band: sideBandData, max: 995}
+		progress = &sideBandWriter{w: w, band: sideBandProgress, max: 995}
+	}
+
+	if req.capabilities.Has("no-progress") {
+		progress = io.Discard
+	}
+
+	revObjects, err := req.revList(store, ownShallow)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(revObjects))
+
+	buf := bufio.NewWriterSize(packWriter, 65515)
+	_, err = WritePack(buf, store, revObjects, nil)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "Total %d\n", len(revObjects))
+	if packWriter != w {
+		err = writeFlush(w)
+	}
+	return err
+}
+
+// UploadPack serves a fetch from the repository at gitDir, reading the
+// client's requests from r and writing responses to w. A stateless
+// exchange, as over HTTP, starts without the advertisement and ends after
+// one round of negotiation.
+func UploadPack(gitDir string, r io.Reader, w io.Writer, stateless bool) error {
+	capabilities, err := repoUploadPackCapabilities(gitDir)
+	if err != nil {
+		return err
+	}
+
+	store := objects.NewDirStore(filepath.Join(gitDir, "objects"))
+	ra, err := advertiseReferences(gitDir, store, capabilities)
+	if err != nil {
+		return err
+	}
+
+	if !stateless {
+		err = ra.Encode(w)
+		if err != nil {
+			return errors.Wrap(err, "writing advertisement")
+		}
+	}
+
+	req := &uploadPackRequest{shallow: map[string]bool{}}
+	err = req.readWants(r, capabilities.Has("filter"))
+	if err == nil {
+		err = req.checkWants(store, ra)
+	}
+	if err != nil {
+		writeLine(w, "ERR upload-pack: "+err.Error())
+		return err
+	}
+
+	// A client that wants nothing sends only a flush-pkt.
+	if len(req.wants) == 0 {
+		return nil
+	}
+	req.deepen.relative = req.capabilities.Has("deepen-relative")
+
+	ownShallow, err := paths.ReadShallow(gitDir)
+	if err != nil {
+		return err
+	}
+
+	if req.deepen.requested() {
+		err = req.sendShallowInfo(w, store, ownShallow)
+		if err != nil {
+			writeLine(w, "ERR upload-pack: "+err.Error())
+			return err
+		}
+	}
+
+	for !req.done {
+		err = req.negotiate(r, w, store)
+		if err != nil {
+			return err
+		}
+
+		if stateless && !req.done {
+			return nil
+		}
+	}
+
+	err = req.sendPack(w, store, ownShallow)
+	if err != nil && (req.capabilities.Has("side-band-64k") || req.capabilities.Has("side-band")) {
+		(&sideBandWriter{w: w, band: sideBandError, max: 995}).Write([]byte("upload-pack: " + err.Error() + "\n"))
+	}
+	return err
+}
@@ -0,0 +1,323 @@
+package pack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"github.com/pkg/errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	DefaultWindow = 10
+	DefaultDepth  = 50
+
+	packVersion = 2
+
+	// minDeltaSize is the smallest object worth trying to delta; smaller
+	// objects compress just as well on their own.
+	minDeltaSize = 50
+)
+
+type PackOptions struct {
+	// Window is the number of preceding objects considered as delta
+	// bases for each object. A window of 0 disables deltas.
+	Window int
+
+	// Depth is the longest delta chain the writer will create.
+	Depth int
+}
+
+func DefaultPackOptions() *PackOptions {
+	return &PackOptions{
+		Window: DefaultWindow,
+		Depth:  DefaultDepth,
+	}
+}
+
+type packObject struct {
+	id         []byte
+	name       string
+	objectType objects.PackObjectType
+	data       []byte
+
+	base   *packObject
+	delta  []byte
+	depth  int
+	offset uint64
+}
+
+// nameHash is git's pack_name_hash: it sorts objects so that files with
+// the same name (and especially the same suffix) end up near each other.
+func nameHash(name string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == ' ' || c == '\t' || c == '\n' {
+			continue
+		}
+		hash = (hash >> 2) + (uint32(c) << 24)
+	}
+	return hash
+}
+
+func loadPackObjects(store objects.ObjectStore, revObjects []RevObject) ([]*packObject, error) {
+	seen := map[string]bool{}
+	var packObjects []*packObject
+
+	for _, obj := range revObjects {
+		if seen[obj.ID] {
+			continue
+		}
+		seen[obj.ID] = true
+
+		id, err := hex.DecodeString(obj.ID)
+		if err != nil || len(id) != 20 {
+			return nil, fmt.Errorf("invalid object ID %q", obj.ID)
+		}
+
+		blob, err := store.Read(obj.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading object to pack")
+		}
+
+		objectType, err := objects.PackObjectTypeFor(blob.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		packObjects = append(packObjects, &packObject{
+			id:         id,
+			name:       obj.Name,
+			objectType: objectType,
+			data:       blob.Contents,
+		})
+	}
+
+	return packObjects, nil
+}
+
+// selectDeltas sorts the objects into the order they'll be written in and
+// picks a delta base for each object from the window of objects before it.
+func selectDeltas(packObjects []*packObject, opts *PackOptions) {
+	sort.SliceStable(packObjects, func(i, j int) bool {
+		a, b := packObjects[i], packObjects[j]
+		if a.objectType != b.objectType {
+			return a.objectType < b.objectType
+		}
+
+		if ha, hb := nameHash(a.name), nameHash(b.name); ha != hb {
+			return ha < hb
+		}
+
+		return len(a.data) > len(b.data)
+	})
+
+	if opts.Window <= 0 || opts.Depth <= 0 {
+		return
+	}
+
+	for i, target := range packObjects {
+		if len(target.data) < minDeltaSize {
+			continue
+		}
+
+		maxSize := len(target.data)/2 - 20
+		start := i - opts.Window
+		if start < 0 {
+			start = 0
+		}
+
+		for j := i - 1; j >= start; j-- {
+			base := packObjects[j]
+			if base.objectType != target.objectType || base.depth >= opts.Depth {
+				continue
+			}
+
+			sizeDiff := len(base.data) - len(target.data)
+			if sizeDiff < 0 {
+				sizeDiff = -sizeDiff
+			}
+			if sizeDiff >= maxSize {
+				continue
+			}
+
+			delta := computeDelta(base.data, target.data)
+			if len(delta) >= maxSize {
+				continue
+			}
+
+			target.base = base
+			target.delta = delta
+			target.depth = base.depth + 1
+			maxSize = len(delta)
+		}
+	}
+}
+
+type packWriter struct {
+	w      io.Writer
+	hash   hash.Hash
+	offset uint64
+}
+
+func (pw *packWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.hash.Write(p[:n])
+	pw.offset += uint64(n)
+	return n, err
+}
+
+func compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := zlib.NewWriter(buf)
+
+	_, err := encoder.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (pw *packWriter) writeEntry(obj *packObject) (objects.PackIndexEntry, error) {
+	obj.offset = pw.offset
+
+	var entry []byte
+	var compressed []byte
+	var err error
+	if obj.base != nil {
+		entry = objects.EncodePackEntryHeader(objects.PackOffsetDelta, uint64(len(obj.delta)))
+		entry = append(entry, objects.EncodeOffsetDeltaDistance(obj.offset-obj.base.offset)...)
+		compressed, err = compress(obj.delta)
+	} else {
+		entry = objects.EncodePackEntryHeader(obj.objectType, uint64(len(obj.data)))
+		compressed, err = compress(obj.data)
+	}
+
+	if err != nil {
+		return objects.PackIndexEntry{}, errors.Wrapf(err, "compressing object %x", obj.id)
+	}
+	entry = append(entry, compressed...)
+
+	_, err = pw.Write(entry)
+	if err != nil {
+		return objects.PackIndexEntry{}, errors.Wrap(err, "writing pack entry")
+	}
+
+	return objects.PackIndexEntry{
+		ID:     obj.id,
+		CRC:    crc32.ChecksumIEEE(entry),
+		Offset: obj.offset,
+	}, nil
+}
+
+// WritePack writes a version 2 packfile containing the given objects, read
+// from store, to w and returns its index.
+func WritePack(w io.Writer, store objects.ObjectStore, revObjects []RevObject, opts *PackOptions) (*objects.PackIndex, error) {
+	if opts == nil {
+		opts = DefaultPackOptions()
+	}
+
+	packObjects, err := loadPackObjects(store, revObjects)
+	if err != nil {
+		return nil, err
+	}
+
+	selectDeltas(packObjects, opts)
+
+	pw := &packWriter{w: w, hash: sha1.New()}
+
+	header := &bytes.Buffer{}
+	header.Write([]byte("PACK"))
+	binary.Write(header, binary.BigEndian, uint32(packVersion))
+	binary.Write(header, binary.BigEndian, uint32(len(packObjects)))
+	_, err = pw.Write(header.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "writing pack header")
+	}
+
+	entries := make([]objects.PackIndexEntry, 0, len(packObjects))
+	for _, obj := range packObjects {
+		entry, err := pw.writeEntry(obj)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	checksum := pw.hash.Sum(nil)
+	_, err = w.Write(checksum)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing pack checksum")
+	}
+
+	return objects.NewPackIndex(entries, checksum), nil
+}
+
+// WritePackFiles writes a pack and its index as <base>-<checksum>.pack and
+// <base>-<checksum>.idx, returning the checksum.
+func WritePackFiles(base string, store objects.ObjectStore, revObjects []RevObject, opts *PackOptions) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(base), "tmp_pack_")
+	if err != nil {
+		return "", errors.Wrap(err, "creating temporary packfile")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	index, err := WritePack(tmp, store, revObjects, opts)
+	if err != nil {
+		return "", err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "closing temporary packfile")
+	}
+
+	return finishPackFiles(tmp.Name(), base, index)
+}
+
+// finishPackFiles writes the index for a completed pack and moves the pack
+// into place next to it.
+func finishPackFiles(tmpPath, base string, index *objects.PackIndex) (string, error) {
+	checksum := hex.EncodeToString(index.PackChecksum)
+	packPath := base + "-" + checksum + ".pack"
+
+	indexFile, err := os.Create(objects.IndexPathForPack(packPath))
+	if err != nil {
+		return "", errors.Wrap(err, "creating pack index")
+	}
+	defer indexFile.Close()
+
+	_, err = index.WriteTo(indexFile)
+	if err != nil {
+		return "", errors.Wrap(err, "writing pack index")
+	}
+
+	err = indexFile.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "closing pack index")
+	}
+
+	err = os.Rename(tmpPath, packPath)
+	if err != nil {
+		return "", errors.Wrap(err, "moving packfile into place")
+	}
+
+	return checksum, nil
+}
@@ -0,0 +1,173 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kisom/codecrafters/git-go/objects"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeDelta(t *testing.T) {
+	prng := rand.New(rand.NewSource(1))
+	base := make([]byte, 8192)
+	prng.Read(base)
+
+	target := append([]byte{}, base[:3000]...)
+	target = append(target, []byte("something new in the middle")...)
+	target = append(target, base[3100:]...)
+	target = append(target, base[:200]...)
+
+	delta := computeDelta(base, target)
+	if len(delta) > len(target)/10 {
+		t.Errorf("delta is too large: %d bytes for a %d byte target", len(delta), len(target))
+	}
+
+	result, err := objects.ApplyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(result, target) {
+		t.Fatal("delta doesn't reproduce the target")
+	}
+}
+
+// newTestStore creates an objects directory holding a history of commits,
+// each of which changes one line of a large file. It returns the
+// directory's store and the commit IDs, oldest first.
+func newTestStore(t *testing.T, commits int) (*objects.DirStore, []string) {
+	objectsDir := filepath.Join(t.TempDir(), ".git", "objects")
+	err := os.MkdirAll(filepath.Join(objectsDir, "pack"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := objects.NewDirStore(objectsDir)
+
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d of the test file\n", i)
+	}
+
+	var ids []string
+	parent := ""
+	for i := 0; i < commits; i++ {
+		lines[i*7%len(lines)] = fmt.Sprintf("line changed in commit %d\n", i)
+
+		contents := ""
+		for _, line := range lines {
+			contents += line
+		}
+
+		blob := objects.BlobFromBytes([]byte(contents))
+		writeTestObject(t, store, blob)
+
+		tree := &objects.Tree{}
+		tree.Add(&objects.TreeEntry{Hash: blob.Hash(), Name: "file.txt", Mode: objects.ModeRegular})
+		writeTestObject(t, store, tree)
+
+		commit := objects.NewCommitFromTree(tree.HashString(), parent, fmt.Sprintf("commit %d", i))
+		commit.Timestamp = time.Unix(int64(1700000000+i), 0)
+		writeTestObject(t, store, commit)
+
+		parent = commit.HashString()
+		ids = append(ids, parent)
+	}
+
+	return store, ids
+}
+
+type testObject interface {
+	Write(objects.ObjectStore) error
+}
+
+func writeTestObject(t *testing.T, store objects.ObjectStore, obj testObject) {
+	err := obj.Write(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevList(t *testing.T) {
+	store, commits := newTestStore(t, 5)
+
+	all, err := RevList(store, commits[4:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Five commits, each with its own tree and blob.
+	if len(all) != 15 {
+		t.Fatalf("expected 15 objects, have %d", len(all))
+	}
+
+	if all[0].ID != commits[4] {
+		t.Fatalf("expected the first object to be %s, have %s", commits[4], all[0].ID)
+	}
+
+	some, err := RevList(store, commits[4:], commits[2:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(some) != 6 {
+		t.Fatalf("expected 6 objects, have %d", len(some))
+	}
+}
+
+func TestWritePackFiles(t *testing.T) {
+	store, commits := newTestStore(t, 20)
+
+	revObjects, err := RevList(store, commits[len(commits)-1:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[int]int64{}
+	for _, window := range []int{0, DefaultWindow} {
+		base := filepath.Join(store.Dir, "pack", fmt.Sprintf("window%d", window))
+		checksum, err := WritePackFiles(base, store, revObjects, &PackOptions{Window: window, Depth: DefaultDepth})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pack, err := objects.OpenPackfile(base + "-" + checksum + ".pack")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pack.Close()
+
+		if pack.Index.Len() != len(revObjects) {
+			t.Fatalf("expected %d objects in pack, have %d", len(revObjects), pack.Index.Len())
+		}
+
+		for _, obj := range revObjects {
+			blob, err := store.Read(obj.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			packed, err := pack.ReadObject(blob.Hash(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if packed.HashString() != obj.ID {
+				t.Fatalf("packed object hash mismatch; have %s, want %s", packed.HashString(), obj.ID)
+			}
+		}
+
+		fi, err := os.Stat(pack.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[window] = fi.Size()
+	}
+
+	if sizes[DefaultWindow] >= sizes[0] {
+		t.Fatalf("deltified pack (%d bytes) isn't smaller than undeltified pack (%d bytes)", sizes[DefaultWindow], sizes[0])
+	}
+}
@@ -12,6 +12,15 @@ import (
 const ObjectIDLength = 40
 
 func PathFromID(id string) (string, error) {
+	objectsDir, err := ObjectsDir()
+	if err != nil {
+		return "", errors.Wrap(err, "while searching for git root trying to build PathFromID("+id+")")
+	}
+
+	return PathFromIDInDir(objectsDir, id)
+}
+
+func PathFromIDInDir(objectsDir, id string) (string, error) {
 	if len(id) != ObjectIDLength {
 		return "", fmt.Errorf("object ID `%s` want length %d, have %d", id, ObjectIDLength, len(id))
 	}
@@ -20,12 +29,51 @@ func PathFromID(id string) (string, error) {
 	subdir := id[:2]
 	base := id[2:]
 
+	return filepath.Join(objectsDir, subdir, base), nil
+}
+
+func GitDir() (string, error) {
 	parent, err := FindGitRoot()
 	if err != nil {
-		return "", errors.Wrap(err, "while searching for git root trying to build PathFromID("+id+")")
+		return "", err
 	}
 
-	return filepath.Join(parent, ".git", "objects", subdir, base), nil
+	return filepath.Join(parent, ".git"), nil
+}
+
+// FindGitDir returns the git directory of the repository at dir, which may
+// be a bare repository (possibly named with a .git suffix left off) or a
+// working tree.
+func FindGitDir(dir string) (string, bool) {
+	for _, candidate := range []string{dir, dir + ".git", filepath.Join(dir, ".git")} {
+		if isGitDir(candidate) {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+func isGitDir(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func ObjectsDir() (string, error) {
+	gitDir, err := GitDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(gitDir, "objects"), nil
+}
+
+func PackDir(objectsDir string) string {
+	return filepath.Join(objectsDir, "pack")
 }
 
 func PathForRef(ref string) (string, error) {
@@ -0,0 +1,324 @@
+package paths
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const symbolicRefPrefix = "ref: "
+
+// maxSymbolicRefDepth matches the limit git itself uses when following
+// symbolic references.
+const maxSymbolicRefDepth = 5
+
+// IsObjectID reports whether s is a full, lowercase hex object ID.
+func IsObjectID(s string) bool {
+	if len(s) != ObjectIDLength {
+		return false
+	}
+
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPseudoRef reports whether name is a top-level ref such as HEAD or
+// FETCH_HEAD, which are the only refs allowed outside refs/.
+func isPseudoRef(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidRefName reports whether name is a ref name git would accept, by the
+// rules of git check-ref-format: no empty, dot-led or .lock components, no
+// "..", no "@{", and no control characters, spaces, backslashes or any of
+// ~^:?*[. Names that pass can be joined to the git directory safely.
+func ValidRefName(name string) bool {
+	if !strings.Contains(name, "/") {
+		return isPseudoRef(name)
+	}
+
+	if strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func readPackedRefs(gitDir string) (map[string]string, error) {
+	refs := map[string]string{}
+
+	file, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, errors.Wrap(err, "opening packed-refs")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		id, name, ok := strings.Cut(line, " ")
+		if !ok || !IsObjectID(id) {
+			return nil, fmt.Errorf("invalid packed-refs line %q", line)
+		}
+		refs[name] = id
+	}
+
+	return refs, errors.Wrap(scanner.Err(), "reading packed-refs")
+}
+
+// readRefFile returns the contents of a loose ref, or the empty string if it
+// doesn't exist.
+func readRefFile(gitDir, name string) (string, error) {
+	contents, err := os.ReadFile(filepath.Join(gitDir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "reading ref "+name)
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
+
+func resolveRef(gitDir, name string, depth int) (string, error) {
+	if depth > maxSymbolicRefDepth {
+		return "", fmt.Errorf("symbolic ref %s nests too deeply", name)
+	}
+
+	value, err := readRefFile(gitDir, name)
+	if err != nil {
+		return "", err
+	}
+
+	if value == "" {
+		packed, err := readPackedRefs(gitDir)
+		if err != nil {
+			return "", err
+		}
+		value = packed[name]
+	}
+
+	switch {
+	case value == "":
+		return "", nil
+	case strings.HasPrefix(value, symbolicRefPrefix):
+		return resolveRef(gitDir, strings.TrimPrefix(value, symbolicRefPrefix), depth+1)
+	case IsObjectID(value):
+		return value, nil
+	}
+
+	return "", fmt.Errorf("ref %s has invalid contents %q", name, value)
+}
+
+// ReadRef resolves a revision name to an object ID. Names are looked up the
+// way git does: as given, then under refs/, refs/tags/, refs/heads/ and
+// refs/remotes/. Object IDs are returned as is.
+func ReadRef(gitDir, name string) (string, error) {
+	if IsObjectID(name) {
+		return name, nil
+	}
+
+	for _, candidate := range []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	} {
+		id, err := resolveRef(gitDir, candidate, 0)
+		if err != nil {
+			return "", err
+		}
+
+		if id != "" {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown revision %s", name)
+}
+
+func writeRefFile(gitDir, name, contents string) error {
+	if !ValidRefName(name) {
+		return fmt.Errorf("invalid ref name %q", name)
+	}
+
+	return writeGitFile(gitDir, name, contents)
+}
+
+// writeGitFile replaces a file in the git directory, such as a ref or
+// packed-refs.
+func writeGitFile(gitDir, name, contents string) error {
+	path := filepath.Join(gitDir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrap(err, "while writing "+name)
+	}
+
+	// Write to a lock file and rename it into place, so readers never see
+	// a partially written ref.
+	lock := path + ".lock"
+	err = os.WriteFile(lock, []byte(contents+"\n"), 0644)
+	if err != nil {
+		return errors.Wrap(err, "while writing "+name)
+	}
+
+	err = os.Rename(lock, path)
+	if err != nil {
+		os.Remove(lock)
+		return errors.Wrap(err, "while writing "+name)
+	}
+
+	return nil
+}
+
+// UpdateRef points the ref with the full name given (e.g. refs/heads/main)
+// at an object.
+func UpdateRef(gitDir, name, id string) error {
+	if !IsObjectID(id) {
+		return fmt.Errorf("can't point ref %s at invalid object ID %q", name, id)
+	}
+
+	return writeRefFile(gitDir, name, id)
+}
+
+// UpdateSymbolicRef makes name (usually HEAD) refer to another ref.
+func UpdateSymbolicRef(gitDir, name, target string) error {
+	if !ValidRefName(target) {
+		return fmt.Errorf("can't point ref %s at invalid ref name %q", name, target)
+	}
+
+	return writeRefFile(gitDir, name, symbolicRefPrefix+target)
+}
+
+// ListRefs returns every ref under refs/, loose or packed, mapped to the
+// object it points to. Symbolic refs are resolved.
+func ListRefs(gitDir string) (map[string]string, error) {
+	refs, err := readPackedRefs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(filepath.Join(gitDir, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(gitDir, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		id, err := resolveRef(gitDir, name, 0)
+		if err != nil {
+			return err
+		}
+
+		if id != "" {
+			refs[name] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing refs")
+	}
+
+	return refs, nil
+}
+
+// DeleteRef removes a ref, whether it's loose or packed.
+func DeleteRef(gitDir, name string) error {
+	if !ValidRefName(name) {
+		return fmt.Errorf("invalid ref name %q", name)
+	}
+
+	err := os.Remove(filepath.Join(gitDir, filepath.FromSlash(name)))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "deleting ref "+name)
+	}
+
+	packedPath := filepath.Join(gitDir, "packed-refs")
+	contents, err := os.ReadFile(packedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "reading packed-refs")
+	}
+
+	// Drop the ref's line, and the peeled line after it if there is one.
+	var kept []string
+	dropping := false
+	for _, line := range strings.SplitAfter(string(contents), "\n") {
+		if strings.HasPrefix(line, "^") && dropping {
+			continue
+		}
+
+		_, refName, _ := strings.Cut(strings.TrimSpace(line), " ")
+		dropping = refName == name && !strings.HasPrefix(line, "#")
+		if !dropping {
+			kept = append(kept, line)
+		}
+	}
+
+	return writeGitFile(gitDir, "packed-refs", strings.TrimSuffix(strings.Join(kept, ""), "\n"))
+}
+
+// ReadSymbolicRef returns the ref a symbolic ref such as HEAD points to,
+// or the empty string if it isn't symbolic.
+func ReadSymbolicRef(gitDir, name string) (string, error) {
+	value, err := readRefFile(gitDir, name)
+	if err != nil {
+		return "", err
+	}
+
+	target, _ := strings.CutPrefix(value, symbolicRefPrefix)
+	if target == value {
+		return "", nil
+	}
+	return target, nil
+}
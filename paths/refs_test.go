@@ -0,0 +1,92 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidRefName(t *testing.T) {
+	for _, name := range []string{
+		"HEAD",
+		"FETCH_HEAD",
+		"refs/heads/main",
+		"refs/heads/feature/x",
+		"refs/tags/v1.0",
+		"refs/remotes/origin/HEAD",
+	} {
+		if !ValidRefName(name) {
+			t.Errorf("expected %q to be valid", name)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		"head",
+		"packed-refs",
+		"/refs/heads/main",
+		"refs/heads/main/",
+		"refs//heads/main",
+		"refs/../../victim.txt",
+		"refs/heads/a..b",
+		"refs/heads/.hidden",
+		"refs/heads/main.lock",
+		"refs/heads/main.",
+		"refs/heads/a@{1}",
+		"refs/heads/a b",
+		"refs/heads/a\x01",
+		"refs/heads/a~1",
+		"refs/heads/a^",
+		"refs/heads/a:b",
+		"refs/heads/a?",
+		"refs/heads/a*",
+		"refs/heads/a[",
+		"refs/heads/..\\..\\x",
+	} {
+		if ValidRefName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestRefNamesStayInGitDir(t *testing.T) {
+	dir := t.TempDir()
+	gitDir := filepath.Join(dir, ".git")
+	victim := filepath.Join(dir, "victim.txt")
+	err := os.WriteFile(victim, []byte("keep me\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := "5360bfb1a8f0e8fcfba4f9d9e4ef2cf1e1b4c9d5"
+	if err = UpdateRef(gitDir, "refs/../../victim.txt", id); err == nil {
+		t.Fatal("expected updating a ref outside refs/ to fail")
+	}
+	if err = UpdateSymbolicRef(gitDir, "HEAD", "refs/../../victim.txt"); err == nil {
+		t.Fatal("expected pointing HEAD outside refs/ to fail")
+	}
+	if err = DeleteRef(gitDir, "refs/../../victim.txt"); err == nil {
+		t.Fatal("expected deleting a ref outside refs/ to fail")
+	}
+
+	contents, err := os.ReadFile(victim)
+	if err != nil || string(contents) != "keep me\n" {
+		t.Fatalf("victim file changed: %q, %v", contents, err)
+	}
+
+	err = UpdateRef(gitDir, "refs/heads/main", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := ReadRef(gitDir, "main"); err != nil || have != id {
+		t.Fatalf("expected main at %s, have %s (%v)", id, have, err)
+	}
+
+	err = DeleteRef(gitDir, "refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadRef(gitDir, "main"); err == nil {
+		t.Fatal("expected main to be deleted")
+	}
+}
@@ -0,0 +1,72 @@
+package paths
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Refspec maps refs on a remote to local refs, as in
+// "+refs/heads/*:refs/remotes/origin/*".
+type Refspec struct {
+	Force bool
+	Src   string
+	Dst   string
+}
+
+func ParseRefspec(spec string) (*Refspec, error) {
+	refspec := &Refspec{}
+	if rest, ok := strings.CutPrefix(spec, "+"); ok {
+		refspec.Force = true
+		spec = rest
+	}
+
+	src, dst, _ := strings.Cut(spec, ":")
+	refspec.Src = src
+	refspec.Dst = dst
+
+	if strings.Count(src, "*") > 1 || strings.Count(dst, "*") > 1 ||
+		strings.Contains(src, "*") != strings.Contains(dst, "*") && dst != "" {
+		return nil, fmt.Errorf("invalid refspec %q", spec)
+	}
+
+	return refspec, nil
+}
+
+func (refspec *Refspec) IsWildcard() bool {
+	return strings.Contains(refspec.Src, "*")
+}
+
+// Match checks whether a remote ref matches the source side of the refspec,
+// returning the local ref it maps to.
+func (refspec *Refspec) Match(name string) (string, bool) {
+	if !refspec.IsWildcard() {
+		return refspec.Dst, name == refspec.Src
+	}
+
+	prefix, suffix, _ := strings.Cut(refspec.Src, "*")
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+
+	matched := name[len(prefix) : len(name)-len(suffix)]
+	return strings.Replace(refspec.Dst, "*", matched, 1), true
+}
+
+// Prefix returns the fixed part of the source, which is what a server
+// needs to know to list the refs that might match.
+func (refspec *Refspec) Prefix() string {
+	prefix, _, _ := strings.Cut(refspec.Src, "*")
+	return prefix
+}
+
+func (refspec *Refspec) String() string {
+	spec := refspec.Src
+	if refspec.Dst != "" {
+		spec += ":" + refspec.Dst
+	}
+
+	if refspec.Force {
+		spec = "+" + spec
+	}
+	return spec
+}
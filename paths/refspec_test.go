@@ -0,0 +1,36 @@
+package paths
+
+import "testing"
+
+func TestRefspec(t *testing.T) {
+	refspec, err := ParseRefspec("+refs/heads/*:refs/remotes/origin/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !refspec.Force || refspec.Prefix() != "refs/heads/" {
+		t.Fatalf("refspec parsed incorrectly: %#v", refspec)
+	}
+
+	local, ok := refspec.Match("refs/heads/feature/x")
+	if !ok || local != "refs/remotes/origin/feature/x" {
+		t.Fatalf("expected refs/remotes/origin/feature/x, have %q (%v)", local, ok)
+	}
+
+	if _, ok = refspec.Match("refs/tags/v1"); ok {
+		t.Fatal("tag shouldn't match a branch refspec")
+	}
+
+	refspec, err = ParseRefspec("refs/heads/main:refs/heads/upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if local, ok = refspec.Match("refs/heads/main"); !ok || local != "refs/heads/upstream" {
+		t.Fatalf("expected refs/heads/upstream, have %q (%v)", local, ok)
+	}
+
+	if _, err = ParseRefspec("refs/heads/*:refs/remotes/origin/main"); err == nil {
+		t.Fatal("refspec with one wildcard should be invalid")
+	}
+}
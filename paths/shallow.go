@@ -0,0 +1,59 @@
+package paths
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const shallowFile = "shallow"
+
+// ReadShallow returns the commits listed in a shallow repository's
+// shallow file. Their parents aren't in the repository, so history stops
+// at them.
+func ReadShallow(gitDir string) (map[string]bool, error) {
+	shallow := map[string]bool{}
+	file, err := os.Open(filepath.Join(gitDir, shallowFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return shallow, nil
+		}
+		return nil, errors.Wrap(err, "opening shallow file")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if !IsObjectID(id) {
+			return nil, fmt.Errorf("invalid shallow file line %q", id)
+		}
+		shallow[id] = true
+	}
+
+	return shallow, errors.Wrap(scanner.Err(), "reading shallow file")
+}
+
+// WriteShallow replaces the shallow file. A repository with no shallow
+// commits has no shallow file.
+func WriteShallow(gitDir string, shallow map[string]bool) error {
+	if len(shallow) == 0 {
+		err := os.Remove(filepath.Join(gitDir, shallowFile))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "removing shallow file")
+		}
+		return nil
+	}
+
+	ids := make([]string, 0, len(shallow))
+	for id := range shallow {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return writeGitFile(gitDir, shallowFile, strings.Join(ids, "\n"))
+}
@@ -0,0 +1,78 @@
+package trace2
+
+import (
+	"git.wntrmute.dev/kyle/goutils/log"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dialSocket connects to the unix socket a trace goes to. Without a
+// socket type, a stream socket is tried, then a datagram socket.
+func dialSocket(spec string) (io.Writer, error) {
+	networks := []string{"unix", "unixgram"}
+	switch {
+	case strings.HasPrefix(spec, "stream:"):
+		networks, spec = networks[:1], strings.TrimPrefix(spec, "stream:")
+	case strings.HasPrefix(spec, "dgram:"):
+		networks, spec = networks[1:], strings.TrimPrefix(spec, "dgram:")
+	}
+
+	var err error
+	for _, network := range networks {
+		var conn net.Conn
+		conn, err = net.Dial(network, spec)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Open opens the destination a GIT_TRACE* variable names, as git does: 1,
+// 2 or true for standard error, a file descriptor from 3 to 9, a unix
+// socket given as af_unix:[stream:|dgram:]<path>, or an absolute path to
+// append to, which may also be a socket. Tracing is off, and Open returns
+// nil, for anything else.
+func Open(name, value string) io.Writer {
+	switch strings.ToLower(value) {
+	case "", "0", "false":
+		return nil
+	case "1", "2", "true":
+		return os.Stderr
+	}
+
+	if fd, err := strconv.Atoi(value); err == nil && fd >= 3 && fd <= 9 {
+		return os.NewFile(uintptr(fd), name)
+	}
+
+	socket, isSocket := strings.CutPrefix(value, "af_unix:")
+	if !isSocket {
+		if !filepath.IsAbs(value) {
+			log.Warnf("unknown trace value for %s: %s", name, value)
+			return nil
+		}
+
+		fi, err := os.Stat(value)
+		isSocket = err == nil && fi.Mode()&os.ModeSocket != 0
+	}
+
+	if isSocket {
+		w, err := dialSocket(socket)
+		if err != nil {
+			log.Warnf("could not connect to %s for tracing: %v", socket, err)
+			return nil
+		}
+		return w
+	}
+
+	file, err := os.OpenFile(value, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		log.Warnf("could not open %s for tracing: %v", value, err)
+		return nil
+	}
+	return file
+}
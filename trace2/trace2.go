@@ -0,0 +1,336 @@
+// Package trace2 records what a command does and how long it takes, as
+// the JSON events of git's trace2 event format, one to a line. Events go
+// to wherever GIT_TRACE2_EVENT names; without it, nothing is recorded and
+// each call costs next to nothing.
+package trace2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventVersion is the version of the event format written.
+const eventVersion = "3"
+
+// parentSIDVariable passes a session ID on to child processes, whose own
+// session IDs are nested under it.
+const parentSIDVariable = "GIT_TRACE2_PARENT_SID"
+
+type timerKey struct {
+	category, name string
+}
+
+type timerStats struct {
+	count           int
+	total, min, max time.Duration
+}
+
+var enabled atomic.Bool
+
+var tr struct {
+	sync.Mutex
+	w       io.Writer
+	sid     string
+	start   time.Time
+	nesting int
+	child   int
+	timers  map[timerKey]*timerStats
+	order   []timerKey
+}
+
+func sessionID() string {
+	sid := fmt.Sprintf("%s-P%08x", time.Now().UTC().Format("20060102T150405.000000Z"), os.Getpid())
+	if parent := os.Getenv(parentSIDVariable); parent != "" {
+		sid = parent + "/" + sid
+	}
+	return sid
+}
+
+// emit writes an event, with the fields every event has followed by the
+// name/value pairs given. The caller holds the lock.
+func emit(event string, fields ...any) {
+	now := time.Now()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"event":%q,"sid":%q,"thread":"main","time":%q`,
+		event, tr.sid, now.UTC().Format("2006-01-02T15:04:05.000000Z"))
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		value, err := json.Marshal(fields[i+1])
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		fmt.Fprintf(&buf, ",%q:%s", fields[i], value)
+	}
+	buf.WriteString("}\n")
+
+	// Each event is written at once, so events from processes sharing
+	// a file or socket don't interleave.
+	_, err := tr.w.Write(buf.Bytes())
+	if err != nil {
+		disable()
+	}
+}
+
+func disable() {
+	enabled.Store(false)
+	if closer, ok := tr.w.(io.Closer); ok && tr.w != os.Stderr {
+		closer.Close()
+	}
+	tr.w = nil
+}
+
+// Start begins tracing the command run with argv, if GIT_TRACE2_EVENT
+// asks for it. A directory there gets a file of its own for each
+// session.
+func Start(version string, argv []string) {
+	tr.Lock()
+	defer tr.Unlock()
+
+	tr.start = time.Now()
+	tr.sid = sessionID()
+
+	target := os.Getenv("GIT_TRACE2_EVENT")
+	if fi, err := os.Stat(target); err == nil && fi.IsDir() && filepath.IsAbs(target) {
+		target = filepath.Join(target, strings.ReplaceAll(tr.sid, "/", "_"))
+	}
+
+	tr.w = Open("GIT_TRACE2_EVENT", target)
+	if tr.w == nil {
+		return
+	}
+	enabled.Store(true)
+	tr.timers = map[timerKey]*timerStats{}
+	os.Setenv(parentSIDVariable, tr.sid)
+
+	emit("version", "evt", eventVersion, "exe", version)
+	emit("start", "t_abs", time.Since(tr.start).Seconds(), "argv", argv)
+}
+
+// CmdName records the name of the command being run.
+func CmdName(name string) {
+	if !enabled.Load() {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w != nil {
+		emit("cmd_name", "name", name, "hierarchy", name)
+	}
+}
+
+// Exit records the command's exit status, along with the timers, and
+// stops tracing. A command that exits without calling it only has its
+// start recorded.
+func Exit(code int) {
+	if !enabled.Load() {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w == nil {
+		return
+	}
+
+	for _, key := range tr.order {
+		stats := tr.timers[key]
+		emit("timer", "category", key.category, "name", key.name, "count", stats.count,
+			"t_total", stats.total.Seconds(), "t_min", stats.min.Seconds(), "t_max", stats.max.Seconds())
+	}
+
+	elapsed := time.Since(tr.start).Seconds()
+	emit("exit", "t_abs", elapsed, "code", code)
+	emit("atexit", "t_abs", elapsed, "code", code)
+	disable()
+}
+
+// ExitProcess records the exit status, as Exit does, then exits with it.
+// Commands exit through it rather than os.Exit so that every exit is
+// traced.
+func ExitProcess(code int) {
+	Exit(code)
+	os.Exit(code)
+}
+
+// DieIf prints err and exits with status 1 if it isn't nil, as die.If
+// does, recording the exit on the way out.
+func DieIf(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+		ExitProcess(1)
+	}
+}
+
+// Data records a value of interest, such as how many objects were
+// fetched.
+func Data(category, key string, value any) {
+	if !enabled.Load() {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w != nil {
+		emit("data", "t_abs", time.Since(tr.start).Seconds(), "nesting", tr.nesting,
+			"category", category, "key", key, "value", value)
+	}
+}
+
+// Region times a stretch of work, which may hold other regions.
+type Region struct {
+	category, label string
+	start           time.Time
+}
+
+// StartRegion records entering a region, which is left by calling End.
+func StartRegion(category, label string) *Region {
+	if !enabled.Load() {
+		return nil
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w == nil {
+		return nil
+	}
+
+	tr.nesting++
+	emit("region_enter", "nesting", tr.nesting, "category", category, "label", label)
+	return &Region{category: category, label: label, start: time.Now()}
+}
+
+func (r *Region) End() {
+	if r == nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w == nil {
+		return
+	}
+
+	emit("region_leave", "t_rel", time.Since(r.start).Seconds(), "nesting", tr.nesting,
+		"category", r.category, "label", r.label)
+	tr.nesting--
+}
+
+// Timer adds up the time spent in something done many times, such as
+// reading objects. Timers are recorded when the command exits.
+type Timer struct {
+	key   timerKey
+	start time.Time
+}
+
+func StartTimer(category, name string) *Timer {
+	if !enabled.Load() {
+		return nil
+	}
+	return &Timer{key: timerKey{category, name}, start: time.Now()}
+}
+
+func (t *Timer) Stop() {
+	if t == nil {
+		return
+	}
+	elapsed := time.Since(t.start)
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w == nil {
+		return
+	}
+
+	stats, ok := tr.timers[t.key]
+	if !ok {
+		stats = &timerStats{min: elapsed}
+		tr.timers[t.key] = stats
+		tr.order = append(tr.order, t.key)
+	}
+
+	stats.count++
+	stats.total += elapsed
+	stats.min = min(stats.min, elapsed)
+	stats.max = max(stats.max, elapsed)
+}
+
+// Child records a child process being run.
+type Child struct {
+	id    int
+	cmd   *exec.Cmd
+	start time.Time
+}
+
+// ChildStart records a child process about to be started. The class says
+// what it's for, such as "ssh" or "credential".
+func ChildStart(class string, cmd *exec.Cmd) *Child {
+	if !enabled.Load() {
+		return nil
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w == nil {
+		return nil
+	}
+
+	c := &Child{id: tr.child, cmd: cmd, start: time.Now()}
+	tr.child++
+	emit("child_start", "child_id", c.id, "child_class", class, "argv", cmd.Args)
+	return c
+}
+
+// Ready records a child process left running in the background, such as
+// a daemon, once it's ready.
+func (c *Child) Ready() {
+	if c == nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w == nil {
+		return
+	}
+
+	emit("child_ready", "child_id", c.id, "pid", c.cmd.Process.Pid, "ready", "ready",
+		"t_rel", time.Since(c.start).Seconds())
+}
+
+// Exit records the child process finishing, with the error from waiting
+// for it.
+func (c *Child) Exit(err error) {
+	if c == nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	if tr.w == nil {
+		return
+	}
+
+	pid, code := -1, 0
+	if c.cmd.Process != nil {
+		pid = c.cmd.Process.Pid
+	}
+
+	switch {
+	case c.cmd.ProcessState != nil:
+		code = c.cmd.ProcessState.ExitCode()
+	case err != nil:
+		code = -1
+	}
+
+	emit("child_exit", "child_id", c.id, "pid", pid, "code", code, "t_rel", time.Since(c.start).Seconds())
+}
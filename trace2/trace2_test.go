@@ -0,0 +1,170 @@
+package trace2
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func readEvents(t *testing.T, path string) []map[string]any {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []map[string]any
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		event := map[string]any{}
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func TestEvents(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GIT_TRACE2_EVENT", dir)
+	t.Setenv(parentSIDVariable, "parent")
+
+	Start("mygit/test", []string{"mygit", "clone"})
+	CmdName("clone")
+
+	region := StartRegion("clone", "fetch-pack")
+	for i := 0; i < 3; i++ {
+		StartTimer("objects", "read").Stop()
+	}
+	Data("clone", "refs", 2)
+	region.End()
+
+	cmd := exec.Command("true")
+	child := ChildStart("test", cmd)
+	child.Exit(cmd.Run())
+	Exit(0)
+
+	// Nothing is recorded once the command has exited.
+	Data("clone", "late", 1)
+
+	matches, err := filepath.Glob(filepath.Join(dir, "parent_*"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("expected a trace file for the session, have %v", matches)
+	}
+
+	events := readEvents(t, matches[0])
+	expected := []string{
+		"version", "start", "cmd_name", "region_enter", "data", "region_leave",
+		"child_start", "child_exit", "timer", "exit", "atexit",
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, have %d: %v", len(expected), len(events), events)
+	}
+
+	for i, event := range events {
+		if event["event"] != expected[i] {
+			t.Fatalf("expected event %d to be %s, have %v", i, expected[i], event)
+		}
+
+		if event["sid"] != events[0]["sid"] {
+			t.Fatalf("event %d has session %v, not %v", i, event["sid"], events[0]["sid"])
+		}
+	}
+
+	if events[4]["nesting"] != 1.0 || events[4]["value"] != 2.0 {
+		t.Fatalf("unexpected data event %v", events[4])
+	}
+
+	if events[8]["count"] != 3.0 || events[8]["name"] != "read" {
+		t.Fatalf("unexpected timer event %v", events[8])
+	}
+
+	if events[7]["code"] != 0.0 {
+		t.Fatalf("unexpected child exit event %v", events[7])
+	}
+}
+
+func TestOpenSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "trace.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+			received <- line
+		}
+	}()
+
+	for _, value := range []string{"af_unix:stream:" + socket, socket} {
+		w := Open("GIT_TRACE2_EVENT", value)
+		if w == nil {
+			t.Fatalf("couldn't open %s", value)
+		}
+
+		_, err = w.Write([]byte("event\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.(net.Conn).Close()
+
+		if line := <-received; line != "event\n" {
+			t.Fatalf("expected the event to be sent to %s, have %q", value, line)
+		}
+	}
+
+	if w := Open("GIT_TRACE2_EVENT", "relative/path"); w != nil {
+		t.Fatal("a relative path shouldn't be traced to")
+	}
+}
+
+// TestDieIfHelper isn't a real test: it's the command run by TestDieIf,
+// failing as a command would.
+func TestDieIfHelper(t *testing.T) {
+	if os.Getenv("TRACE2_TEST_DIE") == "" {
+		t.Skip("only run by TestDieIf")
+	}
+
+	Start("mygit/test", os.Args)
+	CmdName("fail")
+	DieIf(errors.New("failed"))
+}
+
+func TestDieIf(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDieIfHelper$")
+	cmd.Env = append(os.Environ(), "TRACE2_TEST_DIE=1", "GIT_TRACE2_EVENT="+dir, parentSIDVariable+"=")
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("expected the command to exit with status 1, have %v\n%s", err, out)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("expected a trace file for the session, have %v", matches)
+	}
+
+	events := readEvents(t, matches[0])
+	if len(events) < 2 || events[len(events)-2]["event"] != "exit" || events[len(events)-2]["code"] != 1.0 {
+		t.Fatalf("expected the exit to be recorded, have %v", events)
+	}
+}
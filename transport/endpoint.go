@@ -0,0 +1,213 @@
+// Package transport parses the ways git lets a remote repository be
+// named, and rewrites them as the url.<base>.insteadOf settings ask.
+package transport
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// The protocols a remote repository can be reached with.
+const (
+	ProtocolFile  = "file"
+	ProtocolGit   = "git"
+	ProtocolSSH   = "ssh"
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+)
+
+// Endpoint is a remote repository, and how to reach it. For the file
+// protocol, only Path is set.
+type Endpoint struct {
+	Protocol string
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Path     string
+}
+
+// parseSCP parses the [user@]host:path names scp uses for remote files,
+// which git takes to mean ssh. The host may be bracketed, as in
+// [::1]:path or [host:port]:path. A slash before the colon makes the name a
+// local path instead.
+func parseSCP(repo string) (*Endpoint, bool) {
+	start := 0
+	if open := strings.Index(repo, "["); open >= 0 && (open == 0 || repo[open-1] == '@') {
+		end := strings.Index(repo[open:], "]")
+		if end < 0 {
+			return nil, false
+		}
+		start = open + end
+	}
+
+	colon := strings.Index(repo[start:], ":")
+	if colon < 0 {
+		return nil, false
+	}
+	colon += start
+
+	host, repoPath := repo[:colon], repo[colon+1:]
+	if host == "" || strings.Contains(host, "/") {
+		return nil, false
+	}
+
+	endpoint := &Endpoint{Protocol: ProtocolSSH, Host: host, Path: repoPath}
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		endpoint.User, endpoint.Host = host[:at], host[at+1:]
+	}
+
+	if inner, ok := strings.CutPrefix(endpoint.Host, "["); ok && strings.HasSuffix(inner, "]") {
+		inner = strings.TrimSuffix(inner, "]")
+		endpoint.Host = inner
+		if host, port, err := net.SplitHostPort(inner); err == nil {
+			endpoint.Host, endpoint.Port = host, port
+		}
+	}
+
+	return endpoint, endpoint.Host != ""
+}
+
+// parseURL parses a name with a scheme.
+func parseURL(repo string) (*Endpoint, error) {
+	repoURL, err := url.Parse(repo)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing repository URL")
+	}
+
+	endpoint := &Endpoint{
+		Protocol: repoURL.Scheme,
+		Host:     repoURL.Hostname(),
+		Port:     repoURL.Port(),
+		Path:     repoURL.Path,
+	}
+
+	if repoURL.User != nil {
+		endpoint.User = repoURL.User.Username()
+		endpoint.Password, _ = repoURL.User.Password()
+	}
+
+	switch endpoint.Protocol {
+	case "git+ssh", "ssh+git":
+		endpoint.Protocol = ProtocolSSH
+	case ProtocolSSH, ProtocolGit, ProtocolHTTP, ProtocolHTTPS:
+	case ProtocolFile:
+		if endpoint.Host != "" && endpoint.Host != "localhost" {
+			return nil, fmt.Errorf("file URL %s names a remote host", repo)
+		}
+		return &Endpoint{Protocol: ProtocolFile, Path: endpoint.Path}, nil
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q in %s", repoURL.Scheme, repo)
+	}
+
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("no host in %s", repo)
+	}
+
+	// ssh://host/~user/repo is relative to user's home directory.
+	if endpoint.Protocol == ProtocolSSH && strings.HasPrefix(endpoint.Path, "/~") {
+		endpoint.Path = endpoint.Path[1:]
+	}
+
+	return endpoint, nil
+}
+
+// ParseEndpoint parses any of the names git accepts for a repository: a
+// URL for one of the supported protocols, an scp-style [user@]host:path,
+// or a local path.
+func ParseEndpoint(repo string) (*Endpoint, error) {
+	if repo == "" {
+		return nil, errors.New("empty repository URL")
+	}
+
+	if strings.Contains(repo, "://") {
+		endpoint, err := parseURL(repo)
+		if err != nil {
+			return nil, err
+		}
+		return endpoint, endpoint.check()
+	}
+
+	if endpoint, ok := parseSCP(repo); ok {
+		return endpoint, endpoint.check()
+	}
+
+	return &Endpoint{Protocol: ProtocolFile, Path: repo}, nil
+}
+
+// check refuses a remote endpoint whose host, user, port or path starts
+// with a dash, which the ssh command or a remote shell would take as an
+// option.
+func (e *Endpoint) check() error {
+	for _, part := range []struct{ name, value string }{
+		{"hostname", e.Host},
+		{"username", e.User},
+		{"port", e.Port},
+		{"pathname", e.Path},
+	} {
+		if strings.HasPrefix(part.value, "-") {
+			return fmt.Errorf("strange %s '%s' blocked", part.name, part.value)
+		}
+	}
+
+	return nil
+}
+
+// hostPort returns the host, with the port if there is one.
+func (e *Endpoint) hostPort() string {
+	if e.Port != "" {
+		return net.JoinHostPort(e.Host, e.Port)
+	}
+
+	if strings.Contains(e.Host, ":") {
+		return "[" + e.Host + "]"
+	}
+	return e.Host
+}
+
+// String returns the endpoint as a name git accepts. SSH endpoints with a
+// relative path are written scp-style, which is the only way to name
+// them.
+func (e *Endpoint) String() string {
+	if e.Protocol == ProtocolFile {
+		return e.Path
+	}
+
+	if e.Protocol == ProtocolSSH && !strings.HasPrefix(e.Path, "/") {
+		host := e.hostPort()
+		if e.Port != "" {
+			host = "[" + host + "]"
+		}
+
+		if e.User != "" {
+			host = e.User + "@" + host
+		}
+		return host + ":" + e.Path
+	}
+
+	u := &url.URL{Scheme: e.Protocol, Host: e.hostPort(), Path: e.Path}
+	switch {
+	case e.Password != "":
+		u.User = url.UserPassword(e.User, e.Password)
+	case e.User != "":
+		u.User = url.User(e.User)
+	}
+	return u.String()
+}
+
+// DefaultDir returns the directory git clones the repository into when
+// it isn't given one: the last part of the path, without .git, or the
+// host if there's no path.
+func (e *Endpoint) DefaultDir() string {
+	name := strings.TrimRight(e.Path, "/")
+	name = strings.TrimRight(strings.TrimSuffix(name, "/.git"), "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.TrimSuffix(name, ".git")
+	if name == "" {
+		return e.Host
+	}
+	return name
+}
@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"github.com/kisom/codecrafters/git-go/config"
+	"strings"
+)
+
+// longestRewrite finds the url.<base>.<key> value that's the longest
+// prefix of repo, returning repo with that prefix replaced by its base.
+func longestRewrite(cfg *config.Config, repo, key string) (string, bool) {
+	var base, prefix string
+	for _, candidate := range cfg.Subsections("url") {
+		for _, value := range cfg.GetAll("url." + candidate + "." + key) {
+			if strings.HasPrefix(repo, value) && len(value) > len(prefix) {
+				base, prefix = candidate, value
+			}
+		}
+	}
+
+	if prefix == "" {
+		return repo, false
+	}
+	return base + strings.TrimPrefix(repo, prefix), true
+}
+
+// RewriteURL applies the url.<base>.insteadOf settings to repo, replacing
+// the longest matching prefix with its base. URLs being pushed to are
+// rewritten with url.<base>.pushInsteadOf first, if it matches.
+func RewriteURL(cfg *config.Config, repo string, push bool) string {
+	if push {
+		if rewritten, ok := longestRewrite(cfg, repo, "pushInsteadOf"); ok {
+			return rewritten
+		}
+	}
+
+	rewritten, _ := longestRewrite(cfg, repo, "insteadOf")
+	return rewritten
+}